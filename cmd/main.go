@@ -1,13 +1,23 @@
 package main
 
 import (
+	"context"
+	"cruder/internal/auth"
 	"cruder/internal/controller"
 	"cruder/internal/handler"
+	"cruder/internal/mail"
 	"cruder/internal/middleware"
 	"cruder/internal/repository"
+	"cruder/internal/scheduler"
 	"cruder/internal/service"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -18,6 +28,13 @@ func main() {
 		dsn = "host=localhost port=5432 user=postgres password=postgres dbname=postgres sslmode=disable"
 	}
 
+	dbTimeout := durationFromEnv("DB_TIMEOUT", 5*time.Second)
+	purgeInterval := durationFromEnv("PURGE_INTERVAL", time.Hour)
+	purgeRetention := durationFromEnv("PURGE_RETENTION", 30*24*time.Hour)
+	apiKeyEventRetention := durationFromEnv("API_KEY_EVENT_RETENTION", 90*24*time.Hour)
+	idempotencyTTL := durationFromEnv("IDEMPOTENCY_TTL", 24*time.Hour)
+	shutdownTimeout := durationFromEnv("SHUTDOWN_TIMEOUT", 30*time.Second)
+
 	dbConn, err := repository.NewPostgresConnection(dsn)
 	if err != nil {
 		log.Fatalf("failed to connect to database: %v", err)
@@ -25,15 +42,256 @@ func main() {
 
 	repositories := repository.NewRepository(dbConn.DB())
 	services := service.NewService(repositories)
-	controllers := controller.NewController(services)
+	mailConfig := mailConfigFromEnv()
+	services.Passwords = service.NewPasswordService(repositories, passwordHasherFromEnv(), mailConfig)
+	services.EmailVerification = service.NewEmailVerificationService(repositories, mailConfig)
+	services.MFA = service.NewMFAService(repositories, mfaKeysFromEnv(), os.Getenv("MFA_ISSUER"))
+
+	// Bearer tokens are accepted from an external identity provider, from
+	// the built-in token endpoint, or both.
+	var verifiers auth.Verifiers
+	if verifier := jwtVerifierFromEnv(); verifier != nil {
+		verifiers = append(verifiers, verifier)
+	}
+	var userTokenIssuer string
+	if tokens, verifier := tokenServiceFromEnv(repositories); tokens != nil {
+		services.Tokens = tokens
+		verifiers = append(verifiers, verifier)
+		userTokenIssuer = verifier.Issuer()
+	}
+	var bearerAuth gin.HandlerFunc
+	if len(verifiers) > 0 {
+		bearerAuth = middleware.BearerAuthMiddleware(verifiers)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var backgroundJobs []scheduler.Job
+	if purgeInterval > 0 {
+		backgroundJobs = append(backgroundJobs,
+			scheduler.PurgeDeletedUsers(services.Users, purgeInterval, purgeRetention),
+			scheduler.PurgeIdempotencyKeys(repositories.Idempotency, purgeInterval),
+			scheduler.PurgeAPIKeyEvents(services.APIKeys, purgeInterval, apiKeyEventRetention),
+			scheduler.PurgeRefreshTokens(repositories.OAuth, purgeInterval),
+			scheduler.PurgeUserTokens(repositories.UserTokens, purgeInterval),
+			scheduler.FailStaleImports(services.Imports, purgeInterval),
+		)
+	}
+	jobs := scheduler.New(repositories.Jobs, backgroundJobs...)
+	jobs.Start(ctx)
+
+	controllers := controller.NewController(services, jobs)
 	r := gin.Default()
 
+	// Client IPs are recorded in the audit log and API key events, so
+	// X-Forwarded-For is only believed from configured proxies.
+	if err := r.SetTrustedProxies(trustedProxiesFromEnv()); err != nil {
+		log.Fatalf("invalid TRUSTED_PROXIES: %v", err)
+	}
+
+	r.Use(middleware.RequestIDMiddleware())
+
 	r.Use(middleware.JSONLoggingMiddleware())
 
-	r.Use(middleware.APIKeyAuthMiddleware())
+	// Exports stream for as long as the result takes; they stop when the
+	// client disconnects.
+	r.Use(middleware.RequestTimeoutMiddleware(dbTimeout, "/api/v1/users/export"))
+
+	handler.New(r, controllers, handler.Middleware{
+		BearerAuth:  bearerAuth,
+		APIKeyAuth:  middleware.APIKeyAuthMiddleware(services.APIKeys, authDisabledFromEnv()),
+		Audit:       middleware.AuditMiddleware(),
+		Idempotency: middleware.IdempotencyMiddleware(repositories.Idempotency, idempotencyTTL),
+
+		UserTokenIssuer: userTokenIssuer,
+	})
+
+	addr := ":8080"
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
+	}
+	srv := &http.Server{Addr: addr, Handler: r, ReadHeaderTimeout: 10 * time.Second}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.ListenAndServe() }()
 
-	handler.New(r, controllers.Users)
-	if err := r.Run(); err != nil {
+	select {
+	case err := <-serveErr:
 		log.Fatalf("failed to run server: %v", err)
+	case <-ctx.Done():
+	}
+
+	// Requests in flight finish first, then background imports are stopped
+	// and marked failed.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("failed to shut down server: %v", err)
+	}
+	if err := services.Imports.Shutdown(shutdownCtx); err != nil {
+		log.Printf("failed to stop imports: %v", err)
+	}
+}
+
+// jwtVerifierFromEnv configures the verification of tokens from an external
+// identity provider with JWT_JWKS_URL or JWT_JWKS_FILE, JWT_ISSUER and
+// JWT_AUDIENCE. It returns nil when no key set is configured.
+func jwtVerifierFromEnv() *auth.Verifier {
+	jwksURL, jwksFile := os.Getenv("JWT_JWKS_URL"), os.Getenv("JWT_JWKS_FILE")
+	issuer, audience := os.Getenv("JWT_ISSUER"), os.Getenv("JWT_AUDIENCE")
+
+	var keys *auth.KeySet
+	switch {
+	case jwksURL != "" && jwksFile != "":
+		log.Fatal("set only one of JWT_JWKS_URL and JWT_JWKS_FILE")
+	case jwksURL != "":
+		keys = auth.NewRemoteKeySet(jwksURL, &http.Client{Timeout: 10 * time.Second}, auth.DefaultRefreshInterval)
+	case jwksFile != "":
+		keys = auth.NewFileKeySet(jwksFile, auth.DefaultRefreshInterval)
+	default:
+		return nil
+	}
+	// Without both checks a token minted for another service would be accepted.
+	if issuer == "" || audience == "" {
+		log.Fatal("JWT_ISSUER and JWT_AUDIENCE are required with a JWKS")
+	}
+	return auth.NewVerifier(keys, issuer, audience)
+}
+
+// tokenServiceFromEnv configures the token endpoint with the signing keys in
+// OAUTH_SIGNING_KEY_FILE and OAUTH_ISSUER, and returns the verifier for the
+// tokens it issues. It returns nil when no signing key is configured.
+func tokenServiceFromEnv(repositories *repository.Repository) (service.TokenService, *auth.Verifier) {
+	keyFile, issuer := os.Getenv("OAUTH_SIGNING_KEY_FILE"), os.Getenv("OAUTH_ISSUER")
+	if keyFile == "" {
+		log.Print("OAUTH_SIGNING_KEY_FILE is not set, the token endpoint and password login are disabled")
+		return nil, nil
+	}
+	if issuer == "" {
+		log.Fatal("OAUTH_ISSUER is required with OAUTH_SIGNING_KEY_FILE")
+	}
+	if issuer == os.Getenv("JWT_ISSUER") {
+		log.Fatal("OAUTH_ISSUER must differ from JWT_ISSUER")
+	}
+	keys, err := auth.LoadSigningKeys(keyFile)
+	if err != nil {
+		log.Fatalf("failed to load OAuth signing keys: %v", err)
+	}
+
+	tokens := service.NewTokenService(repositories, keys, service.TokenConfig{
+		Issuer:          issuer,
+		AccessTokenTTL:  durationFromEnv("OAUTH_ACCESS_TOKEN_TTL", service.DefaultAccessTokenTTL),
+		RefreshTokenTTL: durationFromEnv("OAUTH_REFRESH_TOKEN_TTL", service.DefaultRefreshTokenTTL),
+	})
+	return tokens, auth.NewVerifier(keys, issuer, issuer)
+}
+
+// passwordHasherFromEnv hashes passwords with the argon2id parameters in
+// PASSWORD_HASH_PARAMS, e.g. "m=65536,t=3,p=4", or the defaults.
+func passwordHasherFromEnv() *auth.PasswordHasher {
+	params := auth.DefaultArgon2Params
+	if v := os.Getenv("PASSWORD_HASH_PARAMS"); v != "" {
+		var err error
+		if params, err = auth.ParseArgon2Params(v); err != nil {
+			log.Fatalf("invalid PASSWORD_HASH_PARAMS: %v", err)
+		}
+	}
+	return auth.NewPasswordHasher(params)
+}
+
+// mfaKeysFromEnv reads the keys that encrypt TOTP secrets from
+// MFA_ENCRYPTION_KEYS: comma separated, base64 encoded 32-byte keys, the
+// first of which encrypts. Without them, users cannot enroll.
+func mfaKeysFromEnv() *auth.SecretBox {
+	v := os.Getenv("MFA_ENCRYPTION_KEYS")
+	if v == "" {
+		log.Print("MFA_ENCRYPTION_KEYS is not set, MFA is disabled")
+		return nil
+	}
+	keys, err := auth.ParseSecretBoxKeys(v)
+	if err != nil {
+		log.Fatalf("invalid MFA_ENCRYPTION_KEYS: %v", err)
+	}
+	return keys
+}
+
+// mailConfigFromEnv configures the emails of the account flows. They are
+// sent through the relay in MAIL_SMTP_ADDR, or written to MAIL_FILE ("-"
+// for stdout, the default).
+func mailConfigFromEnv() service.MailConfig {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = service.DefaultMailFrom
+	}
+	config := service.MailConfig{
+		VerifyEmailURL:   os.Getenv("MAIL_VERIFY_EMAIL_URL"),
+		ResetPasswordURL: os.Getenv("MAIL_RESET_PASSWORD_URL"),
+		VerificationTTL:  durationFromEnv("EMAIL_VERIFICATION_TTL", service.DefaultVerificationTTL),
+		ResetTTL:         durationFromEnv("PASSWORD_RESET_TTL", service.DefaultResetTTL),
+	}
+
+	smtpAddr, file := os.Getenv("MAIL_SMTP_ADDR"), os.Getenv("MAIL_FILE")
+	switch {
+	case smtpAddr != "" && file != "":
+		log.Fatal("set only one of MAIL_SMTP_ADDR and MAIL_FILE")
+	case smtpAddr != "":
+		mailer, err := mail.NewSMTPMailer(smtpAddr, from, os.Getenv("MAIL_SMTP_USERNAME"), os.Getenv("MAIL_SMTP_PASSWORD"))
+		if err != nil {
+			log.Fatalf("invalid MAIL_SMTP_ADDR: %v", err)
+		}
+		config.Mailer = mailer
+	case file != "" && file != "-":
+		mailer, err := mail.NewFileMailer(file, from)
+		if err != nil {
+			log.Fatalf("failed to open MAIL_FILE: %v", err)
+		}
+		config.Mailer = mailer
+	default:
+		log.Print("MAIL_SMTP_ADDR is not set, emails are written to stdout")
+		config.Mailer = mail.NewWriterMailer(os.Stdout, from)
+	}
+	return config
+}
+
+// trustedProxiesFromEnv reads the comma separated IPs and CIDRs of the
+// reverse proxies in TRUSTED_PROXIES. Without it, no proxy is trusted and
+// the client IP is the address of the connection.
+func trustedProxiesFromEnv() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// authDisabledFromEnv reports whether AUTH_DISABLED lets requests without
+// credentials through, for local development only.
+func authDisabledFromEnv() bool {
+	value := os.Getenv("AUTH_DISABLED")
+	if value == "" {
+		return false
+	}
+	disabled, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("invalid AUTH_DISABLED: %v", err)
+	}
+	if disabled {
+		log.Print("AUTH_DISABLED is set, requests without credentials have every scope")
+	}
+	return disabled
+}
+
+// durationFromEnv reads a time.ParseDuration value from the environment.
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Fatalf("invalid %s: %q", key, v)
 	}
+	return d
 }
@@ -0,0 +1,32 @@
+// Package audit carries the origin of a request (who made it and from
+// where) through the context so that it can be stored with audit records.
+package audit
+
+import "context"
+
+// SystemActor is recorded for changes made outside of an HTTP request,
+// such as background jobs.
+const SystemActor = "system"
+
+// Source identifies who made a change and from where.
+type Source struct {
+	Actor     string
+	RequestID string
+	IP        string
+}
+
+type sourceKey struct{}
+
+func WithSource(ctx context.Context, source Source) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+// SourceFrom returns the source stored in ctx. Without one, the change is
+// attributed to SystemActor.
+func SourceFrom(ctx context.Context) Source {
+	source, ok := ctx.Value(sourceKey{}).(Source)
+	if !ok || source.Actor == "" {
+		source.Actor = SystemActor
+	}
+	return source
+}
@@ -0,0 +1,321 @@
+// Package auth verifies JSON Web Tokens against keys published as a JSON
+// Web Key Set (RFC 7517), signs the tokens this service issues, hashes
+// user passwords and implements TOTP second factors.
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Supported signing algorithms. Each key type verifies exactly one of them:
+// oct keys HS256, RSA keys RS256 and P-256 EC keys ES256.
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+)
+
+const (
+	// DefaultRefreshInterval is how long a fetched key set is used before it
+	// is fetched again.
+	DefaultRefreshInterval = time.Hour
+
+	// minRefreshInterval limits refetches, so that neither tokens with
+	// made-up key ids nor an unreachable key source turn every request into
+	// a fetch.
+	minRefreshInterval = time.Minute
+
+	minRSAKeyBits = 2048
+	maxJWKSBytes  = 1 << 20
+)
+
+var (
+	ErrUnknownKey = errors.New("unknown signing key")
+	ErrKeyType    = errors.New("signing key does not match the token algorithm")
+)
+
+// KeySource resolves the key that verifies a token signed with alg by the
+// key with id kid, which may be empty.
+type KeySource interface {
+	Key(ctx context.Context, kid, alg string) (any, error)
+}
+
+// jsonWebKey holds the members of the key types that are supported.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	K   string `json:"k,omitempty"`
+}
+
+// verificationKey is a parsed key and the algorithm it verifies.
+type verificationKey struct {
+	id  string
+	alg string
+	key any
+}
+
+// parseJWKS parses a key set. Keys for other uses or algorithms, and of
+// unsupported types, are skipped; malformed keys are an error.
+func parseJWKS(data []byte) ([]verificationKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	keys := make([]verificationKey, 0, len(set.Keys))
+	for i, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, alg, err := jwk.parse()
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS key %d (%q): %w", i, jwk.Kid, err)
+		}
+		if key == nil || (jwk.Alg != "" && jwk.Alg != alg) {
+			continue
+		}
+		keys = append(keys, verificationKey{id: jwk.Kid, alg: alg, key: key})
+	}
+	return keys, nil
+}
+
+// parse returns the public key, or the secret for oct keys, and the
+// algorithm it is used with. Unsupported key types return a nil key.
+func (k *jsonWebKey) parse() (any, string, error) {
+	switch k.Kty {
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil || len(secret) < 32 {
+			return nil, "", errors.New("k must be at least 32 base64url encoded bytes")
+		}
+		return secret, AlgHS256, nil
+
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, "", errors.New("n is not base64url encoded")
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, "", errors.New("e is not a valid exponent")
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if key.N.BitLen() < minRSAKeyBits {
+			return nil, "", fmt.Errorf("RSA keys must have at least %d bits", minRSAKeyBits)
+		}
+		return key, AlgRS256, nil
+
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, "", nil
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		if errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+			return nil, "", errors.New("x and y must be 32 base64url encoded bytes")
+		}
+		point := append(append([]byte{4}, x...), y...)
+		key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), point)
+		if err != nil {
+			return nil, "", err
+		}
+		return key, AlgES256, nil
+	}
+	return nil, "", nil
+}
+
+// KeySet is a KeySource backed by a JWKS document. The document is fetched
+// on first use, again after the refresh interval, and early when a token
+// names a key id it does not know, which is how new keys are picked up
+// during a rotation. If a refresh fails, the previous keys stay in use.
+// Fetches happen one at a time and at most once per minRefreshInterval,
+// also while the document has never been fetched.
+type KeySet struct {
+	fetch           func(ctx context.Context) ([]byte, error)
+	refreshInterval time.Duration
+
+	mu        sync.Mutex
+	keys      []verificationKey
+	fetchedAt time.Time
+	triedAt   time.Time
+	lastErr   error
+	inflight  *keySetRefresh
+}
+
+// keySetRefresh is a fetch in progress; done is closed when err is set.
+type keySetRefresh struct {
+	done chan struct{}
+	err  error
+}
+
+// NewFileKeySet reads the key set from a local file.
+func NewFileKeySet(path string, refreshInterval time.Duration) *KeySet {
+	return newKeySet(func(context.Context) ([]byte, error) {
+		f, err := os.Open(path) // #nosec G304 -- the path is operator configuration
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return io.ReadAll(io.LimitReader(f, maxJWKSBytes))
+	}, refreshInterval)
+}
+
+// NewRemoteKeySet fetches the key set from url.
+func NewRemoteKeySet(url string, client *http.Client, refreshInterval time.Duration) *KeySet {
+	return newKeySet(func(ctx context.Context) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetching JWKS: %s", resp.Status)
+		}
+		return io.ReadAll(io.LimitReader(resp.Body, maxJWKSBytes))
+	}, refreshInterval)
+}
+
+func newKeySet(fetch func(ctx context.Context) ([]byte, error), refreshInterval time.Duration) *KeySet {
+	if refreshInterval <= 0 {
+		refreshInterval = DefaultRefreshInterval
+	}
+	return &KeySet{fetch: fetch, refreshInterval: refreshInterval}
+}
+
+// Key implements KeySource. A token without a key id is accepted only if the
+// set holds exactly one key for its algorithm.
+func (s *KeySet) Key(ctx context.Context, kid, alg string) (any, error) {
+	keys, fetchedAt := s.current()
+	switch {
+	case fetchedAt.IsZero():
+		if err := s.refresh(ctx, true); err != nil {
+			return nil, err
+		}
+		keys, _ = s.current()
+	case time.Since(fetchedAt) >= s.refreshInterval:
+		// Tokens are verified with the previous keys rather than wait for
+		// a refresh that is already running, or that failed.
+		if s.refresh(ctx, false) == nil {
+			keys, _ = s.current()
+		}
+	}
+
+	key, err := lookupKey(keys, kid, alg)
+	if errors.Is(err, ErrUnknownKey) && s.refresh(ctx, true) == nil {
+		keys, _ = s.current()
+		key, err = lookupKey(keys, kid, alg)
+	}
+	return key, err
+}
+
+func (s *KeySet) current() ([]verificationKey, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys, s.fetchedAt
+}
+
+// refresh fetches the key set, without holding s.mu so that tokens can be
+// verified meanwhile. Within minRefreshInterval of the last try it returns
+// that try's error instead. A caller that finds a fetch running waits for
+// it if wait is set, and otherwise returns at once.
+func (s *KeySet) refresh(ctx context.Context, wait bool) error {
+	s.mu.Lock()
+	if call := s.inflight; call != nil {
+		s.mu.Unlock()
+		if !wait {
+			return nil
+		}
+		select {
+		case <-call.done:
+			return call.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	now := time.Now()
+	if now.Sub(s.triedAt) < minRefreshInterval {
+		err := s.lastErr
+		s.mu.Unlock()
+		return err
+	}
+	call := &keySetRefresh{done: make(chan struct{})}
+	s.inflight = call
+	s.triedAt = now
+	s.mu.Unlock()
+
+	keys, err := s.load(ctx)
+
+	s.mu.Lock()
+	if err == nil {
+		s.keys = keys
+		s.fetchedAt = now
+	}
+	s.lastErr = err
+	s.inflight = nil
+	s.mu.Unlock()
+	call.err = err
+	close(call.done)
+	return err
+}
+
+func (s *KeySet) load(ctx context.Context) ([]verificationKey, error) {
+	data, err := s.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return parseJWKS(data)
+}
+
+// lookupKey finds the key with id kid, or the only key for alg if kid is
+// empty.
+func lookupKey(keys []verificationKey, kid, alg string) (any, error) {
+	var match *verificationKey
+	for i := range keys {
+		k := &keys[i]
+		if kid != "" && k.id != kid {
+			continue
+		}
+		if kid == "" && k.alg != alg {
+			continue
+		}
+		if match != nil {
+			// Only possible without a key id: the choice is ambiguous.
+			return nil, ErrUnknownKey
+		}
+		match = k
+	}
+	if match == nil {
+		return nil, ErrUnknownKey
+	}
+	if match.alg != alg {
+		return nil, ErrKeyType
+	}
+	return match.key, nil
+}
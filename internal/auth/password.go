@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2Params are the argon2id cost parameters (RFC 9106). Memory is in
+// KiB.
+type Argon2Params struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+}
+
+// DefaultArgon2Params follow the second recommended option of RFC 9106
+// section 4: 64 MiB of memory and three passes.
+var DefaultArgon2Params = Argon2Params{Memory: 64 * 1024, Time: 3, Threads: 4}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+
+	minArgon2Memory = 8 * 1024
+)
+
+var ErrMalformedHash = errors.New("malformed password hash")
+
+// ParseArgon2Params parses parameters in the form they take in a hash,
+// e.g. "m=65536,t=3,p=4".
+func ParseArgon2Params(s string) (Argon2Params, error) {
+	var p Argon2Params
+	if _, err := fmt.Sscanf(s, "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, fmt.Errorf("argon2 parameters must look like m=65536,t=3,p=4: %w", err)
+	}
+	if p.Memory < minArgon2Memory || p.Time < 1 || p.Threads < 1 {
+		return p, fmt.Errorf("argon2 parameters need m >= %d, t >= 1 and p >= 1", minArgon2Memory)
+	}
+	return p, nil
+}
+
+func (p Argon2Params) String() string {
+	return fmt.Sprintf("m=%d,t=%d,p=%d", p.Memory, p.Time, p.Threads)
+}
+
+// PasswordHasher hashes passwords with argon2id. Since every hash takes
+// Params.Memory, it runs at most GOMAXPROCS of them at a time; the others
+// wait or give up when their context ends.
+type PasswordHasher struct {
+	params Argon2Params
+	slots  chan struct{}
+}
+
+func NewPasswordHasher(params Argon2Params) *PasswordHasher {
+	return &PasswordHasher{params: params, slots: make(chan struct{}, runtime.GOMAXPROCS(0))}
+}
+
+// Hash returns password hashed with a random salt in the PHC string format,
+// e.g. "$argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>".
+func (h *PasswordHasher) Hash(ctx context.Context, password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := h.derive(ctx, password, salt, h.params, argon2KeyLength)
+	if err != nil {
+		return "", err
+	}
+	b64 := base64.RawStdEncoding.EncodeToString
+	return fmt.Sprintf("$argon2id$v=%d$%s$%s$%s", argon2.Version, h.params, b64(salt), b64(key)), nil
+}
+
+// Verify reports whether password matches encoded, and whether encoded was
+// made with other parameters than the current ones and should be replaced
+// with a new hash.
+func (h *PasswordHasher) Verify(ctx context.Context, password, encoded string) (ok, rehash bool, err error) {
+	params, salt, key, err := decodeHash(encoded)
+	if err != nil {
+		return false, false, err
+	}
+	derived, err := h.derive(ctx, password, salt, params, uint32(len(key))) // #nosec G115 -- decodeHash bounds the key length
+	if err != nil {
+		return false, false, err
+	}
+	if subtle.ConstantTimeCompare(derived, key) != 1 {
+		return false, false, nil
+	}
+	return true, params != h.params || len(salt) != argon2SaltLength || len(key) != argon2KeyLength, nil
+}
+
+func (h *PasswordHasher) derive(ctx context.Context, password string, salt []byte, p Argon2Params, keyLength uint32) ([]byte, error) {
+	select {
+	case h.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-h.slots }()
+	return argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, keyLength), nil
+}
+
+func decodeHash(encoded string) (Argon2Params, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return Argon2Params{}, nil, nil, ErrMalformedHash
+	}
+	params, err := ParseArgon2Params(parts[3])
+	if err != nil {
+		return params, nil, nil, ErrMalformedHash
+	}
+	salt, errSalt := base64.RawStdEncoding.DecodeString(parts[4])
+	key, errKey := base64.RawStdEncoding.DecodeString(parts[5])
+	if errSalt != nil || errKey != nil || len(salt) < 8 || len(key) < 16 || len(key) > 64 {
+		return params, nil, nil, ErrMalformedHash
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+var testArgon2Params = Argon2Params{Memory: 8 * 1024, Time: 1, Threads: 1}
+
+func TestPasswordHasher(t *testing.T) {
+	ctx := context.Background()
+	hasher := NewPasswordHasher(testArgon2Params)
+	hash, err := hasher.Hash(ctx, "correct horse battery")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=8192,t=1,p=1$") {
+		t.Errorf("expected a PHC string, got %q", hash)
+	}
+	if other, _ := hasher.Hash(ctx, "correct horse battery"); other == hash {
+		t.Error("expected a random salt")
+	}
+
+	if ok, rehash, err := hasher.Verify(ctx, "correct horse battery", hash); !ok || rehash || err != nil {
+		t.Errorf("expected a match without rehash, got %v, %v, %v", ok, rehash, err)
+	}
+	if ok, _, err := hasher.Verify(ctx, "wrong horse battery", hash); ok || err != nil {
+		t.Errorf("expected a mismatch, got %v, %v", ok, err)
+	}
+
+	// Hashes made before the parameters were raised still verify, but
+	// should be replaced.
+	stronger := NewPasswordHasher(Argon2Params{Memory: 16 * 1024, Time: 2, Threads: 1})
+	if ok, rehash, err := stronger.Verify(ctx, "correct horse battery", hash); !ok || !rehash || err != nil {
+		t.Errorf("expected a match with rehash, got %v, %v, %v", ok, rehash, err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	full := &PasswordHasher{params: testArgon2Params, slots: make(chan struct{})}
+	if _, err := full.Hash(ctx, "correct horse battery"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected waiting for a slot to end with the context, got %v", err)
+	}
+}
+
+func TestPasswordHasher_MalformedHash(t *testing.T) {
+	hasher := NewPasswordHasher(testArgon2Params)
+	for _, hash := range []string{
+		"",
+		"$2a$10$abcdefghijklmnopqrstuv",
+		"$argon2i$v=19$m=8192,t=1,p=1$c29tZXNhbHRzb21lc2FsdA$ZGF0YWRhdGFkYXRhZGF0YWRhdGE",
+		"$argon2id$v=16$m=8192,t=1,p=1$c29tZXNhbHRzb21lc2FsdA$ZGF0YWRhdGFkYXRhZGF0YWRhdGE",
+		"$argon2id$v=19$m=1,t=1,p=1$c29tZXNhbHRzb21lc2FsdA$ZGF0YWRhdGFkYXRhZGF0YWRhdGE",
+		"$argon2id$v=19$m=8192,t=1,p=1$!!!$ZGF0YWRhdGFkYXRhZGF0YWRhdGE",
+	} {
+		if _, _, err := hasher.Verify(context.Background(), "password", hash); !errors.Is(err, ErrMalformedHash) {
+			t.Errorf("%q: expected ErrMalformedHash, got %v", hash, err)
+		}
+	}
+}
+
+func TestParseArgon2Params(t *testing.T) {
+	params, err := ParseArgon2Params("m=65536,t=3,p=4")
+	if err != nil || params != DefaultArgon2Params {
+		t.Errorf("expected the default parameters, got %+v, %v", params, err)
+	}
+	for _, s := range []string{"", "65536,3,4", "m=1024,t=3,p=4", "m=65536,t=0,p=4", "m=65536,t=3,p=0"} {
+		if _, err := ParseArgon2Params(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
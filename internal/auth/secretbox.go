@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const secretBoxKeyBytes = 32
+
+var ErrDecrypt = errors.New("secret cannot be decrypted with any key")
+
+// SecretBox encrypts small secrets, such as TOTP secrets, with AES-256-GCM.
+// The first key encrypts and every key decrypts, so that a new key can be
+// put first while secrets encrypted with the old one are still read.
+type SecretBox struct {
+	aeads []cipher.AEAD
+}
+
+// ParseSecretBoxKeys parses comma separated, base64 encoded 256-bit keys.
+func ParseSecretBoxKeys(s string) (*SecretBox, error) {
+	box := &SecretBox{}
+	for i, encoded := range strings.Split(s, ",") {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil || len(key) != secretBoxKeyBytes {
+			return nil, fmt.Errorf("key %d must be %d base64 encoded bytes", i+1, secretBoxKeyBytes)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		box.aeads = append(box.aeads, aead)
+	}
+	return box, nil
+}
+
+// Seal encrypts plaintext with the first key. additionalData is
+// authenticated but not encrypted; it binds the ciphertext to its owner so
+// that it cannot be moved to another row.
+func (b *SecretBox) Seal(plaintext, additionalData []byte) ([]byte, error) {
+	aead := b.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// Open decrypts a ciphertext made by Seal with any of the keys. current
+// reports whether it was made with the first key; if not, the secret
+// should be sealed again.
+func (b *SecretBox) Open(ciphertext, additionalData []byte) (plaintext []byte, current bool, err error) {
+	for i, aead := range b.aeads {
+		if len(ciphertext) < aead.NonceSize() {
+			break
+		}
+		nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+		if plaintext, err := aead.Open(nil, nonce, sealed, additionalData); err == nil {
+			return plaintext, i == 0, nil
+		}
+	}
+	return nil, false, ErrDecrypt
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SigningKeys signs tokens with the first of its keys and publishes the
+// public half of all of them, so that tokens signed before a key rotation
+// stay verifiable. It is a KeySource for the tokens it signs.
+type SigningKeys struct {
+	signer signingKey
+	public []verificationKey
+	keySet []byte
+}
+
+type signingKey struct {
+	id     string
+	method jwt.SigningMethod
+	key    any
+}
+
+// LoadSigningKeys reads PEM encoded private keys from path; see
+// ParseSigningKeys.
+func LoadSigningKeys(path string) (*SigningKeys, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- the path is operator configuration
+	if err != nil {
+		return nil, err
+	}
+	return ParseSigningKeys(data)
+}
+
+// ParseSigningKeys parses PEM encoded RSA (at least 2048 bits) and P-256 EC
+// private keys in PKCS #8, PKCS #1 or SEC 1 form. The first key signs; to
+// rotate, put the new key first and drop the old one once the tokens it
+// signed have expired. Key ids are RFC 7638 thumbprints.
+func ParseSigningKeys(data []byte) (*SigningKeys, error) {
+	keys := &SigningKeys{}
+	jwks := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{Keys: []jsonWebKey{}}
+
+	for i := 0; ; i++ {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		private, err := parsePrivateKey(block)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %d: %w", i, err)
+		}
+		jwk, method, public, err := publicJWK(private)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %d: %w", i, err)
+		}
+		if i == 0 {
+			keys.signer = signingKey{id: jwk.Kid, method: method, key: private}
+		}
+		keys.public = append(keys.public, verificationKey{id: jwk.Kid, alg: jwk.Alg, key: public})
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	if len(keys.public) == 0 {
+		return nil, errors.New("no PEM encoded private key found")
+	}
+
+	var err error
+	if keys.keySet, err = json.Marshal(jwks); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func parsePrivateKey(block *pem.Block) (any, error) {
+	switch block.Type {
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+}
+
+// publicJWK returns the JWK of the public half of private, the method it
+// signs with and the public key.
+func publicJWK(private any) (jsonWebKey, jwt.SigningMethod, any, error) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	var jwk jsonWebKey
+	var method jwt.SigningMethod
+	var public any
+	// RFC 7638 thumbprints hash the required members in lexicographic order,
+	// which is the order encoding/json writes map keys in.
+	var members map[string]string
+
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < minRSAKeyBits {
+			return jwk, nil, nil, fmt.Errorf("RSA keys must have at least %d bits", minRSAKeyBits)
+		}
+		e := big.NewInt(int64(k.E)).Bytes()
+		jwk = jsonWebKey{Kty: "RSA", Alg: AlgRS256, N: b64(k.N.Bytes()), E: b64(e)}
+		members = map[string]string{"kty": jwk.Kty, "n": jwk.N, "e": jwk.E}
+		method, public = jwt.SigningMethodRS256, &k.PublicKey
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return jwk, nil, nil, errors.New("EC keys must use the P-256 curve")
+		}
+		point, err := k.PublicKey.Bytes()
+		if err != nil {
+			return jwk, nil, nil, err
+		}
+		jwk = jsonWebKey{Kty: "EC", Alg: AlgES256, Crv: "P-256", X: b64(point[1:33]), Y: b64(point[33:])}
+		members = map[string]string{"kty": jwk.Kty, "crv": jwk.Crv, "x": jwk.X, "y": jwk.Y}
+		method, public = jwt.SigningMethodES256, &k.PublicKey
+	default:
+		return jwk, nil, nil, fmt.Errorf("unsupported key type %T", private)
+	}
+
+	canonical, err := json.Marshal(members)
+	if err != nil {
+		return jwk, nil, nil, err
+	}
+	thumbprint := sha256.Sum256(canonical)
+	jwk.Kid = b64(thumbprint[:])
+	jwk.Use = "sig"
+	return jwk, method, public, nil
+}
+
+// Sign returns claims as a token signed with the current key. typ sets the
+// token's "typ" header unless it is empty.
+func (k *SigningKeys) Sign(claims jwt.Claims, typ string) (string, error) {
+	token := jwt.NewWithClaims(k.signer.method, claims)
+	token.Header["kid"] = k.signer.id
+	if typ != "" {
+		token.Header["typ"] = typ
+	}
+	return token.SignedString(k.signer.key)
+}
+
+// JWKS returns the public keys as a JSON Web Key Set document.
+func (k *SigningKeys) JWKS() []byte {
+	return k.keySet
+}
+
+// Key implements KeySource.
+func (k *SigningKeys) Key(_ context.Context, kid, alg string) (any, error) {
+	return lookupKey(k.public, kid, alg)
+}
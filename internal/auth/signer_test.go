@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func pemBlock(t *testing.T, blockType string, der []byte, err error) []byte {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+}
+
+func TestSigningKeys(t *testing.T) {
+	keys := newTestKeys(t)
+	ecDER, err := x509.MarshalECPrivateKey(keys.ec)
+	ecPEM := pemBlock(t, "EC PRIVATE KEY", ecDER, err)
+	rsaPEM := pemBlock(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(keys.rsa), nil)
+
+	// The RSA key signed before a rotation to the EC key.
+	before, err := ParseSigningKeys(rsaPEM)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	after, err := ParseSigningKeys(append(append([]byte{}, ecPEM...), rsaPEM...))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	claims := &Claims{RegisteredClaims: jwt.RegisteredClaims{
+		Issuer: testIssuer, Subject: "client-1", Audience: jwt.ClaimStrings{testAudience},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}, Scope: "users:read"}
+	oldToken, err := before.Sign(claims, "at+jwt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	newToken, err := after.Sign(claims, "at+jwt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	verifier := NewVerifier(after, testIssuer, testAudience)
+	for name, token := range map[string]string{"old key": oldToken, "new key": newToken} {
+		if _, err := verifier.Verify(context.Background(), token); err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		}
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(newToken, &Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Method.Alg() != AlgES256 || parsed.Header["typ"] != "at+jwt" {
+		t.Errorf("expected an ES256 at+jwt token, got %v", parsed.Header)
+	}
+
+	// The published key set verifies the same tokens.
+	published, err := parseJWKS(after.JWKS())
+	if err != nil || len(published) != 2 {
+		t.Fatalf("expected two published keys, got %v (%v)", published, err)
+	}
+	if published[0].id != parsed.Header["kid"] || published[0].alg != AlgES256 {
+		t.Errorf("expected the signing key first, got %q (%s)", published[0].id, published[0].alg)
+	}
+	if published[1].id != before.signer.id {
+		t.Errorf("expected stable key ids, got %q and %q", published[1].id, before.signer.id)
+	}
+}
+
+func TestParseSigningKeys_Invalid(t *testing.T) {
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384DER, err := x509.MarshalPKCS8PrivateKey(p384)
+
+	tests := map[string][]byte{
+		"empty":       nil,
+		"small RSA":   pemBlock(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(small), nil),
+		"P-384":       pemBlock(t, "PRIVATE KEY", p384DER, err),
+		"public key":  pemBlock(t, "PUBLIC KEY", []byte{1}, nil),
+		"corrupt DER": pemBlock(t, "PRIVATE KEY", []byte{1, 2, 3}, nil),
+	}
+	for name, data := range tests {
+		if _, err := ParseSigningKeys(data); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestVerifiers(t *testing.T) {
+	keys := newTestKeys(t)
+	signing, err := ParseSigningKeys(pemBlock(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(keys.rsa), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := keys.jwks(t)
+	external := NewVerifier(newKeySet(func(context.Context) ([]byte, error) { return jwks, nil }, 0), testIssuer, testAudience)
+	internal := NewVerifier(signing, "https://cruder.example.com", "https://cruder.example.com")
+	verifiers := Verifiers{external, internal}
+
+	claims := validClaims()
+	if _, err := verifiers.Verify(context.Background(), sign(t, jwt.SigningMethodHS256, "hs", keys.secret, claims)); err != nil {
+		t.Errorf("external token: unexpected error: %v", err)
+	}
+
+	claims["iss"], claims["aud"] = "https://cruder.example.com", "https://cruder.example.com"
+	token, err := signing.Sign(claims, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifiers.Verify(context.Background(), token); err != nil {
+		t.Errorf("internal token: unexpected error: %v", err)
+	}
+
+	// Each issuer has its own audience, and other issuers are rejected.
+	claims["iss"] = testIssuer
+	wrongAudience := sign(t, jwt.SigningMethodHS256, "hs", keys.secret, claims)
+	claims["iss"] = "https://unknown.example.com"
+	unknown := sign(t, jwt.SigningMethodHS256, "hs", keys.secret, claims)
+	if _, err := verifiers.Verify(context.Background(), wrongAudience); !errors.Is(err, jwt.ErrTokenInvalidAudience) {
+		t.Errorf("expected an invalid audience, got %v", err)
+	}
+	if _, err := verifiers.Verify(context.Background(), unknown); !errors.Is(err, jwt.ErrTokenInvalidIssuer) {
+		t.Errorf("expected an invalid issuer, got %v", err)
+	}
+}
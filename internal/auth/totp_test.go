@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// The SHA-1 test vectors of RFC 6238 appendix B, truncated to six digits.
+	secret := []byte("12345678901234567890")
+	tests := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, want := range tests {
+		if got := TOTPCode(secret, TOTPStep(time.Unix(unix, 0))); got != want {
+			t.Errorf("T=%d: expected %s, got %s", unix, want, got)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	step := TOTPStep(now)
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		ok       bool
+	}{
+		{"current code", TOTPCode(secret, step), 0, true},
+		{"previous code", TOTPCode(secret, step-1), 0, true},
+		{"next code", TOTPCode(secret, step+1), 0, true},
+		{"expired code", TOTPCode(secret, step-2), 0, false},
+		{"used code", TOTPCode(secret, step), step, false},
+		{"wrong length", TOTPCode(secret, step)[1:], 0, false},
+	}
+	for _, tt := range tests {
+		matched, ok := VerifyTOTP(secret, tt.code, now, tt.lastStep)
+		if ok != tt.ok {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.ok, ok)
+		}
+		if ok && TOTPCode(secret, matched) != tt.code {
+			t.Errorf("%s: matched the wrong step %d", tt.name, matched)
+		}
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	u, err := url.Parse(TOTPURI("Cruder", "alice", secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Cruder:alice" ||
+		q.Get("secret") != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" || q.Get("issuer") != "Cruder" ||
+		q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Errorf("unexpected URI %s", u)
+	}
+}
+
+func TestSecretBox(t *testing.T) {
+	newKey := func() string {
+		key := make([]byte, 32)
+		_, _ = rand.Read(key)
+		return base64.StdEncoding.EncodeToString(key)
+	}
+	oldKey, newerKey := newKey(), newKey()
+	before, err := ParseSecretBoxKeys(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := ParseSecretBoxKeys(newerKey + "," + oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := before.Seal([]byte("secret"), []byte("user-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext, current, err := before.Open(sealed, []byte("user-1")); err != nil || !current || !bytes.Equal(plaintext, []byte("secret")) {
+		t.Errorf("expected the secret with the current key, got %q, %v, %v", plaintext, current, err)
+	}
+	// After a rotation the old ciphertext still opens, but is stale.
+	if plaintext, current, err := after.Open(sealed, []byte("user-1")); err != nil || current || !bytes.Equal(plaintext, []byte("secret")) {
+		t.Errorf("expected the secret with an old key, got %q, %v, %v", plaintext, current, err)
+	}
+	if _, _, err := after.Open(sealed, []byte("user-2")); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("expected another owner to fail, got %v", err)
+	}
+	if _, _, err := after.Open(sealed[:5], []byte("user-1")); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("expected a truncated ciphertext to fail, got %v", err)
+	}
+
+	for _, keys := range []string{"", "c2hvcnQ=", oldKey + ",not base64"} {
+		if _, err := ParseSecretBoxKeys(keys); err == nil {
+			t.Errorf("%q: expected an error", keys)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testIssuer   = "https://issuer.example.com"
+	testAudience = "cruder"
+)
+
+// testKeys are signing keys and the JWKS entries that verify them.
+type testKeys struct {
+	secret []byte
+	rsa    *rsa.PrivateKey
+	ec     *ecdsa.PrivateKey
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatal(err)
+	}
+	return &testKeys{secret: secret, rsa: rsaKey, ec: ecKey}
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func (k *testKeys) jwks(t *testing.T) []byte {
+	t.Helper()
+	ecPoint, err := k.ec.PublicKey.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "oct", "kid": "hs", "k": b64(k.secret)},
+		{"kty": "RSA", "kid": "rs", "use": "sig", "n": b64(k.rsa.N.Bytes()), "e": b64(big.NewInt(int64(k.rsa.E)).Bytes())},
+		{"kty": "EC", "kid": "es", "crv": "P-256", "x": b64(ecPoint[1:33]), "y": b64(ecPoint[33:])},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": b64(k.rsa.N.Bytes()), "e": "AQAB"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":   "user-1",
+		"iss":   testIssuer,
+		"aud":   testAudience,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scope": "users:read users:write",
+	}
+}
+
+func TestVerifier_Algorithms(t *testing.T) {
+	keys := newTestKeys(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, keys.jwks(t), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	verifier := NewVerifier(NewFileKeySet(path, 0), testIssuer, testAudience)
+
+	tests := []struct {
+		name   string
+		method jwt.SigningMethod
+		kid    string
+		key    any
+	}{
+		{"HS256", jwt.SigningMethodHS256, "hs", keys.secret},
+		{"RS256", jwt.SigningMethodRS256, "rs", keys.rsa},
+		{"ES256", jwt.SigningMethodES256, "es", keys.ec},
+		{"RS256 without kid", jwt.SigningMethodRS256, "", keys.rsa},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := verifier.Verify(context.Background(), sign(t, tt.method, tt.kid, tt.key, validClaims()))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if claims.Subject != "user-1" {
+				t.Errorf("expected subject user-1, got %q", claims.Subject)
+			}
+			if scopes := claims.Scopes(); len(scopes) != 2 || scopes[0] != "users:read" || scopes[1] != "users:write" {
+				t.Errorf("unexpected scopes %v", scopes)
+			}
+		})
+	}
+}
+
+func TestVerifier_Rejects(t *testing.T) {
+	keys := newTestKeys(t)
+	jwks := keys.jwks(t)
+	verifier := NewVerifier(newKeySet(func(context.Context) ([]byte, error) { return jwks, nil }, 0), testIssuer, testAudience)
+
+	with := func(name string, value any) jwt.MapClaims {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+	// An HS256 token signed with public RSA key material must not verify.
+	rsaModulus := keys.rsa.N.Bytes()
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"expired", sign(t, jwt.SigningMethodRS256, "rs", keys.rsa, with("exp", time.Now().Add(-time.Minute).Unix())), jwt.ErrTokenExpired},
+		{"no exp", sign(t, jwt.SigningMethodRS256, "rs", keys.rsa, with("exp", nil)), jwt.ErrTokenRequiredClaimMissing},
+		{"not yet valid", sign(t, jwt.SigningMethodRS256, "rs", keys.rsa, with("nbf", time.Now().Add(time.Hour).Unix())), jwt.ErrTokenNotValidYet},
+		{"wrong issuer", sign(t, jwt.SigningMethodRS256, "rs", keys.rsa, with("iss", "https://evil.example.com")), jwt.ErrTokenInvalidIssuer},
+		{"wrong audience", sign(t, jwt.SigningMethodRS256, "rs", keys.rsa, with("aud", "other")), jwt.ErrTokenInvalidAudience},
+		{"algorithm confusion", sign(t, jwt.SigningMethodHS256, "rs", rsaModulus, validClaims()), ErrKeyType},
+		{"wrong key", sign(t, jwt.SigningMethodES256, "es", mustECKey(t), validClaims()), jwt.ErrTokenSignatureInvalid},
+		{"unknown kid", sign(t, jwt.SigningMethodRS256, "other", keys.rsa, validClaims()), ErrUnknownKey},
+		{"encryption key", sign(t, jwt.SigningMethodRS256, "enc", keys.rsa, validClaims()), ErrUnknownKey},
+		{"none", sign(t, jwt.SigningMethodNone, "", jwt.UnsafeAllowNoneSignatureType, validClaims()), jwt.ErrTokenSignatureInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifier.Verify(context.Background(), tt.token)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+
+	t.Run("no subject", func(t *testing.T) {
+		if _, err := verifier.Verify(context.Background(), sign(t, jwt.SigningMethodRS256, "rs", keys.rsa, with("sub", nil))); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func mustECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestKeySet_Rotation(t *testing.T) {
+	old, rotated := newTestKeys(t), newTestKeys(t)
+	current := old.jwks(t)
+	fetches := 0
+	keys := newKeySet(func(context.Context) ([]byte, error) {
+		fetches++
+		return current, nil
+	}, time.Hour)
+	ctx := context.Background()
+
+	if _, err := keys.Key(ctx, "rs", AlgRS256); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A new key id right after a fetch waits for the refresh throttle.
+	current = []byte(`{"keys":[]}`)
+	if _, err := keys.Key(ctx, "new", AlgRS256); !errors.Is(err, ErrUnknownKey) || fetches != 1 {
+		t.Fatalf("expected unknown key without a fetch, got %v after %d fetches", err, fetches)
+	}
+
+	// Once the throttle has passed, an unknown key id triggers a refetch.
+	current = []byte(`{"keys":[{"kty":"oct","kid":"new","k":"` + b64(rotated.secret) + `"}]}`)
+	keys.triedAt = keys.triedAt.Add(-minRefreshInterval)
+	if _, err := keys.Key(ctx, "new", AlgHS256); err != nil || fetches != 2 {
+		t.Fatalf("expected the new key after a refetch, got %v after %d fetches", err, fetches)
+	}
+
+	// A failed refresh keeps the keys that were fetched before.
+	keys.fetchedAt = keys.fetchedAt.Add(-2 * time.Hour)
+	keys.triedAt = keys.triedAt.Add(-2 * time.Hour)
+	current = []byte(`not json`)
+	if _, err := keys.Key(ctx, "new", AlgHS256); err != nil || fetches != 3 {
+		t.Fatalf("expected the stale key after a failed refresh, got %v after %d fetches", err, fetches)
+	}
+}
+
+func TestKeySet_Unavailable(t *testing.T) {
+	keys := newKeySet(func(context.Context) ([]byte, error) {
+		return nil, errors.New("connection refused")
+	}, 0)
+	verifier := NewVerifier(keys, "", "")
+	_, err := verifier.Verify(context.Background(), sign(t, jwt.SigningMethodHS256, "hs", make([]byte, 32), validClaims()))
+	if !errors.Is(err, jwt.ErrTokenUnverifiable) || errors.Is(err, ErrUnknownKey) {
+		t.Errorf("expected an unverifiable token error, got %v", err)
+	}
+}
+
+func TestKeySet_UnavailableThrottled(t *testing.T) {
+	fetches := 0
+	keys := newKeySet(func(context.Context) ([]byte, error) {
+		fetches++
+		return nil, errors.New("connection refused")
+	}, 0)
+	ctx := context.Background()
+
+	// Until the key source is back, requests get the last error without a
+	// fetch each.
+	for range 3 {
+		if _, err := keys.Key(ctx, "rs", AlgRS256); err == nil || err.Error() != "connection refused" {
+			t.Fatalf("expected the fetch error, got %v", err)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("expected 1 fetch, got %d", fetches)
+	}
+}
+
+func TestKeySet_SlowRefresh(t *testing.T) {
+	k := newTestKeys(t)
+	data := k.jwks(t)
+	started, release := make(chan struct{}), make(chan struct{})
+	slow := false
+	keys := newKeySet(func(context.Context) ([]byte, error) {
+		if slow {
+			close(started)
+			<-release
+		}
+		return data, nil
+	}, time.Hour)
+	ctx := context.Background()
+	if _, err := keys.Key(ctx, "rs", AlgRS256); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// While a refresh of the stale set hangs, tokens are verified with the
+	// keys fetched before.
+	keys.fetchedAt = keys.fetchedAt.Add(-2 * time.Hour)
+	keys.triedAt = keys.triedAt.Add(-2 * time.Hour)
+	slow = true
+	done := make(chan error)
+	go func() {
+		_, err := keys.Key(ctx, "rs", AlgRS256)
+		done <- err
+	}()
+	<-started
+	if _, err := keys.Key(ctx, "rs", AlgRS256); err != nil {
+		t.Errorf("expected the previous key during the refresh, got %v", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestParseJWKS_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"short secret": `{"keys":[{"kty":"oct","k":"c2hvcnQ"}]}`,
+		"small RSA":    `{"keys":[{"kty":"RSA","n":"AQAB","e":"AQAB"}]}`,
+		"bad EC point": `{"keys":[{"kty":"EC","crv":"P-256","x":"` + b64(make([]byte, 32)) + `","y":"` + b64(make([]byte, 32)) + `"}]}`,
+		"not JSON":     `keys`,
+	}
+	for name, data := range tests {
+		if _, err := parseJWKS([]byte(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	keys, err := parseJWKS([]byte(`{"keys":[{"kty":"OKP","crv":"Ed25519","x":"AA"},{"kty":"EC","crv":"P-384"}]}`))
+	if err != nil || len(keys) != 0 {
+		t.Errorf("expected unsupported keys to be skipped, got %v, %v", keys, err)
+	}
+}
@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cruder/internal/model"
+	"cruder/internal/problem"
+	"cruder/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+const apiKeysPath = "/api/v1/admin/api-keys/"
+
+type APIKeyController struct {
+	service service.APIKeyService
+}
+
+func NewAPIKeyController(service service.APIKeyService) *APIKeyController {
+	return &APIKeyController{service: service}
+}
+
+type createAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Owner     string     `json:"owner"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type rotateAPIKeyRequest struct {
+	// GracePeriod is a Go duration such as "24h".
+	GracePeriod *string `json:"grace_period"`
+}
+
+// respondIssued returns a new key with its secret. The response must not be
+// cached anywhere, since the secret cannot be retrieved again.
+func respondIssued(ctx *gin.Context, issued *model.IssuedAPIKey) {
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("Location", apiKeysPath+issued.ID)
+	ctx.JSON(http.StatusCreated, issued)
+}
+
+func (c *APIKeyController) CreateAPIKey(ctx *gin.Context) {
+	var req createAPIKeyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		problem.BadRequest(ctx, "invalid request body")
+		return
+	}
+
+	key := &model.APIKey{Name: req.Name, Owner: req.Owner, Scopes: req.Scopes, ExpiresAt: req.ExpiresAt}
+	token, err := c.service.Create(ctx.Request.Context(), key)
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+	respondIssued(ctx, &model.IssuedAPIKey{APIKey: *key, Key: token})
+}
+
+func (c *APIKeyController) ListAPIKeys(ctx *gin.Context) {
+	includeRevoked, err := queryBool(ctx, "include_revoked")
+	if err != nil {
+		problem.BadRequest(ctx, err.Error())
+		return
+	}
+
+	keys, err := c.service.List(ctx.Request.Context(), includeRevoked)
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, keys)
+}
+
+func (c *APIKeyController) GetAPIKey(ctx *gin.Context) {
+	key, err := c.service.Get(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, key)
+}
+
+func (c *APIKeyController) RevokeAPIKey(ctx *gin.Context) {
+	if err := c.service.Revoke(ctx.Request.Context(), ctx.Param("id")); err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// RotateAPIKey issues a successor key. The body is optional and may set
+// grace_period, during which the old key keeps working.
+func (c *APIKeyController) RotateAPIKey(ctx *gin.Context) {
+	var req rotateAPIKeyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		problem.BadRequest(ctx, "invalid request body")
+		return
+	}
+	grace := model.DefaultRotationGracePeriod
+	if req.GracePeriod != nil {
+		var err error
+		if grace, err = time.ParseDuration(*req.GracePeriod); err != nil {
+			problem.BadRequest(ctx, "grace_period must be a duration such as \"24h\"")
+			return
+		}
+	}
+
+	issued, err := c.service.Rotate(ctx.Request.Context(), ctx.Param("id"), grace)
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+	respondIssued(ctx, issued)
+}
+
+// ListAPIKeyEvents shows when and from where a key was used, at most once a
+// minute, and the rejected attempts to use it.
+func (c *APIKeyController) ListAPIKeyEvents(ctx *gin.Context) {
+	limit := model.DefaultAPIKeyEventLimit
+	if limitStr := ctx.Query("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > model.MaxAPIKeyEventLimit {
+			problem.BadRequest(ctx, fmt.Sprintf("limit must be between 1 and %d", model.MaxAPIKeyEventLimit))
+			return
+		}
+	}
+
+	events, err := c.service.Events(ctx.Request.Context(), ctx.Param("id"), limit, ctx.Query("after"))
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, events)
+}
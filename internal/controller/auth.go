@@ -0,0 +1,177 @@
+package controller
+
+import (
+	"net/http"
+
+	"cruder/internal/auth"
+	"cruder/internal/model"
+	"cruder/internal/problem"
+	"cruder/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuthController serves password logins with their second factor,
+// password changes and resets, and email verification.
+type AuthController struct {
+	passwords    service.PasswordService
+	verification service.EmailVerificationService
+	mfa          service.MFAService
+
+	// tokens is nil when the service issues no tokens, and so has no login.
+	tokens service.TokenService
+}
+
+func NewAuthController(passwords service.PasswordService, verification service.EmailVerificationService, mfa service.MFAService, tokens service.TokenService) *AuthController {
+	return &AuthController{passwords: passwords, verification: verification, mfa: mfa, tokens: tokens}
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// Login exchanges a username and password for an access token of the user.
+// Users with MFA get a challenge instead, which LoginMFA completes.
+func (c *AuthController) Login(ctx *gin.Context) {
+	noStore(ctx)
+	if c.loginDisabled(ctx) {
+		return
+	}
+	var req model.LoginRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		problem.BadRequest(ctx, "invalid request body")
+		return
+	}
+
+	user, err := c.passwords.Authenticate(ctx.Request.Context(), req.Username, req.Password)
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+	challenge, err := c.mfa.Challenge(ctx.Request.Context(), user)
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+	if challenge != nil {
+		ctx.JSON(http.StatusOK, challenge)
+		return
+	}
+	c.issueToken(ctx, user, auth.AMRPassword)
+}
+
+// LoginMFA exchanges the challenge of a login and a TOTP or recovery code
+// for an access token.
+func (c *AuthController) LoginMFA(ctx *gin.Context) {
+	noStore(ctx)
+	if c.loginDisabled(ctx) {
+		return
+	}
+	var req model.MFALogin
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		problem.BadRequest(ctx, "invalid request body")
+		return
+	}
+
+	user, err := c.mfa.CompleteLogin(ctx.Request.Context(), req.MFAToken, req.Code)
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+	c.issueToken(ctx, user, auth.AMRPassword, auth.AMRMFA)
+}
+
+// loginDisabled answers 501 if there are no tokens to log in to, before
+// any password is checked.
+func (c *AuthController) loginDisabled(ctx *gin.Context) bool {
+	if c.tokens != nil {
+		return false
+	}
+	problem.Write(ctx, problem.New(http.StatusNotImplemented, problem.TypeBlank,
+		"password login is disabled: no token signing key is configured"))
+	return true
+}
+
+// issueToken responds with a token for user, who authenticated with methods.
+func (c *AuthController) issueToken(ctx *gin.Context, user *model.User, methods ...string) {
+	resp, err := c.tokens.IssueUserToken(ctx.Request.Context(), user, methods)
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, resp)
+}
+
+// ChangePassword sets a user's password. current_password may only be
+// left out while the user has none.
+func (c *AuthController) ChangePassword(ctx *gin.Context) {
+	var req changePasswordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		problem.BadRequest(ctx, "invalid request body")
+		return
+	}
+
+	err := c.passwords.ChangePassword(ctx.Request.Context(), ctx.Param("uuid"), req.CurrentPassword, req.NewPassword)
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// RequestPasswordReset mails a reset link. It is accepted whether or not a
+// user has the address, so that it cannot be used to find accounts.
+func (c *AuthController) RequestPasswordReset(ctx *gin.Context) {
+	var req model.PasswordResetRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		problem.BadRequest(ctx, "invalid request body")
+		return
+	}
+
+	if err := c.passwords.RequestReset(ctx.Request.Context(), req.Email); err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusAccepted)
+}
+
+// ResetPassword sets a new password with a mailed reset token.
+func (c *AuthController) ResetPassword(ctx *gin.Context) {
+	var req model.PasswordReset
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		problem.BadRequest(ctx, "invalid request body")
+		return
+	}
+
+	if err := c.passwords.Reset(ctx.Request.Context(), req.Token, req.NewPassword); err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// RequestEmailVerification mails a verification link to a user's address.
+func (c *AuthController) RequestEmailVerification(ctx *gin.Context) {
+	if err := c.verification.Request(ctx.Request.Context(), ctx.Param("uuid")); err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusAccepted)
+}
+
+// VerifyEmail confirms an address with a mailed token and returns the user.
+func (c *AuthController) VerifyEmail(ctx *gin.Context) {
+	var req model.EmailVerification
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		problem.BadRequest(ctx, "invalid request body")
+		return
+	}
+
+	user, err := c.verification.Verify(ctx.Request.Context(), req.Token)
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, user)
+}
@@ -0,0 +1,66 @@
+package controller
+
+import (
+	"net/http"
+
+	"cruder/internal/model"
+	"cruder/internal/problem"
+
+	"github.com/gin-gonic/gin"
+)
+
+// batchItem reports the outcome of one batch operation with the status
+// code the equivalent single request would have returned.
+type batchItem struct {
+	Index  int              `json:"index"`
+	Status int              `json:"status"`
+	User   *model.User      `json:"user,omitempty"`
+	Error  *problem.Problem `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Items []batchItem `json:"items"`
+}
+
+var batchSuccessStatus = map[string]int{
+	model.BatchOpCreate: http.StatusCreated,
+	model.BatchOpUpdate: http.StatusOK,
+	model.BatchOpDelete: http.StatusNoContent,
+}
+
+// BatchUsers applies an array of create, update and delete operations.
+// With atomic=true they succeed or fail together; otherwise each item
+// reports its own status.
+func (c *UserController) BatchUsers(ctx *gin.Context) {
+	atomic, err := queryBool(ctx, "atomic")
+	if err != nil {
+		problem.BadRequest(ctx, err.Error())
+		return
+	}
+
+	var ops []model.BatchOperation
+	if err := ctx.ShouldBindJSON(&ops); err != nil {
+		problem.BadRequest(ctx, "request body must be a JSON array of operations")
+		return
+	}
+
+	results, err := c.service.Batch(ctx.Request.Context(), ops, atomic)
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+
+	response := batchResponse{Items: make([]batchItem, len(results))}
+	for i, result := range results {
+		item := batchItem{Index: i, User: result.User}
+		if result.Err != nil {
+			item.Error = problem.FromError(ctx, result.Err)
+			item.Error.Instance = ctx.Request.URL.Path
+			item.Status = item.Error.Status
+		} else {
+			item.Status = batchSuccessStatus[ops[i].Op]
+		}
+		response.Items[i] = item
+	}
+	ctx.JSON(http.StatusOK, response)
+}
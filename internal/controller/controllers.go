@@ -3,11 +3,30 @@ package controller
 import "cruder/internal/service"
 
 type Controller struct {
-	Users *UserController
+	Users        *UserController
+	Imports      *ImportController
+	Jobs         *JobController
+	APIKeys      *APIKeyController
+	OAuthClients *OAuthClientController
+	Auth         *AuthController
+	MFA          *MFAController
+
+	// OAuth is nil when the service issues no tokens.
+	OAuth *OAuthController
 }
 
-func NewController(services *service.Service) *Controller {
-	return &Controller{
-		Users: NewUserController(services.Users),
+func NewController(services *service.Service, jobs JobStatusProvider) *Controller {
+	controllers := &Controller{
+		Users:        NewUserController(services.Users),
+		Imports:      NewImportController(services.Imports),
+		Jobs:         NewJobController(jobs),
+		APIKeys:      NewAPIKeyController(services.APIKeys),
+		OAuthClients: NewOAuthClientController(services.OAuthClients),
+		Auth:         NewAuthController(services.Passwords, services.EmailVerification, services.MFA, services.Tokens),
+		MFA:          NewMFAController(services.MFA),
+	}
+	if services.Tokens != nil {
+		controllers.OAuth = NewOAuthController(services.Tokens)
 	}
+	return controllers
 }
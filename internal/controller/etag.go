@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"cruder/internal/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errInvalidIfMatch = errors.New(`If-Match must be "*" or a single strong entity tag`)
+
+// userETag is the strong entity tag of a user, derived from its version.
+func userETag(u *model.User) string {
+	return `"` + strconv.Itoa(u.Version) + `"`
+}
+
+func setUserETag(ctx *gin.Context, u *model.User) {
+	ctx.Header("ETag", userETag(u))
+}
+
+// ifMatchVersion returns the version required by the If-Match header.
+// A missing header or "*" returns 0, meaning any version.
+func ifMatchVersion(ctx *gin.Context) (int, error) {
+	header := strings.TrimSpace(ctx.GetHeader("If-Match"))
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+	if len(header) < 3 || header[0] != '"' || header[len(header)-1] != '"' {
+		return 0, errInvalidIfMatch
+	}
+	version, err := strconv.Atoi(header[1 : len(header)-1])
+	if err != nil || version < 1 {
+		return 0, errInvalidIfMatch
+	}
+	return version, nil
+}
+
+// notModified reports whether If-None-Match matches the user's entity tag,
+// using the weak comparison required for GET.
+func notModified(ctx *gin.Context, u *model.User) bool {
+	header := ctx.GetHeader("If-None-Match")
+	if header == "" {
+		return false
+	}
+	etag := userETag(u)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
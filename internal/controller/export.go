@@ -0,0 +1,198 @@
+package controller
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cruder/internal/model"
+	"cruder/internal/problem"
+	"cruder/pkg/csvsafe"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// exportFlushRows is how many rows are buffered before they are sent.
+	exportFlushRows = 500
+
+	// ExportErrorTrailer reports a failure that happened after the export
+	// started streaming and the status could no longer be changed.
+	ExportErrorTrailer = "X-Export-Error"
+)
+
+// exportMediaTypes are offered in order of preference; clients that send no
+// Accept header get JSON.
+var exportMediaTypes = []string{"application/json", "application/x-ndjson", "text/csv"}
+
+// exportWriter encodes a stream of users. begin and end are called once,
+// around any number of write calls.
+type exportWriter interface {
+	begin() error
+	write(u *model.User) error
+	end() error
+}
+
+func newExportWriter(mediaType string, w io.Writer) exportWriter {
+	switch mediaType {
+	case "text/csv":
+		return &csvExportWriter{w: csv.NewWriter(w)}
+	case "application/x-ndjson":
+		return &ndjsonExportWriter{enc: json.NewEncoder(w)}
+	default:
+		return &jsonExportWriter{w: w}
+	}
+}
+
+// ExportUsers streams every user matching the filter, sort and
+// include_deleted parameters of the list endpoint, without paging.
+func (c *UserController) ExportUsers(ctx *gin.Context) {
+	mediaType := ctx.NegotiateFormat(exportMediaTypes...)
+	if mediaType == "" {
+		problem.Write(ctx, problem.New(http.StatusNotAcceptable, problem.TypeBadRequest,
+			"export is available as application/json, application/x-ndjson or text/csv"))
+		return
+	}
+	includeDeleted, err := queryBool(ctx, "include_deleted")
+	if err != nil {
+		problem.BadRequest(ctx, err.Error())
+		return
+	}
+	params := model.ListParams{
+		Filter:         ctx.Query("filter"),
+		Sort:           ctx.Query("sort"),
+		IncludeDeleted: includeDeleted,
+	}
+
+	w := newExportWriter(mediaType, ctx.Writer)
+	started := false
+	start := func() error {
+		started = true
+		ctx.Header("Content-Type", mediaType+"; charset=utf-8")
+		ctx.Header("Trailer", ExportErrorTrailer)
+		ctx.Status(http.StatusOK)
+		return w.begin()
+	}
+
+	rows := 0
+	err = c.service.Export(ctx.Request.Context(), params, func(u *model.User) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		if err := w.write(u); err != nil {
+			return err
+		}
+		if rows++; rows%exportFlushRows == 0 {
+			ctx.Writer.Flush()
+		}
+		return nil
+	})
+	// Until the first row is written, errors such as an invalid filter
+	// still get a proper status.
+	if err != nil && !started {
+		problem.Error(ctx, err)
+		return
+	}
+	if err == nil && !started {
+		err = start()
+	}
+	if err == nil {
+		err = w.end()
+	}
+	if err != nil {
+		ctx.Writer.Header().Set(ExportErrorTrailer, "export aborted after "+strconv.Itoa(rows)+" rows")
+		_ = ctx.Error(err)
+	}
+}
+
+type jsonExportWriter struct {
+	w io.Writer
+	n int
+}
+
+func (j *jsonExportWriter) begin() error {
+	_, err := io.WriteString(j.w, "[")
+	return err
+}
+
+func (j *jsonExportWriter) write(u *model.User) error {
+	b, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	if j.n > 0 {
+		if _, err := io.WriteString(j.w, ","); err != nil {
+			return err
+		}
+	}
+	j.n++
+	_, err = j.w.Write(b)
+	return err
+}
+
+func (j *jsonExportWriter) end() error {
+	_, err := io.WriteString(j.w, "]\n")
+	return err
+}
+
+type ndjsonExportWriter struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonExportWriter) begin() error { return nil }
+
+func (n *ndjsonExportWriter) write(u *model.User) error { return n.enc.Encode(u) }
+
+func (n *ndjsonExportWriter) end() error { return nil }
+
+// csvExportHeader lists the columns of a CSV export. The file can be
+// imported again; the import ignores the columns it does not set and
+// undoes the escaping of cells that spreadsheets would run as formulas.
+var csvExportHeader = []string{"id", "uuid", "username", "email", "full_name", "created_at", "version", "deleted_at",
+	"email_verified_at"}
+
+type csvExportWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvExportWriter) begin() error {
+	return c.w.Write(csvExportHeader)
+}
+
+func (c *csvExportWriter) write(u *model.User) error {
+	deletedAt, emailVerifiedAt := "", ""
+	if u.DeletedAt != nil {
+		deletedAt = u.DeletedAt.Format(time.RFC3339Nano)
+	}
+	if u.EmailVerifiedAt != nil {
+		emailVerifiedAt = u.EmailVerifiedAt.Format(time.RFC3339Nano)
+	}
+	err := c.w.Write([]string{
+		strconv.Itoa(u.ID),
+		u.UUID,
+		csvsafe.Escape(u.Username),
+		csvsafe.Escape(u.Email),
+		csvsafe.Escape(u.FullName),
+		u.CreatedAt.Format(time.RFC3339Nano),
+		strconv.Itoa(u.Version),
+		deletedAt,
+		emailVerifiedAt,
+	})
+	if err != nil {
+		return err
+	}
+	// csv.Writer buffers internally; hand each row to the response so that
+	// the periodic flush sends it.
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvExportWriter) end() error {
+	c.w.Flush()
+	return c.w.Error()
+}
@@ -0,0 +1,194 @@
+package controller
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+
+	"cruder/internal/model"
+	"cruder/internal/problem"
+	"cruder/internal/service"
+	"cruder/pkg/csvsafe"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// importMaxBytes bounds an uploaded file.
+	importMaxBytes = 64 << 20
+
+	// importSyncMaxBytes is the largest upload, by Content-Length, that is
+	// imported while the client waits. Larger or chunked uploads become
+	// background jobs.
+	importSyncMaxBytes = 256 << 10
+
+	importJobsPath = "/api/v1/users/import/"
+)
+
+var importFormats = map[string]string{
+	"text/csv":             model.ImportFormatCSV,
+	"application/x-ndjson": model.ImportFormatNDJSON,
+}
+
+type ImportController struct {
+	service service.ImportService
+}
+
+func NewImportController(service service.ImportService) *ImportController {
+	return &ImportController{service: service}
+}
+
+// importJobResponse adds the job's links to its status.
+type importJobResponse struct {
+	*model.ImportJob
+	URL       string `json:"url"`
+	ReportURL string `json:"report_url,omitempty"`
+}
+
+func newImportJobResponse(job *model.ImportJob) importJobResponse {
+	response := importJobResponse{ImportJob: job, URL: importJobsPath + job.ID}
+	if job.FinishedAt != nil {
+		response.ReportURL = response.URL + "/report"
+	}
+	return response
+}
+
+// ImportUsers creates users from a CSV or NDJSON body. Small uploads are
+// answered with the error report; larger ones are spooled to disk and
+// imported by a background job.
+func (c *ImportController) ImportUsers(ctx *gin.Context) {
+	dryRun, err := queryBool(ctx, "dry_run")
+	if err != nil {
+		problem.BadRequest(ctx, err.Error())
+		return
+	}
+	format, ok := importFormats[ctx.ContentType()]
+	if !ok {
+		problem.Write(ctx, problem.New(http.StatusUnsupportedMediaType, problem.TypeBadRequest,
+			"Content-Type must be text/csv or application/x-ndjson"))
+		return
+	}
+
+	body := http.MaxBytesReader(ctx.Writer, ctx.Request.Body, importMaxBytes)
+	if n := ctx.Request.ContentLength; n >= 0 && n <= importSyncMaxBytes {
+		stats, report, err := c.service.Import(ctx.Request.Context(), body, format, dryRun)
+		if err != nil {
+			importError(ctx, err)
+			return
+		}
+		writeImportReport(ctx, stats, func(fn func(model.ImportError) error) error {
+			for _, e := range report {
+				if err := fn(e); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		return
+	}
+
+	file, err := spoolUpload(body)
+	if err != nil {
+		importError(ctx, err)
+		return
+	}
+	job, err := c.service.StartImport(ctx.Request.Context(), file, format, dryRun)
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+	ctx.Header("Location", importJobsPath+job.ID)
+	ctx.JSON(http.StatusAccepted, newImportJobResponse(job))
+}
+
+func (c *ImportController) GetImportJob(ctx *gin.Context) {
+	job, err := c.service.GetJob(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, newImportJobResponse(job))
+}
+
+// GetImportReport streams the lines a job rejected so far as CSV.
+func (c *ImportController) GetImportReport(ctx *gin.Context) {
+	id := ctx.Param("id")
+	job, err := c.service.GetJob(ctx.Request.Context(), id)
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+	writeImportReport(ctx, job.ImportStats, func(fn func(model.ImportError) error) error {
+		return c.service.Report(ctx.Request.Context(), id, fn)
+	})
+}
+
+// writeImportReport sends the counts as headers and the rejected lines as
+// a CSV attachment with line, field and message columns.
+func writeImportReport(ctx *gin.Context, stats model.ImportStats, each func(func(model.ImportError) error) error) {
+	ctx.Header("Content-Type", "text/csv; charset=utf-8")
+	ctx.Header("Content-Disposition", `attachment; filename="import-report.csv"`)
+	ctx.Header("X-Import-Processed", strconv.Itoa(stats.Processed))
+	ctx.Header("X-Import-Succeeded", strconv.Itoa(stats.Succeeded))
+	ctx.Header("X-Import-Failed", strconv.Itoa(stats.Failed))
+	ctx.Status(http.StatusOK)
+
+	w := csv.NewWriter(ctx.Writer)
+	_ = w.Write([]string{"line", "field", "message"})
+	err := each(func(e model.ImportError) error {
+		return w.Write([]string{strconv.Itoa(e.Line), csvsafe.Escape(e.Field), csvsafe.Escape(e.Message)})
+	})
+	w.Flush()
+	if err == nil {
+		err = w.Error()
+	}
+	if err != nil {
+		// The status is already sent; leave the failure to the logs.
+		_ = ctx.Error(err)
+	}
+}
+
+func importError(ctx *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		problem.Write(ctx, problem.New(http.StatusRequestEntityTooLarge, problem.TypeBadRequest,
+			"upload exceeds "+strconv.Itoa(importMaxBytes>>20)+" MiB"))
+		return
+	}
+	problem.Error(ctx, err)
+}
+
+// spoolUpload copies body to a temporary file, so that a background import
+// does not depend on the request, and returns it rewound. The file is
+// removed when it is closed.
+func spoolUpload(body io.Reader) (io.ReadCloser, error) {
+	file, err := os.CreateTemp("", "cruder-import-*")
+	if err != nil {
+		return nil, err
+	}
+	spooled := &tempFile{File: file}
+	if _, err := io.Copy(file, body); err != nil {
+		_ = spooled.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		_ = spooled.Close()
+		return nil, err
+	}
+	return spooled, nil
+}
+
+type tempFile struct {
+	*os.File
+}
+
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	if removeErr := os.Remove(f.Name()); err == nil {
+		err = removeErr
+	}
+	return err
+}
@@ -0,0 +1,34 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+
+	"cruder/internal/model"
+	"cruder/internal/problem"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JobStatusProvider reports the state of background jobs.
+type JobStatusProvider interface {
+	Status(ctx context.Context) ([]model.JobStatus, error)
+}
+
+type JobController struct {
+	jobs JobStatusProvider
+}
+
+func NewJobController(jobs JobStatusProvider) *JobController {
+	return &JobController{jobs: jobs}
+}
+
+func (c *JobController) ListJobs(ctx *gin.Context) {
+	statuses, err := c.jobs.Status(ctx.Request.Context())
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, model.JobStatusList{Items: statuses})
+}
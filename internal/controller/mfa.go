@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"net/http"
+
+	"cruder/internal/model"
+	"cruder/internal/problem"
+	"cruder/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MFAController serves TOTP enrollment and its reset by administrators.
+type MFAController struct {
+	service service.MFAService
+}
+
+func NewMFAController(service service.MFAService) *MFAController {
+	return &MFAController{service: service}
+}
+
+func (c *MFAController) GetMFAStatus(ctx *gin.Context) {
+	status, err := c.service.Status(ctx.Request.Context(), ctx.Param("uuid"))
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, status)
+}
+
+// EnrollTOTP starts an enrollment. The response carries the secret once
+// and must not be cached.
+func (c *MFAController) EnrollTOTP(ctx *gin.Context) {
+	enrollment, err := c.service.Enroll(ctx.Request.Context(), ctx.Param("uuid"))
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+	noStore(ctx)
+	ctx.JSON(http.StatusCreated, enrollment)
+}
+
+// ActivateTOTP enables MFA with a first code and returns the recovery
+// codes, which are not shown again.
+func (c *MFAController) ActivateTOTP(ctx *gin.Context) {
+	var req model.TOTPActivation
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		problem.BadRequest(ctx, "invalid request body")
+		return
+	}
+
+	codes, err := c.service.Activate(ctx.Request.Context(), ctx.Param("uuid"), req.Code)
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+	noStore(ctx)
+	ctx.JSON(http.StatusOK, codes)
+}
+
+// ResetMFA removes the second factor of a locked-out user.
+func (c *MFAController) ResetMFA(ctx *gin.Context) {
+	if err := c.service.Reset(ctx.Request.Context(), ctx.Param("uuid")); err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
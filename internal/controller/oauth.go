@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"cruder/internal/model"
+	"cruder/internal/problem"
+	"cruder/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxOAuthFormBytes bounds token and introspection requests, which only
+// carry a few short parameters.
+const maxOAuthFormBytes = 64 << 10
+
+// OAuthController serves the token, introspection and key set endpoints.
+// They follow RFC 6749 and RFC 7662 rather than the API's conventions:
+// requests are form encoded and errors are OAuth error responses.
+type OAuthController struct {
+	service service.TokenService
+}
+
+func NewOAuthController(service service.TokenService) *OAuthController {
+	return &OAuthController{service: service}
+}
+
+// oauthErrorResponse is an error response of RFC 6749 section 5.2.
+type oauthErrorResponse struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// Token issues an access token for the client_credentials and
+// refresh_token grants. Clients authenticate with HTTP Basic or with
+// client_id and client_secret form parameters.
+func (c *OAuthController) Token(ctx *gin.Context) {
+	noStore(ctx)
+	form, creds, err := parseOAuthForm(ctx)
+	if err != nil {
+		writeOAuthError(ctx, err)
+		return
+	}
+
+	resp, err := c.service.Token(ctx.Request.Context(), &model.TokenRequest{
+		Client:       creds,
+		GrantType:    form.Get("grant_type"),
+		Scope:        form.Get("scope"),
+		RefreshToken: form.Get("refresh_token"),
+	})
+	if err != nil {
+		writeOAuthError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, resp)
+}
+
+// Introspect describes a token to an authenticated client. The optional
+// token_type_hint is not needed to tell the token types apart and is
+// ignored.
+func (c *OAuthController) Introspect(ctx *gin.Context) {
+	noStore(ctx)
+	form, creds, err := parseOAuthForm(ctx)
+	if err != nil {
+		writeOAuthError(ctx, err)
+		return
+	}
+
+	info, err := c.service.Introspect(ctx.Request.Context(), creds, form.Get("token"))
+	if err != nil {
+		writeOAuthError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, info)
+}
+
+// JWKS publishes the public keys that verify access tokens.
+func (c *OAuthController) JWKS(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "public, max-age=300")
+	ctx.Data(http.StatusOK, "application/jwk-set+json", c.service.JWKS())
+}
+
+func noStore(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("Pragma", "no-cache")
+}
+
+// parseOAuthForm parses a form encoded request body and the client
+// credentials, which may be sent with HTTP Basic (RFC 6749 section 2.3.1)
+// or as form parameters, but not both.
+func parseOAuthForm(ctx *gin.Context) (url.Values, model.ClientCredentials, error) {
+	var creds model.ClientCredentials
+	if ctx.ContentType() != "application/x-www-form-urlencoded" {
+		return nil, creds, &service.OAuthError{Code: service.OAuthInvalidRequest, Description: "request body must be application/x-www-form-urlencoded"}
+	}
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxOAuthFormBytes)
+	if err := ctx.Request.ParseForm(); err != nil {
+		return nil, creds, &service.OAuthError{Code: service.OAuthInvalidRequest, Description: "invalid request body"}
+	}
+	form := ctx.Request.PostForm
+	for name, values := range form {
+		if len(values) > 1 {
+			return nil, creds, &service.OAuthError{Code: service.OAuthInvalidRequest, Description: name + " must not be repeated"}
+		}
+	}
+
+	creds = model.ClientCredentials{ID: form.Get("client_id"), Secret: form.Get("client_secret")}
+	if id, secret, ok := ctx.Request.BasicAuth(); ok {
+		if creds.Secret != "" {
+			return nil, creds, &service.OAuthError{Code: service.OAuthInvalidRequest, Description: "use only one client authentication method"}
+		}
+		// Basic credentials are form encoded before they are joined.
+		var errID, errSecret error
+		creds.ID, errID = url.QueryUnescape(id)
+		creds.Secret, errSecret = url.QueryUnescape(secret)
+		if errID != nil || errSecret != nil {
+			return nil, creds, &service.OAuthError{Code: service.OAuthInvalidClient, Description: "malformed client credentials"}
+		}
+	}
+	return form, creds, nil
+}
+
+// writeOAuthError answers OAuth errors with an RFC 6749 error response and
+// other errors, such as database failures, with problem details.
+func writeOAuthError(ctx *gin.Context, err error) {
+	var oauthErr *service.OAuthError
+	if !errors.As(err, &oauthErr) {
+		problem.Error(ctx, err)
+		return
+	}
+	status := http.StatusBadRequest
+	if oauthErr.Code == service.OAuthInvalidClient {
+		status = http.StatusUnauthorized
+		ctx.Header("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	ctx.JSON(status, oauthErrorResponse{Error: oauthErr.Code, Description: oauthErr.Description})
+}
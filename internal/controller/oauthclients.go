@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"net/http"
+
+	"cruder/internal/model"
+	"cruder/internal/problem"
+	"cruder/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+const oauthClientsPath = "/api/v1/admin/oauth-clients/"
+
+type OAuthClientController struct {
+	service service.OAuthClientService
+}
+
+func NewOAuthClientController(service service.OAuthClientService) *OAuthClientController {
+	return &OAuthClientController{service: service}
+}
+
+type createOAuthClientRequest struct {
+	Name       string   `json:"name"`
+	Owner      string   `json:"owner"`
+	Scopes     []string `json:"scopes"`
+	GrantTypes []string `json:"grant_types"`
+}
+
+// CreateOAuthClient registers a client. Like a new API key, the response
+// carries the secret once and must not be cached.
+func (c *OAuthClientController) CreateOAuthClient(ctx *gin.Context) {
+	var req createOAuthClientRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		problem.BadRequest(ctx, "invalid request body")
+		return
+	}
+
+	client := &model.OAuthClient{Name: req.Name, Owner: req.Owner, Scopes: req.Scopes, GrantTypes: req.GrantTypes}
+	secret, err := c.service.Create(ctx.Request.Context(), client)
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("Location", oauthClientsPath+client.ID)
+	ctx.JSON(http.StatusCreated, &model.IssuedOAuthClient{OAuthClient: *client, Secret: secret})
+}
+
+func (c *OAuthClientController) ListOAuthClients(ctx *gin.Context) {
+	includeRevoked, err := queryBool(ctx, "include_revoked")
+	if err != nil {
+		problem.BadRequest(ctx, err.Error())
+		return
+	}
+
+	clients, err := c.service.List(ctx.Request.Context(), includeRevoked)
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, clients)
+}
+
+func (c *OAuthClientController) GetOAuthClient(ctx *gin.Context) {
+	client, err := c.service.Get(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, client)
+}
+
+func (c *OAuthClientController) RevokeOAuthClient(ctx *gin.Context) {
+	if err := c.service.Revoke(ctx.Request.Context(), ctx.Param("id")); err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
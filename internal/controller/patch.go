@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"cruder/internal/model"
+	"cruder/internal/service"
+	"cruder/pkg/validation"
+)
+
+const (
+	contentTypeJSON       = "application/json"
+	contentTypeMergePatch = "application/merge-patch+json"
+	contentTypeJSONPatch  = "application/json-patch+json"
+)
+
+var (
+	errPatchNotObject = errors.New("merge patch must be a JSON object")
+	errPatchNotArray  = errors.New("JSON patch must be an array of operations")
+)
+
+// readOnlyUserFields are user members that cannot be changed by a patch.
+// They are ignored so clients can send back a representation they fetched.
+var readOnlyUserFields = map[string]bool{"id": true, "uuid": true, "created_at": true, "version": true, "deleted_at": true,
+	"email_verified_at": true}
+
+// parseUserMergePatch decodes an RFC 7396 JSON Merge Patch document.
+// Read-only members are ignored; unknown members and members that are not
+// strings or null are reported as a validation error.
+func parseUserMergePatch(body []byte) (*model.UserPatch, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(body), []byte("{")) {
+		return nil, errPatchNotObject
+	}
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(body, &members); err != nil {
+		return nil, errPatchNotObject
+	}
+
+	patch := &model.UserPatch{}
+	var errs validation.Errors
+	for name, raw := range members {
+		var target *model.PatchString
+		switch name {
+		case "username":
+			target = &patch.Username
+		case "email":
+			target = &patch.Email
+		case "full_name":
+			target = &patch.FullName
+		default:
+			if !readOnlyUserFields[name] {
+				errs.Add(name, "is not a user field")
+			}
+			continue
+		}
+
+		target.Set = true
+		if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+			target.Null = true
+			continue
+		}
+		if err := json.Unmarshal(raw, &target.Value); err != nil {
+			errs.Add(name, "must be a string or null")
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, &service.ValidationError{Errors: errs}
+	}
+	return patch, nil
+}
+
+// parseJSONPatch decodes an RFC 6902 JSON Patch document.
+func parseJSONPatch(body []byte) ([]model.JSONPatchOperation, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		return nil, errPatchNotArray
+	}
+	var ops []model.JSONPatchOperation
+	if err := json.Unmarshal(body, &ops); err != nil {
+		return nil, errPatchNotArray
+	}
+	return ops, nil
+}
@@ -2,10 +2,12 @@ package controller
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 
 	"cruder/internal/model"
+	"cruder/internal/problem"
 	"cruder/internal/service"
 
 	"github.com/gin-gonic/gin"
@@ -20,102 +22,346 @@ func NewUserController(service service.UserService) *UserController {
 }
 
 func (c *UserController) GetAllUsers(ctx *gin.Context) {
-	users, err := c.service.GetAll()
+	params, err := parseListParams(ctx)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		problem.BadRequest(ctx, err.Error())
+		return
+	}
+
+	users, err := c.service.GetAll(ctx.Request.Context(), params)
+	if err != nil {
+		problem.Error(ctx, err)
 		return
 	}
 
 	ctx.JSON(http.StatusOK, users)
 }
 
+func (c *UserController) SearchUsers(ctx *gin.Context) {
+	limit := model.DefaultSearchLimit
+	if limitStr := ctx.Query("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > model.MaxSearchLimit {
+			problem.BadRequest(ctx, fmt.Sprintf("limit must be between 1 and %d", model.MaxSearchLimit))
+			return
+		}
+	}
+
+	includeDeleted, err := queryBool(ctx, "include_deleted")
+	if err != nil {
+		problem.BadRequest(ctx, err.Error())
+		return
+	}
+
+	results, err := c.service.Search(ctx.Request.Context(), ctx.Query("q"), limit, includeDeleted)
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, results)
+}
+
+// parseListParams reads limit, offset, after, with_total, filter and sort query parameters.
+func parseListParams(ctx *gin.Context) (model.ListParams, error) {
+	params := model.ListParams{
+		Limit:  model.DefaultPageLimit,
+		After:  ctx.Query("after"),
+		Filter: ctx.Query("filter"),
+		Sort:   ctx.Query("sort"),
+	}
+
+	if limitStr := ctx.Query("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > model.MaxPageLimit {
+			return params, fmt.Errorf("limit must be between 1 and %d", model.MaxPageLimit)
+		}
+		params.Limit = limit
+	}
+
+	if offsetStr := ctx.Query("offset"); offsetStr != "" {
+		offset, err := strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			return params, errors.New("offset must be a non-negative integer")
+		}
+		params.Offset = offset
+	}
+
+	var err error
+	if params.WithTotal, err = queryBool(ctx, "with_total"); err != nil {
+		return params, err
+	}
+	if params.IncludeDeleted, err = queryBool(ctx, "include_deleted"); err != nil {
+		return params, err
+	}
+
+	return params, nil
+}
+
+// queryBool parses an optional boolean query parameter.
+func queryBool(ctx *gin.Context, name string) (bool, error) {
+	value := ctx.Query(name)
+	if value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s must be a boolean", name)
+	}
+	return b, nil
+}
+
 func (c *UserController) GetUserByUsername(ctx *gin.Context) {
 	username := ctx.Param("username")
 
-	user, err := c.service.GetByUsername(username)
+	includeDeleted, err := queryBool(ctx, "include_deleted")
 	if err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		problem.BadRequest(ctx, err.Error())
 		return
 	}
 
-	ctx.JSON(http.StatusOK, user)
+	user, err := c.service.GetByUsername(ctx.Request.Context(), username, includeDeleted)
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+
+	c.respondUser(ctx, user)
 }
 
 func (c *UserController) GetUserByID(ctx *gin.Context) {
 	idStr := ctx.Param("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		problem.BadRequest(ctx, "invalid id")
 		return
 	}
 
-	user, err := c.service.GetByID(id)
+	includeDeleted, err := queryBool(ctx, "include_deleted")
 	if err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		problem.BadRequest(ctx, err.Error())
 		return
 	}
 
-	ctx.JSON(http.StatusOK, user)
+	user, err := c.service.GetByID(ctx.Request.Context(), id, includeDeleted)
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+
+	c.respondUser(ctx, user)
 }
 
 func (c *UserController) CreateUser(ctx *gin.Context) {
 	var user model.User
 	if err := ctx.ShouldBindJSON(&user); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		problem.BadRequest(ctx, "invalid request body")
 		return
 	}
 
-	createdUser, err := c.service.Create(&user)
+	createdUser, err := c.service.Create(ctx.Request.Context(), &user)
 	if err != nil {
-		if errors.Is(err, service.ErrUniqueConstraint) {
-			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
-			return
-		}
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		problem.Error(ctx, err)
 		return
 	}
 
+	setUserETag(ctx, createdUser)
 	ctx.JSON(http.StatusCreated, createdUser)
 }
 
+// UpdateUser applies a JSON Merge Patch (RFC 7396), where only the members
+// present in the body change and null clears nullable fields, or a JSON Patch
+// (RFC 6902) when the body is sent as application/json-patch+json.
 func (c *UserController) UpdateUser(ctx *gin.Context) {
 	uuid := ctx.Param("uuid")
 
-	var user model.User
-	if err := ctx.ShouldBindJSON(&user); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+	version, err := ifMatchVersion(ctx)
+	if err != nil {
+		problem.BadRequest(ctx, err.Error())
+		return
+	}
+
+	contentType := ctx.ContentType()
+	switch contentType {
+	case "", contentTypeJSON, contentTypeMergePatch, contentTypeJSONPatch:
+	default:
+		problem.Write(ctx, problem.New(http.StatusUnsupportedMediaType, problem.TypeBlank,
+			"PATCH accepts "+contentTypeMergePatch+", "+contentTypeJSONPatch+" or "+contentTypeJSON))
 		return
 	}
 
-	updatedUser, err := c.service.Update(uuid, &user)
+	body, err := ctx.GetRawData()
 	if err != nil {
-		if err.Error() == "user is not found" {
-			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		problem.BadRequest(ctx, "invalid request body")
+		return
+	}
+
+	var updatedUser *model.User
+	if contentType == contentTypeJSONPatch {
+		ops, err := parseJSONPatch(body)
+		if err != nil {
+			problem.BadRequest(ctx, err.Error())
 			return
 		}
-		if errors.Is(err, service.ErrUniqueConstraint) {
-			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		updatedUser, err = c.service.JSONPatch(ctx.Request.Context(), uuid, ops, version)
+		if err != nil {
+			problem.Error(ctx, err)
 			return
 		}
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	} else {
+		patch, err := parseUserMergePatch(body)
+		if err != nil {
+			if errors.Is(err, errPatchNotObject) {
+				problem.BadRequest(ctx, err.Error())
+				return
+			}
+			problem.Error(ctx, err)
+			return
+		}
+		updatedUser, err = c.service.Patch(ctx.Request.Context(), uuid, patch, version)
+		if err != nil {
+			problem.Error(ctx, err)
+			return
+		}
+	}
+
+	setUserETag(ctx, updatedUser)
+	ctx.JSON(http.StatusOK, updatedUser)
+}
+
+// ReplaceUser replaces all mutable fields of the user with the request body.
+func (c *UserController) ReplaceUser(ctx *gin.Context) {
+	uuid := ctx.Param("uuid")
+
+	version, err := ifMatchVersion(ctx)
+	if err != nil {
+		problem.BadRequest(ctx, err.Error())
+		return
+	}
+
+	var user model.User
+	if err := ctx.ShouldBindJSON(&user); err != nil {
+		problem.BadRequest(ctx, "invalid request body")
 		return
 	}
 
+	updatedUser, err := c.service.Update(ctx.Request.Context(), uuid, &user, version)
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+
+	setUserETag(ctx, updatedUser)
 	ctx.JSON(http.StatusOK, updatedUser)
 }
 
+// UpsertUserByExternalID replaces the user linked to an identifier of an
+// upstream system, or creates and links it. It answers 201 when the user was
+// created and 200 when it was updated.
+func (c *UserController) UpsertUserByExternalID(ctx *gin.Context) {
+	version, err := ifMatchVersion(ctx)
+	if err != nil {
+		problem.BadRequest(ctx, err.Error())
+		return
+	}
+
+	var user model.User
+	if err := ctx.ShouldBindJSON(&user); err != nil {
+		problem.BadRequest(ctx, "invalid request body")
+		return
+	}
+
+	upsertedUser, created, err := c.service.UpsertByExternalID(
+		ctx.Request.Context(), ctx.Param("source"), ctx.Param("external_id"), &user, version)
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	setUserETag(ctx, upsertedUser)
+	ctx.JSON(status, upsertedUser)
+}
+
+// DeleteUser soft-deletes a user, or removes it permanently with hard=true.
 func (c *UserController) DeleteUser(ctx *gin.Context) {
 	uuid := ctx.Param("uuid")
 
-	err := c.service.Delete(uuid)
+	version, err := ifMatchVersion(ctx)
+	if err != nil {
+		problem.BadRequest(ctx, err.Error())
+		return
+	}
+
+	hard, err := queryBool(ctx, "hard")
 	if err != nil {
-		if err.Error() == "user is not found" {
-			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		problem.BadRequest(ctx, err.Error())
+		return
+	}
+
+	if hard {
+		err = c.service.Purge(ctx.Request.Context(), uuid, version)
+	} else {
+		err = c.service.Delete(ctx.Request.Context(), uuid, version)
+	}
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (c *UserController) RestoreUser(ctx *gin.Context) {
+	uuid := ctx.Param("uuid")
+
+	version, err := ifMatchVersion(ctx)
+	if err != nil {
+		problem.BadRequest(ctx, err.Error())
+		return
+	}
+
+	restoredUser, err := c.service.Restore(ctx.Request.Context(), uuid, version)
+	if err != nil {
+		problem.Error(ctx, err)
+		return
+	}
+
+	setUserETag(ctx, restoredUser)
+	ctx.JSON(http.StatusOK, restoredUser)
+}
+
+// GetUserHistory pages through the audit trail of a user, newest first.
+func (c *UserController) GetUserHistory(ctx *gin.Context) {
+	limit := model.DefaultHistoryLimit
+	if limitStr := ctx.Query("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > model.MaxHistoryLimit {
+			problem.BadRequest(ctx, fmt.Sprintf("limit must be between 1 and %d", model.MaxHistoryLimit))
 			return
 		}
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+
+	history, err := c.service.History(ctx.Request.Context(), ctx.Param("uuid"), limit, ctx.Query("after"))
+	if err != nil {
+		problem.Error(ctx, err)
 		return
 	}
 
-	ctx.Status(http.StatusNoContent)
+	ctx.JSON(http.StatusOK, history)
+}
+
+// respondUser writes user with its ETag, or 304 if If-None-Match matches it.
+func (c *UserController) respondUser(ctx *gin.Context, user *model.User) {
+	setUserETag(ctx, user)
+	if notModified(ctx, user) {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+	ctx.JSON(http.StatusOK, user)
 }
@@ -2,19 +2,37 @@ package handler
 
 import (
 	"bytes"
+	"context"
+	"cruder/internal/auth"
 	"cruder/internal/controller"
+	"cruder/internal/mail"
+	"cruder/internal/middleware"
 	"cruder/internal/model"
+	"cruder/internal/problem"
 	"cruder/internal/repository"
+	"cruder/internal/scheduler"
 	"cruder/internal/service"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
 	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
 	_ "github.com/lib/pq"
 )
 
@@ -39,20 +57,49 @@ func setupTestDB(t *testing.T) *sql.DB {
 
 // setupRouter creates a router with test dependencies
 func setupRouter(db *sql.DB) *gin.Engine {
+	r, _ := setupRouterWithJobs(db)
+	return r
+}
+
+// setupRouterWithJobs creates a router and the scheduler behind its admin
+// endpoints. The scheduler is not started; tests run jobs explicitly.
+// Authentication is disabled, so requests need no credentials.
+func setupRouterWithJobs(db *sql.DB) (*gin.Engine, *scheduler.Scheduler) {
 	gin.SetMode(gin.TestMode)
 
 	repositories := repository.NewRepository(db)
 	services := service.NewService(repositories)
-	controllers := controller.NewController(services)
+	jobs := scheduler.New(repositories.Jobs,
+		scheduler.PurgeDeletedUsers(services.Users, time.Hour, time.Hour),
+		scheduler.PurgeIdempotencyKeys(repositories.Idempotency, time.Hour),
+	)
+	controllers := controller.NewController(services, jobs)
 	r := gin.New()
-	New(r, controllers.Users)
-	return r
+	r.Use(middleware.RequestIDMiddleware())
+	r.Use(middleware.AuditMiddleware())
+	New(r, controllers, Middleware{
+		APIKeyAuth:  middleware.APIKeyAuthMiddleware(services.APIKeys, true),
+		Idempotency: middleware.IdempotencyMiddleware(repositories.Idempotency, time.Hour),
+	})
+	return r, jobs
+}
+
+// decodeProblem asserts that the response is application/problem+json and decodes it
+func decodeProblem(t *testing.T, rr *httptest.ResponseRecorder) problem.Problem {
+	if ct := rr.Header().Get("Content-Type"); ct != problem.ContentType {
+		t.Fatalf("expected content type %s, got %s", problem.ContentType, ct)
+	}
+	var p problem.Problem
+	if err := json.Unmarshal(rr.Body.Bytes(), &p); err != nil {
+		t.Fatalf("failed to unmarshal problem: %v", err)
+	}
+	return p
 }
 
 // insertTestUser inserts a user into the test database and returns the UUID
 func insertTestUser(t *testing.T, db *sql.DB, user model.User) string {
 	repos := repository.NewRepository(db)
-	createdUser, err := repos.Users.Create(&user)
+	createdUser, err := repos.Users.Create(context.Background(), &user)
 	if err != nil {
 		t.Fatalf("failed to insert test user: %v", err)
 	}
@@ -62,7 +109,7 @@ func insertTestUser(t *testing.T, db *sql.DB, user model.User) string {
 // userExists checks if a user exists in the database by UUID
 func userExists(t *testing.T, db *sql.DB, uuid string) bool {
 	repos := repository.NewRepository(db)
-	user, err := repos.Users.GetByUUID(uuid)
+	user, err := repos.Users.GetByUUID(context.Background(), uuid, false)
 	if err != nil {
 		t.Fatalf("failed to check if user exists: %v", err)
 	}
@@ -72,7 +119,7 @@ func userExists(t *testing.T, db *sql.DB, uuid string) bool {
 // getUserByUUID retrieves a user by UUID from the database
 func getUserByUUID(t *testing.T, db *sql.DB, uuid string) *model.User {
 	repos := repository.NewRepository(db)
-	user, err := repos.Users.GetByUUID(uuid)
+	user, err := repos.Users.GetByUUID(context.Background(), uuid, false)
 	if err != nil {
 		t.Fatalf("failed to get user: %v", err)
 	}
@@ -81,7 +128,7 @@ func getUserByUUID(t *testing.T, db *sql.DB, uuid string) *model.User {
 
 // cleanupTestDB removes all test data from the database
 func cleanupTestDB(t *testing.T, db *sql.DB) {
-	_, err := db.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE")
+	_, err := db.Exec("TRUNCATE TABLE users, job_runs, user_audit_log, user_import_jobs, idempotency_keys, api_keys, api_key_events, oauth_clients, oauth_refresh_tokens, user_credentials, user_tokens, user_mfa, user_recovery_codes RESTART IDENTITY CASCADE")
 	if err != nil {
 		t.Fatalf("failed to cleanup test database: %v", err)
 	}
@@ -125,13 +172,16 @@ func TestGetAllUsers_Success(t *testing.T) {
 		t.Errorf("expected status 200, got %d", rr.Code)
 	}
 
-	var users []model.User
+	var users model.UserList
 	if err := json.Unmarshal(rr.Body.Bytes(), &users); err != nil {
 		t.Fatalf("failed to unmarshal response: %v", err)
 	}
 
-	if len(users) != 2 {
-		t.Errorf("expected 2 users, got %d", len(users))
+	if len(users.Items) != 2 {
+		t.Errorf("expected 2 users, got %d", len(users.Items))
+	}
+	if users.NextCursor != "" {
+		t.Errorf("expected no next cursor, got %s", users.NextCursor)
 	}
 }
 
@@ -153,13 +203,264 @@ func TestGetAllUsers_Empty(t *testing.T) {
 		t.Errorf("expected status 200, got %d", rr.Code)
 	}
 
-	var users []model.User
+	var users model.UserList
+	if err := json.Unmarshal(rr.Body.Bytes(), &users); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+
+	if len(users.Items) != 0 {
+		t.Errorf("expected 0 users, got %d", len(users.Items))
+	}
+}
+
+func TestGetAllUsers_CursorPagination(t *testing.T) {
+	// Given: Three users exist in the database
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	for _, name := range []string{"page1_test", "page2_test", "page3_test"} {
+		_ = insertTestUser(t, db, model.User{
+			Username: name,
+			Email:    name + "@example.com",
+			FullName: "Page User",
+		})
+	}
+
+	router := setupRouter(db)
+
+	// When: Requesting the first page with limit=2 and the total count
+	req, _ := http.NewRequest("GET", "/api/v1/users?limit=2&with_total=true", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: Two users, a next cursor and the total are returned
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+
+	var firstPage model.UserList
+	if err := json.Unmarshal(rr.Body.Bytes(), &firstPage); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(firstPage.Items) != 2 {
+		t.Fatalf("expected 2 users, got %d", len(firstPage.Items))
+	}
+	if firstPage.NextCursor == "" {
+		t.Fatal("expected next cursor to be set")
+	}
+	if firstPage.Total == nil || *firstPage.Total != 3 {
+		t.Errorf("expected total 3, got %v", firstPage.Total)
+	}
+
+	// When: Requesting the next page with the returned cursor
+	req, _ = http.NewRequest("GET", "/api/v1/users?limit=2&after="+firstPage.NextCursor, nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: The remaining user is returned and there is no further cursor
+	var secondPage model.UserList
+	if err := json.Unmarshal(rr.Body.Bytes(), &secondPage); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(secondPage.Items) != 1 {
+		t.Fatalf("expected 1 user, got %d", len(secondPage.Items))
+	}
+	if secondPage.Items[0].Username != "page3_test" {
+		t.Errorf("expected username page3_test, got %s", secondPage.Items[0].Username)
+	}
+	if secondPage.NextCursor != "" {
+		t.Errorf("expected no next cursor, got %s", secondPage.NextCursor)
+	}
+}
+
+func TestGetAllUsers_InvalidPaging(t *testing.T) {
+	// Given: No users exist in the database
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	router := setupRouter(db)
+
+	for _, query := range []string{"limit=0", "limit=abc", "offset=-1", "after=not-a-cursor"} {
+		// When: Sending a GET request with invalid paging parameters
+		req, _ := http.NewRequest("GET", "/api/v1/users?"+query, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		// Then: The response status should be 400 Bad Request
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, rr.Code)
+		}
+	}
+}
+
+func TestGetAllUsers_FilterAndSort(t *testing.T) {
+	// Given: Users with different email domains exist in the database
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	for _, name := range []string{"alpha_test", "gamma_test", "beta_test"} {
+		_ = insertTestUser(t, db, model.User{
+			Username: name,
+			Email:    name + "@acme.com",
+			FullName: "Acme User",
+		})
+	}
+	_ = insertTestUser(t, db, model.User{
+		Username: "other_test",
+		Email:    "other_test@example.com",
+		FullName: "Other User",
+	})
+
+	router := setupRouter(db)
+
+	// When: Filtering by email domain and sorting by username descending
+	query := url.Values{}
+	query.Set("filter", `email ends_with "@acme.com" and created_at > 2000-01-01`)
+	query.Set("sort", "-username")
+	req, _ := http.NewRequest("GET", "/api/v1/users?"+query.Encode(), nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: Only matching users are returned in the requested order
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+
+	var users model.UserList
 	if err := json.Unmarshal(rr.Body.Bytes(), &users); err != nil {
 		t.Fatalf("failed to unmarshal response: %v", err)
 	}
 
-	if len(users) != 0 {
-		t.Errorf("expected 0 users, got %d", len(users))
+	expected := []string{"gamma_test", "beta_test", "alpha_test"}
+	if len(users.Items) != len(expected) {
+		t.Fatalf("expected %d users, got %d", len(expected), len(users.Items))
+	}
+	for i, name := range expected {
+		if users.Items[i].Username != name {
+			t.Errorf("expected username %s at position %d, got %s", name, i, users.Items[i].Username)
+		}
+	}
+}
+
+func TestGetAllUsers_InvalidFilter(t *testing.T) {
+	// Given: No users exist in the database
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	router := setupRouter(db)
+
+	// When: Filtering by a field that is not allow-listed
+	query := url.Values{}
+	query.Set("filter", `email = "a@b.c" and password = secret`)
+	req, _ := http.NewRequest("GET", "/api/v1/users?"+query.Encode(), nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: The response status should be 400 Bad Request with the error position
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", rr.Code)
+	}
+
+	var body struct {
+		Position int `json:"position"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if body.Position != 20 {
+		t.Errorf("expected position 20, got %d", body.Position)
+	}
+}
+
+func TestSearchUsers_Fuzzy(t *testing.T) {
+	// Given: Users with different full names exist in the database
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	_ = insertTestUser(t, db, model.User{
+		Username: "jdoe_test",
+		Email:    "jdoe_test@example.com",
+		FullName: "John Doe",
+	})
+	_ = insertTestUser(t, db, model.User{
+		Username: "asmith_test",
+		Email:    "asmith_test@example.com",
+		FullName: "Alice Smith",
+	})
+
+	router := setupRouter(db)
+
+	// When: Searching with a misspelled name
+	req, _ := http.NewRequest("GET", "/api/v1/users/search?q="+url.QueryEscape("jhon doe"), nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: The closest user is ranked first with a positive score
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+
+	var results model.UserSearchResults
+	if err := json.Unmarshal(rr.Body.Bytes(), &results); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(results.Items) == 0 {
+		t.Fatal("expected at least one result")
+	}
+	if results.Items[0].FullName != "John Doe" {
+		t.Errorf("expected John Doe first, got %s", results.Items[0].FullName)
+	}
+	if results.Items[0].Score <= 0 {
+		t.Errorf("expected positive score, got %f", results.Items[0].Score)
+	}
+}
+
+func TestSearchUsers_EmptyQuery(t *testing.T) {
+	// Given: No users exist in the database
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	router := setupRouter(db)
+
+	// When: Searching without a query
+	req, _ := http.NewRequest("GET", "/api/v1/users/search?q=", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: The response status should be 400 Bad Request
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", rr.Code)
+	}
+}
+
+func TestGetAllUsers_Timeout(t *testing.T) {
+	// Given: A router whose request deadline expires immediately
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	gin.SetMode(gin.TestMode)
+	repositories := repository.NewRepository(db)
+	services := service.NewService(repositories)
+	controllers := controller.NewController(services, scheduler.New(repositories.Jobs))
+	router := gin.New()
+	router.Use(middleware.RequestTimeoutMiddleware(time.Nanosecond))
+	New(router, controllers, Middleware{Idempotency: middleware.IdempotencyMiddleware(repositories.Idempotency, 0)})
+
+	// When: Sending a GET request to /api/v1/users
+	req, _ := http.NewRequest("GET", "/api/v1/users", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: The response status should be 504 Gateway Timeout
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected status 504, got %d", rr.Code)
 	}
 }
 
@@ -339,10 +640,174 @@ func TestDeleteUser_NotFound(t *testing.T) {
 	rr := httptest.NewRecorder()
 	router.ServeHTTP(rr, req)
 
-	// Then: The response status should be 404 Not Found
+	// Then: The response status should be 404 Not Found with a problem body
 	if rr.Code != http.StatusNotFound {
 		t.Errorf("expected status 404, got %d", rr.Code)
 	}
+	p := decodeProblem(t, rr)
+	if p.Type != problem.TypeNotFound {
+		t.Errorf("expected type %s, got %s", problem.TypeNotFound, p.Type)
+	}
+	if p.Instance != "/api/v1/users/123e4567-e89b-12d3-a456-426614174000" {
+		t.Errorf("unexpected instance %s", p.Instance)
+	}
+	if p.TraceID == "" || p.TraceID != rr.Header().Get(problem.RequestIDHeader) {
+		t.Errorf("expected trace id to match X-Request-ID header, got %q", p.TraceID)
+	}
+}
+
+func TestDeleteUser_SoftDeleteAndRestore(t *testing.T) {
+	// Given: A user that has been soft-deleted
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	uuid := insertTestUser(t, db, model.User{Username: "softuser", Email: "soft@example.com"})
+	router := setupRouter(db)
+
+	req, _ := http.NewRequest("DELETE", "/api/v1/users/"+uuid, nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", rr.Code)
+	}
+
+	// When: Looking the user up with and without include_deleted
+	req, _ = http.NewRequest("GET", "/api/v1/users/username/softuser", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: It is hidden by default but visible with include_deleted=true
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for deleted user, got %d", rr.Code)
+	}
+
+	req, _ = http.NewRequest("GET", "/api/v1/users/username/softuser?include_deleted=true", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 with include_deleted, got %d", rr.Code)
+	}
+	var deleted model.User
+	if err := json.Unmarshal(rr.Body.Bytes(), &deleted); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if deleted.DeletedAt == nil {
+		t.Errorf("expected deleted_at to be set")
+	}
+
+	// When: The username is reused by a new user
+	body, _ := json.Marshal(model.User{Username: "softuser", Email: "soft@example.com"})
+	req, _ = http.NewRequest("POST", "/api/v1/users", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: Creation succeeds and restoring the old user conflicts
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201 when reusing deleted username, got %d", rr.Code)
+	}
+
+	req, _ = http.NewRequest("POST", "/api/v1/users/"+uuid+"/restore", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected status 409 when restoring over a live duplicate, got %d", rr.Code)
+	}
+}
+
+func TestRestoreUser_Success(t *testing.T) {
+	// Given: A soft-deleted user
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	uuid := insertTestUser(t, db, model.User{Username: "restoreme", Email: "restore@example.com"})
+	router := setupRouter(db)
+
+	req, _ := http.NewRequest("DELETE", "/api/v1/users/"+uuid, nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// When: Sending a POST request to /api/v1/users/{uuid}/restore
+	req, _ = http.NewRequest("POST", "/api/v1/users/"+uuid+"/restore", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: The user is live again and restoring twice conflicts
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	if !userExists(t, db, uuid) {
+		t.Errorf("user was not restored")
+	}
+
+	req, _ = http.NewRequest("POST", "/api/v1/users/"+uuid+"/restore", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected status 409 for a live user, got %d", rr.Code)
+	}
+}
+
+func TestDeleteUser_Hard(t *testing.T) {
+	// Given: A user exists in the database
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	uuid := insertTestUser(t, db, model.User{Username: "purgeme", Email: "purge@example.com"})
+	router := setupRouter(db)
+
+	// When: Sending a DELETE request with hard=true
+	req, _ := http.NewRequest("DELETE", "/api/v1/users/"+uuid+"?hard=true", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: The row is gone even when deleted users are included
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", rr.Code)
+	}
+	user, err := repository.NewRepository(db).Users.GetByUUID(context.Background(), uuid, true)
+	if err != nil {
+		t.Fatalf("failed to look up user: %v", err)
+	}
+	if user != nil {
+		t.Errorf("expected user to be purged")
+	}
+}
+
+func TestDeleteUser_HardNeedsAdmin(t *testing.T) {
+	// Given: A user and a key that may change users but not administer them
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	uuid := insertTestUser(t, db, model.User{Username: "keepme", Email: "keep@example.com"})
+	router, services := setupRouterWithAuth(t, db, "env-secret")
+	writeKey, err := services.APIKeys.Create(context.Background(), &model.APIKey{
+		Name: "crm sync", Owner: "integrations", Scopes: []string{model.ScopeUsersWrite},
+	})
+	if err != nil {
+		t.Fatalf("failed to create API key: %v", err)
+	}
+
+	// When: Purging the user with that key
+	rr := sendWithKey(router, "DELETE", "/api/v1/users/"+uuid+"?hard=true", writeKey, "")
+
+	// Then: 403 is returned and the user is still there
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected status 403, got %d: %s", rr.Code, rr.Body.String())
+	}
+	user, err := repository.NewRepository(db).Users.GetByUUID(context.Background(), uuid, true)
+	if err != nil || user == nil || user.DeletedAt != nil {
+		t.Errorf("expected the user to be untouched, got %+v (%v)", user, err)
+	}
+
+	// Then: The same key can still soft-delete
+	if rr := sendWithKey(router, "DELETE", "/api/v1/users/"+uuid, writeKey, ""); rr.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d: %s", rr.Code, rr.Body.String())
+	}
 }
 
 func TestUpdateUser_Success(t *testing.T) {
@@ -416,29 +881,201 @@ func TestUpdateUser_NotFound(t *testing.T) {
 	}
 }
 
-func TestCreateUser_Success(t *testing.T) {
-	// Given: No user exists with the given username/email
+func TestUpdateUser_PartialMergePatch(t *testing.T) {
+	// Given: A user exists in the database with UUID
 	db := setupTestDB(t)
 	defer db.Close()
 	cleanupTestDB(t, db)
 
+	testUser := model.User{
+		Username: "mergepatch_test",
+		Email:    "mergepatch_test@example.com",
+		FullName: "Merge Patch",
+	}
+	uuid := insertTestUser(t, db, testUser)
+
 	router := setupRouter(db)
 
-	// When: Sending a POST request to /api/v1/users with user data
-	newUser := model.User{
-		Username: "newuser_test",
-		Email:    "newuser_test@example.com",
-		FullName: "New User",
-	}
-	body, _ := json.Marshal(newUser)
-	req, _ := http.NewRequest("POST", "/api/v1/users", bytes.NewBuffer(body))
-	req.Header.Set("Content-Type", "application/json")
+	// When: Sending a merge patch that only changes full_name
+	req, _ := http.NewRequest("PATCH", "/api/v1/users/"+uuid, bytes.NewBufferString(`{"full_name":"Renamed User"}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
 	rr := httptest.NewRecorder()
 	router.ServeHTTP(rr, req)
 
-	// Then: The response status should be 201 Created and user should be created in the database
-	if rr.Code != http.StatusCreated {
-		t.Errorf("expected status 201, got %d", rr.Code)
+	// Then: Only full_name changes and the other fields are kept
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	userFromDB := getUserByUUID(t, db, uuid)
+	if userFromDB.FullName != "Renamed User" {
+		t.Errorf("expected full name Renamed User, got %s", userFromDB.FullName)
+	}
+	if userFromDB.Username != testUser.Username || userFromDB.Email != testUser.Email {
+		t.Errorf("expected username and email to be unchanged, got %s and %s", userFromDB.Username, userFromDB.Email)
+	}
+
+	// When: Sending a merge patch that clears full_name
+	req, _ = http.NewRequest("PATCH", "/api/v1/users/"+uuid, bytes.NewBufferString(`{"full_name":null}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: full_name is cleared
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	if userFromDB = getUserByUUID(t, db, uuid); userFromDB.FullName != "" {
+		t.Errorf("expected empty full name, got %s", userFromDB.FullName)
+	}
+}
+
+func TestUpdateUser_MergePatchNullRequiredField(t *testing.T) {
+	// Given: A user exists in the database with UUID
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	uuid := insertTestUser(t, db, model.User{
+		Username: "nullpatch_test",
+		Email:    "nullpatch_test@example.com",
+		FullName: "Null Patch",
+	})
+
+	router := setupRouter(db)
+
+	// When: Sending a merge patch that clears the required email
+	req, _ := http.NewRequest("PATCH", "/api/v1/users/"+uuid, bytes.NewBufferString(`{"email":null}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: The response status should be 422 Unprocessable Entity
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422, got %d", rr.Code)
+	}
+}
+
+func TestUpdateUser_JSONPatch(t *testing.T) {
+	// Given: A user exists in the database with UUID
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	uuid := insertTestUser(t, db, model.User{
+		Username: "jsonpatch_test",
+		Email:    "jsonpatch_test@example.com",
+		FullName: "JSON Patch",
+	})
+
+	router := setupRouter(db)
+
+	// When: Replacing the email guarded by a test of its current value
+	ops := `[
+		{"op": "test", "path": "/email", "value": "jsonpatch_test@example.com"},
+		{"op": "replace", "path": "/email", "value": "patched_test@example.com"}
+	]`
+	req, _ := http.NewRequest("PATCH", "/api/v1/users/"+uuid, bytes.NewBufferString(ops))
+	req.Header.Set("Content-Type", "application/json-patch+json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: The email is replaced
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	if userFromDB := getUserByUUID(t, db, uuid); userFromDB.Email != "patched_test@example.com" {
+		t.Errorf("expected email patched_test@example.com, got %s", userFromDB.Email)
+	}
+}
+
+func TestUpdateUser_JSONPatchFailedTest(t *testing.T) {
+	// Given: A user exists in the database with UUID
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	uuid := insertTestUser(t, db, model.User{
+		Username: "jsonpatchfail_test",
+		Email:    "jsonpatchfail_test@example.com",
+		FullName: "JSON Patch",
+	})
+
+	router := setupRouter(db)
+
+	// When: The test operation does not match the current email
+	ops := `[
+		{"op": "replace", "path": "/full_name", "value": "Should Not Apply"},
+		{"op": "test", "path": "/email", "value": "someone_else@example.com"},
+		{"op": "replace", "path": "/email", "value": "patched_test@example.com"}
+	]`
+	req, _ := http.NewRequest("PATCH", "/api/v1/users/"+uuid, bytes.NewBufferString(ops))
+	req.Header.Set("Content-Type", "application/json-patch+json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: The response status should be 409 Conflict and nothing is changed
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected status 409, got %d", rr.Code)
+	}
+	userFromDB := getUserByUUID(t, db, uuid)
+	if userFromDB.Email != "jsonpatchfail_test@example.com" || userFromDB.FullName != "JSON Patch" {
+		t.Errorf("expected user to be unchanged, got %+v", userFromDB)
+	}
+}
+
+func TestReplaceUser_Success(t *testing.T) {
+	// Given: A user exists in the database with UUID
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	uuid := insertTestUser(t, db, model.User{
+		Username: "replace_test",
+		Email:    "replace_test@example.com",
+		FullName: "Replace User",
+	})
+
+	router := setupRouter(db)
+
+	// When: Sending a PUT request without full_name
+	req, _ := http.NewRequest("PUT", "/api/v1/users/"+uuid, bytes.NewBufferString(`{"username":"replaced_test","email":"replaced_test@example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: Every mutable field is replaced, including the omitted full_name
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	userFromDB := getUserByUUID(t, db, uuid)
+	if userFromDB.Username != "replaced_test" || userFromDB.FullName != "" {
+		t.Errorf("expected replaced user, got %+v", userFromDB)
+	}
+}
+
+func TestCreateUser_Success(t *testing.T) {
+	// Given: No user exists with the given username/email
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	router := setupRouter(db)
+
+	// When: Sending a POST request to /api/v1/users with user data
+	newUser := model.User{
+		Username: "newuser_test",
+		Email:    "newuser_test@example.com",
+		FullName: "New User",
+	}
+	body, _ := json.Marshal(newUser)
+	req, _ := http.NewRequest("POST", "/api/v1/users", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: The response status should be 201 Created and user should be created in the database
+	if rr.Code != http.StatusCreated {
+		t.Errorf("expected status 201, got %d", rr.Code)
 	}
 
 	var createdUser model.User
@@ -483,8 +1120,1861 @@ func TestCreateUser_DuplicateUsername(t *testing.T) {
 	rr := httptest.NewRecorder()
 	router.ServeHTTP(rr, req)
 
-	// Then: The response status should be 409 Conflict
+	// Then: The response status should be 409 Conflict naming the conflicting field
 	if rr.Code != http.StatusConflict {
 		t.Errorf("expected status 409, got %d", rr.Code)
 	}
+	p := decodeProblem(t, rr)
+	if p.Field != "username" {
+		t.Errorf("expected conflict field username, got %s", p.Field)
+	}
+}
+
+func TestCreateUser_ValidationFailed(t *testing.T) {
+	// Given: No users exist in the database
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	router := setupRouter(db)
+
+	// When: Sending a POST request with an empty username and a malformed email
+	newUser := model.User{
+		Username: "",
+		Email:    "not-an-email",
+		FullName: "Invalid User",
+	}
+	body, _ := json.Marshal(newUser)
+	req, _ := http.NewRequest("POST", "/api/v1/users", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: The response status should be 422 with an error for each invalid field
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422, got %d", rr.Code)
+	}
+	p := decodeProblem(t, rr)
+	fields := make(map[string]bool)
+	for _, fe := range p.Errors {
+		fields[fe.Field] = true
+	}
+	if !fields["username"] || !fields["email"] || len(fields) != 2 {
+		t.Errorf("expected username and email errors, got %v", p.Errors)
+	}
+}
+
+func TestUserETag_ConditionalRequests(t *testing.T) {
+	// Given: A user exists in the database
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	uuid := insertTestUser(t, db, model.User{
+		Username: "etag_test",
+		Email:    "etag_test@example.com",
+		FullName: "ETag User",
+	})
+
+	router := setupRouter(db)
+
+	// When: Fetching the user
+	req, _ := http.NewRequest("GET", "/api/v1/users/username/etag_test", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: A strong ETag is returned
+	etag := rr.Header().Get("ETag")
+	if etag != `"1"` {
+		t.Fatalf("expected ETag \"1\", got %q", etag)
+	}
+
+	// When: Fetching again with If-None-Match
+	req, _ = http.NewRequest("GET", "/api/v1/users/username/etag_test", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: The response status should be 304 Not Modified
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("expected status 304, got %d", rr.Code)
+	}
+
+	// When: Patching with the current ETag
+	req, _ = http.NewRequest("PATCH", "/api/v1/users/"+uuid, bytes.NewBufferString(`{"full_name":"First Edit"}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("If-Match", etag)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: The update succeeds and the ETag changes
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	if newETag := rr.Header().Get("ETag"); newETag != `"2"` {
+		t.Errorf("expected ETag \"2\", got %q", newETag)
+	}
+
+	// When: Another editor patches with the stale ETag
+	req, _ = http.NewRequest("PATCH", "/api/v1/users/"+uuid, bytes.NewBufferString(`{"full_name":"Second Edit"}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("If-Match", etag)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: The response status should be 412 and the first edit is kept
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected status 412, got %d", rr.Code)
+	}
+	if userFromDB := getUserByUUID(t, db, uuid); userFromDB.FullName != "First Edit" {
+		t.Errorf("expected full name First Edit, got %s", userFromDB.FullName)
+	}
+
+	// When: Deleting with the stale ETag
+	req, _ = http.NewRequest("DELETE", "/api/v1/users/"+uuid, nil)
+	req.Header.Set("If-Match", etag)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: The response status should be 412 and the user still exists
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected status 412, got %d", rr.Code)
+	}
+	if !userExists(t, db, uuid) {
+		t.Error("user was deleted despite a stale If-Match")
+	}
+}
+
+func TestPurgeDeletedUsersJob(t *testing.T) {
+	// Given: One user deleted beyond the retention window, one deleted recently and one live user
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	expired := insertTestUser(t, db, model.User{Username: "expired", Email: "expired@example.com"})
+	recent := insertTestUser(t, db, model.User{Username: "recent", Email: "recent@example.com"})
+	live := insertTestUser(t, db, model.User{Username: "live", Email: "live@example.com"})
+	if _, err := db.Exec(`UPDATE users SET deleted_at = now() - interval '2 hours' WHERE uuid = $1`, expired); err != nil {
+		t.Fatalf("failed to backdate deletion: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE users SET deleted_at = now() WHERE uuid = $1`, recent); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+
+	router, jobs := setupRouterWithJobs(db)
+
+	// When: The purge job runs and its status is requested
+	run, err := jobs.Run(context.Background(), scheduler.PurgeDeletedUsersJob)
+	if err != nil {
+		t.Fatalf("purge job failed: %v", err)
+	}
+
+	req, _ := http.NewRequest("GET", "/api/v1/admin/jobs", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: Only the expired user is removed and the run is reported
+	if run.RowsAffected != 1 {
+		t.Errorf("expected 1 purged user, got %d", run.RowsAffected)
+	}
+	repos := repository.NewRepository(db)
+	for uuid, want := range map[string]bool{expired: false, recent: true, live: true} {
+		user, err := repos.Users.GetByUUID(context.Background(), uuid, true)
+		if err != nil {
+			t.Fatalf("failed to look up user: %v", err)
+		}
+		if (user != nil) != want {
+			t.Errorf("user %s: expected present=%v", uuid, want)
+		}
+	}
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	var response model.JobStatusList
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(response.Items) != 2 || response.Items[0].Name != scheduler.PurgeDeletedUsersJob {
+		t.Fatalf("unexpected jobs %+v", response.Items)
+	}
+	last := response.Items[0].LastRun
+	if last == nil || last.Status != model.JobRunSucceeded || last.RowsAffected != 1 {
+		t.Errorf("unexpected last run %+v", last)
+	}
+}
+
+func TestGetUserHistory(t *testing.T) {
+	// Given: A user that has been created, updated and deleted through the API
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	router := setupRouter(db)
+
+	body, _ := json.Marshal(model.User{Username: "audited", Email: "audited@example.com"})
+	req, _ := http.NewRequest("POST", "/api/v1/users", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var created model.User
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+
+	req, _ = http.NewRequest("PATCH", "/api/v1/users/"+created.UUID, bytes.NewBufferString(`{"email":"changed@example.com"}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set(problem.RequestIDHeader, "patch-request")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+
+	req, _ = http.NewRequest("DELETE", "/api/v1/users/"+created.UUID, nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// When: Requesting the history two entries at a time
+	req, _ = http.NewRequest("GET", "/api/v1/users/"+created.UUID+"/history?limit=2", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: The changes are listed newest first with actor, request id and diff
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	var page model.UserAuditList
+	if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(page.Items) != 2 || page.NextCursor == "" {
+		t.Fatalf("expected 2 entries and a next cursor, got %d entries", len(page.Items))
+	}
+	if page.Items[0].Action != model.AuditActionDelete || page.Items[1].Action != model.AuditActionUpdate {
+		t.Errorf("unexpected actions %s, %s", page.Items[0].Action, page.Items[1].Action)
+	}
+	update := page.Items[1]
+	if update.Actor != "anonymous" || update.RequestID != "patch-request" {
+		t.Errorf("unexpected actor %q or request id %q", update.Actor, update.RequestID)
+	}
+	change, ok := update.Changes["email"]
+	if !ok || change.Old != "audited@example.com" || change.New != "changed@example.com" {
+		t.Errorf("unexpected changes %+v", update.Changes)
+	}
+
+	req, _ = http.NewRequest("GET", "/api/v1/users/"+created.UUID+"/history?limit=2&after="+page.NextCursor, nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(page.Items) != 1 || page.Items[0].Action != model.AuditActionCreate || page.NextCursor != "" {
+		t.Errorf("expected only the create entry on the last page, got %+v", page.Items)
+	}
+}
+
+func TestGetUserHistory_NotFound(t *testing.T) {
+	// Given: No user exists with the given UUID
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	router := setupRouter(db)
+
+	// When: Requesting its history
+	req, _ := http.NewRequest("GET", "/api/v1/users/123e4567-e89b-12d3-a456-426614174000/history", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: The response status should be 404 Not Found
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", rr.Code)
+	}
+}
+
+func TestBatchUsers_BestEffort(t *testing.T) {
+	// Given: An existing user
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	uuid := insertTestUser(t, db, model.User{Username: "existing", Email: "existing@example.com"})
+	router := setupRouter(db)
+
+	// When: Sending a batch mixing valid and failing operations
+	body := `[
+		{"op": "create", "user": {"username": "batch1", "email": "batch1@example.com"}},
+		{"op": "create", "user": {"username": "existing", "email": "other@example.com"}},
+		{"op": "create", "user": {"username": "x", "email": "not-an-email"}},
+		{"op": "update", "uuid": "` + uuid + `", "user": {"username": "existing", "email": "changed@example.com"}},
+		{"op": "delete", "uuid": "123e4567-e89b-12d3-a456-426614174000"},
+		{"op": "create", "user": {"username": "batch2", "email": "batch2@example.com"}}
+	]`
+	req, _ := http.NewRequest("POST", "/api/v1/users:batch", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: Every item reports its own status and the valid ones are applied
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response struct {
+		Items []struct {
+			Index  int              `json:"index"`
+			Status int              `json:"status"`
+			User   *model.User      `json:"user"`
+			Error  *problem.Problem `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	want := []int{http.StatusCreated, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusOK, http.StatusNotFound, http.StatusCreated}
+	if len(response.Items) != len(want) {
+		t.Fatalf("expected %d items, got %d", len(want), len(response.Items))
+	}
+	for i, item := range response.Items {
+		if item.Index != i || item.Status != want[i] {
+			t.Errorf("item %d: expected status %d, got %d", i, want[i], item.Status)
+		}
+	}
+	if e := response.Items[1].Error; e == nil || e.Field != "username" {
+		t.Errorf("expected username conflict, got %+v", e)
+	}
+	if u := getUserByUUID(t, db, uuid); u == nil || u.Email != "changed@example.com" {
+		t.Errorf("expected existing user to be updated, got %+v", u)
+	}
+	if response.Items[5].User == nil || response.Items[5].User.UUID == "" {
+		t.Errorf("expected created user in item 5")
+	}
+}
+
+func TestBatchUsers_Atomic(t *testing.T) {
+	// Given: An existing user
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	insertTestUser(t, db, model.User{Username: "existing", Email: "existing@example.com"})
+	router := setupRouter(db)
+
+	// When: Sending an atomic batch whose second create conflicts
+	body := `[
+		{"op": "create", "user": {"username": "atomic1", "email": "atomic1@example.com"}},
+		{"op": "create", "user": {"username": "atomic2", "email": "existing@example.com"}}
+	]`
+	req, _ := http.NewRequest("POST", "/api/v1/users:batch?atomic=true", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: The batch fails with the conflicting operation and nothing is created
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d", rr.Code)
+	}
+	p := decodeProblem(t, rr)
+	if p.Index == nil || *p.Index != 1 || p.Field != "email" {
+		t.Errorf("expected conflict on email of operation 1, got %+v", p)
+	}
+
+	req, _ = http.NewRequest("GET", "/api/v1/users/username/atomic1", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected atomic1 to be rolled back, got status %d", rr.Code)
+	}
+}
+
+func TestBatchUsers_UnknownMethod(t *testing.T) {
+	// Given: A router
+	db := setupTestDB(t)
+	defer db.Close()
+
+	router := setupRouter(db)
+
+	// When: Calling an unknown custom method
+	req, _ := http.NewRequest("POST", "/api/v1/users:frobnicate", bytes.NewBufferString(`[]`))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: The response status should be 404 Not Found
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", rr.Code)
+	}
+}
+
+func TestUpsertUserByExternalID(t *testing.T) {
+	// Given: No user is linked to the HR id yet
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	router := setupRouter(db)
+	upsert := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("PUT", "/api/v1/users/by-external-id/hr/E-1001", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// When: Upserting the same external id twice
+	first := upsert(`{"username": "hruser", "email": "hruser@example.com", "full_name": "HR User"}`)
+	second := upsert(`{"username": "hruser", "email": "hruser@corp.example.com", "full_name": "HR User"}`)
+
+	// Then: The first call creates the user and the second updates the same user
+	if first.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", first.Code, first.Body.String())
+	}
+	if second.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", second.Code, second.Body.String())
+	}
+	var created, updated model.User
+	if err := json.Unmarshal(first.Body.Bytes(), &created); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if err := json.Unmarshal(second.Body.Bytes(), &updated); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if updated.UUID != created.UUID || updated.Email != "hruser@corp.example.com" {
+		t.Errorf("expected user %s to be updated, got %+v", created.UUID, updated)
+	}
+
+	// And: Another system can link its own id to a different user
+	req, _ := http.NewRequest("PUT", "/api/v1/users/by-external-id/ldap/E-1001", bytes.NewBufferString(`{"username": "ldapuser", "email": "ldapuser@example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Errorf("expected status 201 for a new source, got %d", rr.Code)
+	}
+}
+
+func TestUpsertUserByExternalID_InvalidSource(t *testing.T) {
+	// Given: A router
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	router := setupRouter(db)
+
+	// When: Upserting with a source that is not a lowercase identifier
+	req, _ := http.NewRequest("PUT", "/api/v1/users/by-external-id/HR/1", bytes.NewBufferString(`{"username": "hruser", "email": "hruser@example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: The response status should be 422 naming the source
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422, got %d", rr.Code)
+	}
+	p := decodeProblem(t, rr)
+	if len(p.Errors) != 1 || p.Errors[0].Field != "source" {
+		t.Errorf("unexpected errors %+v", p.Errors)
+	}
+}
+
+func countUsersByUsername(t *testing.T, db *sql.DB, username string) int {
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users WHERE username = $1", username).Scan(&n); err != nil {
+		t.Fatalf("failed to count users: %v", err)
+	}
+	return n
+}
+
+func TestImportUsers_CSV(t *testing.T) {
+	// Given: An existing user
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	insertTestUser(t, db, model.User{Username: "existing", Email: "existing@example.com"})
+	router := setupRouter(db)
+
+	// When: Importing a small CSV file with valid, invalid and conflicting rows
+	body := "email,username,full_name,ignored\n" +
+		"import1@example.com,import1,Import One,x\n" +
+		"not-an-email,import2,,x\n" +
+		"other@example.com,existing,,x\n" +
+		"import3@example.com,import3,Import Three,x\n"
+	req, _ := http.NewRequest("POST", "/api/v1/users/import", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "text/csv")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: The valid rows are created and the report lists the others
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("X-Import-Processed"); got != "4" {
+		t.Errorf("expected 4 processed rows, got %q", got)
+	}
+	if got := rr.Header().Get("X-Import-Failed"); got != "2" {
+		t.Errorf("expected 2 failed rows, got %q", got)
+	}
+	report := "line,field,message\n" +
+		"3,email,must be a valid email address\n" +
+		"4,username,username already exists\n"
+	if rr.Body.String() != report {
+		t.Errorf("unexpected report:\n%s", rr.Body.String())
+	}
+	if countUsersByUsername(t, db, "import1") != 1 || countUsersByUsername(t, db, "import3") != 1 {
+		t.Errorf("expected valid rows to be created")
+	}
+}
+
+func TestImportUsers_DryRun(t *testing.T) {
+	// Given: An empty database
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	router := setupRouter(db)
+
+	// When: Validating an NDJSON file without importing it
+	body := `{"username": "dry1", "email": "dry1@example.com"}` + "\n\n" +
+		`{"username": "dry2", "email": 42}` + "\n"
+	req, _ := http.NewRequest("POST", "/api/v1/users/import?dry_run=true", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: Errors are reported and nothing is created
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("X-Import-Succeeded"); got != "1" {
+		t.Errorf("expected 1 valid row, got %q", got)
+	}
+	if got := rr.Header().Get("X-Import-Failed"); got != "1" {
+		t.Errorf("expected 1 failed row, got %q", got)
+	}
+	if !bytes.Contains(rr.Body.Bytes(), []byte("\n3,,invalid JSON")) {
+		t.Errorf("expected line 3 to be reported, got:\n%s", rr.Body.String())
+	}
+	if countUsersByUsername(t, db, "dry1") != 0 {
+		t.Errorf("expected dry run not to create users")
+	}
+}
+
+func TestImportUsers_UnsupportedMediaType(t *testing.T) {
+	// Given: A router
+	db := setupTestDB(t)
+	defer db.Close()
+	router := setupRouter(db)
+
+	// When: Uploading JSON instead of CSV or NDJSON
+	req, _ := http.NewRequest("POST", "/api/v1/users/import", bytes.NewBufferString(`[]`))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: The request is rejected
+	if rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected status 415, got %d", rr.Code)
+	}
+}
+
+func TestImportUsers_Async(t *testing.T) {
+	// Given: An empty database
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	router := setupRouter(db)
+
+	// When: Uploading a file of unknown length
+	body := "username,email\nasync1,async1@example.com\nx,async2@example.com\n"
+	req, _ := http.NewRequest("POST", "/api/v1/users/import", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "text/csv")
+	req.ContentLength = -1
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: A job is started and eventually finishes with a report
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d: %s", rr.Code, rr.Body.String())
+	}
+	location := rr.Header().Get("Location")
+	if location == "" {
+		t.Fatal("expected Location header")
+	}
+
+	var job struct {
+		model.ImportJob
+		ReportURL string `json:"report_url"`
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		req, _ = http.NewRequest("GET", location, nil)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &job); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+		if job.FinishedAt != nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if job.Status != model.ImportJobSucceeded || job.Succeeded != 1 || job.Failed != 1 {
+		t.Fatalf("unexpected job: %+v", job)
+	}
+
+	req, _ = http.NewRequest("GET", job.ReportURL, nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !bytes.Contains(rr.Body.Bytes(), []byte("\n3,username,")) {
+		t.Errorf("unexpected report %d:\n%s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestExportUsers(t *testing.T) {
+	// Given: Users with different email domains
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	insertTestUser(t, db, model.User{Username: "export_b", Email: "b@acme.com", FullName: "B, Jr."})
+	insertTestUser(t, db, model.User{Username: "export_a", Email: "a@acme.com"})
+	insertTestUser(t, db, model.User{Username: "export_c", Email: "c@example.com"})
+	router := setupRouter(db)
+	query := "?sort=username&filter=" + url.QueryEscape(`email ends_with "@acme.com"`)
+
+	tests := []struct {
+		accept      string
+		contentType string
+		body        string
+	}{
+		{"text/csv", "text/csv; charset=utf-8", "export_a,a@acme.com,"},
+		{"application/x-ndjson", "application/x-ndjson; charset=utf-8", `"username":"export_a"`},
+		{"", "application/json; charset=utf-8", `"username":"export_a"`},
+	}
+	for _, tt := range tests {
+		// When: Exporting the filtered users in each format
+		req, _ := http.NewRequest("GET", "/api/v1/users/export"+query, nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		// Then: Only the matching users are streamed in the negotiated format
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d: %s", tt.accept, rr.Code, rr.Body.String())
+		}
+		if got := rr.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("%s: expected Content-Type %q, got %q", tt.accept, tt.contentType, got)
+		}
+		body := rr.Body.String()
+		if !strings.Contains(body, tt.body) || !strings.Contains(body, "export_b") || strings.Contains(body, "export_c") {
+			t.Errorf("%s: unexpected body:\n%s", tt.accept, body)
+		}
+		if strings.Index(body, "export_a") > strings.Index(body, "export_b") {
+			t.Errorf("%s: expected users sorted by username", tt.accept)
+		}
+	}
+
+	var users []model.User
+	req, _ := http.NewRequest("GET", "/api/v1/users/export"+query, nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if err := json.Unmarshal(rr.Body.Bytes(), &users); err != nil || len(users) != 2 {
+		t.Errorf("expected a JSON array of 2 users, got %v: %s", err, rr.Body.String())
+	}
+}
+
+func TestExportUsers_Errors(t *testing.T) {
+	// Given: A router
+	db := setupTestDB(t)
+	defer db.Close()
+	router := setupRouter(db)
+
+	tests := []struct {
+		name   string
+		query  string
+		accept string
+		status int
+	}{
+		{"unsupported media type", "", "application/xml", http.StatusNotAcceptable},
+		{"invalid filter", "?filter=" + url.QueryEscape("nope = 1"), "text/csv", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// When: Requesting an export that cannot be served
+			req, _ := http.NewRequest("GET", "/api/v1/users/export"+tt.query, nil)
+			req.Header.Set("Accept", tt.accept)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			// Then: A problem is returned before anything is streamed
+			if rr.Code != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, rr.Code)
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != problem.ContentType {
+				t.Errorf("expected problem content type, got %q", ct)
+			}
+		})
+	}
+}
+
+func TestExportUsers_NoTimeout(t *testing.T) {
+	// Given: A router whose request deadline expires immediately except for exports
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	gin.SetMode(gin.TestMode)
+	repositories := repository.NewRepository(db)
+	services := service.NewService(repositories)
+	controllers := controller.NewController(services, scheduler.New(repositories.Jobs))
+	router := gin.New()
+	router.Use(middleware.RequestTimeoutMiddleware(time.Nanosecond, "/api/v1/users/export"))
+	New(router, controllers, Middleware{Idempotency: middleware.IdempotencyMiddleware(repositories.Idempotency, 0)})
+
+	// When: Exporting users
+	req, _ := http.NewRequest("GET", "/api/v1/users/export", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// Then: The export is not cut off by the deadline
+	if rr.Code != http.StatusOK || rr.Body.String() != "[]\n" {
+		t.Errorf("expected an empty export, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestCreateUser_IdempotencyKey(t *testing.T) {
+	// Given: A router with idempotency keys enabled
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	router := setupRouter(db)
+	send := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/api/v1/users", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(middleware.IdempotencyKeyHeader, "create-retry-1")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	body := `{"username": "idempotent", "email": "idempotent@example.com"}`
+
+	// When: Sending the same create request twice
+	first := send(body)
+	second := send(body)
+
+	// Then: The user is created once and the retry replays the first response
+	if first.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", first.Code, first.Body.String())
+	}
+	if second.Code != http.StatusCreated || second.Body.String() != first.Body.String() {
+		t.Errorf("expected replayed 201, got %d: %s", second.Code, second.Body.String())
+	}
+	if second.Header().Get(middleware.IdempotentReplayedHeader) != "true" {
+		t.Errorf("expected %s header on the retry", middleware.IdempotentReplayedHeader)
+	}
+	if second.Header().Get("ETag") != first.Header().Get("ETag") {
+		t.Errorf("expected replayed ETag %q, got %q", first.Header().Get("ETag"), second.Header().Get("ETag"))
+	}
+	if n := countUsersByUsername(t, db, "idempotent"); n != 1 {
+		t.Errorf("expected 1 user, got %d", n)
+	}
+
+	// When: Reusing the key for a different body
+	rr := send(`{"username": "other", "email": "other@example.com"}`)
+
+	// Then: The request is rejected
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422, got %d", rr.Code)
+	}
+	if p := decodeProblem(t, rr); p.Type != problem.TypeIdempotency {
+		t.Errorf("expected %s problem, got %s", problem.TypeIdempotency, p.Type)
+	}
+}
+
+func TestIdempotencyKey_InFlight(t *testing.T) {
+	// Given: A request with an Idempotency-Key that is still being handled
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	repos := repository.NewRepository(db)
+	entered, release := make(chan struct{}), make(chan struct{})
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/slow", middleware.IdempotencyMiddleware(repos.Idempotency, time.Hour), func(c *gin.Context) {
+		close(entered)
+		<-release
+		c.String(http.StatusOK, "done")
+	})
+	send := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/slow", bytes.NewBufferString("{}"))
+		req.Header.Set(middleware.IdempotencyKeyHeader, "slow-1")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- send() }()
+	<-entered
+
+	// When: A duplicate arrives
+	rr := send()
+	close(release)
+	first := <-done
+
+	// Then: The duplicate is told to retry later and the first one completes
+	if rr.Code != http.StatusConflict || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("expected 409 with Retry-After, got %d", rr.Code)
+	}
+	if first.Code != http.StatusOK {
+		t.Errorf("expected first request to succeed, got %d", first.Code)
+	}
+}
+
+func TestIdempotencyKey_ServerErrorsAreNotStored(t *testing.T) {
+	// Given: A key whose first request failed with a server error
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	repos := repository.NewRepository(db)
+	calls := 0
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/flaky", middleware.IdempotencyMiddleware(repos.Idempotency, time.Hour), func(c *gin.Context) {
+		calls++
+		if calls == 1 {
+			c.Status(http.StatusServiceUnavailable)
+			return
+		}
+		c.String(http.StatusOK, "ok")
+	})
+	send := func() int {
+		req, _ := http.NewRequest("POST", "/flaky", nil)
+		req.Header.Set(middleware.IdempotencyKeyHeader, "flaky-1")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// When: Retrying with the same key
+	first, second, third := send(), send(), send()
+
+	// Then: The retry runs the handler again and its success is replayed
+	if first != http.StatusServiceUnavailable || second != http.StatusOK || third != http.StatusOK {
+		t.Errorf("unexpected statuses %d, %d, %d", first, second, third)
+	}
+	if calls != 2 {
+		t.Errorf("expected handler to run twice, got %d", calls)
+	}
+}
+
+// setupRouterWithAuth creates a router that authenticates API keys, with
+// envKey as the API_KEY environment key.
+func setupRouterWithAuth(t *testing.T, db *sql.DB, envKey string) (*gin.Engine, *service.Service) {
+	return setupRouterWithBearer(t, db, envKey, nil)
+}
+
+// setupRouterWithBearer additionally accepts bearer tokens checked by
+// verifier, unless it is nil.
+func setupRouterWithBearer(t *testing.T, db *sql.DB, envKey string, verifier middleware.TokenVerifier) (*gin.Engine, *service.Service) {
+	t.Setenv("API_KEY", envKey)
+	gin.SetMode(gin.TestMode)
+
+	repositories := repository.NewRepository(db)
+	services := service.NewService(repositories)
+	mw := Middleware{
+		APIKeyAuth:  middleware.APIKeyAuthMiddleware(services.APIKeys, false),
+		Audit:       middleware.AuditMiddleware(),
+		Idempotency: middleware.IdempotencyMiddleware(repositories.Idempotency, 0),
+
+		UserTokenIssuer: testOAuthIssuer,
+	}
+	if verifier != nil {
+		mw.BearerAuth = middleware.BearerAuthMiddleware(verifier)
+	}
+	router := gin.New()
+	New(router, controller.NewController(services, scheduler.New(repositories.Jobs)), mw)
+	return router, services
+}
+
+// sendWithKey sends a JSON request authenticated with key.
+func sendWithKey(router *gin.Engine, method, path, key, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(middleware.APIKeyHeader, key)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	// Given: Authentication with an environment key and a read-only database key
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	router, services := setupRouterWithAuth(t, db, "env-secret")
+	readKey, err := services.APIKeys.Create(context.Background(), &model.APIKey{
+		Name: "reporting", Owner: "analytics", Scopes: []string{model.ScopeUsersRead},
+	})
+	if err != nil {
+		t.Fatalf("failed to create API key: %v", err)
+	}
+	expiresAt := time.Now().Add(time.Hour)
+	expiredKey, err := services.APIKeys.Create(context.Background(), &model.APIKey{
+		Name: "old", Owner: "analytics", Scopes: []string{model.ScopeAdmin}, ExpiresAt: &expiresAt,
+	})
+	if err != nil {
+		t.Fatalf("failed to create API key: %v", err)
+	}
+	if _, err := db.Exec("UPDATE api_keys SET expires_at = now() - interval '1 second' WHERE name = 'old'"); err != nil {
+		t.Fatalf("failed to expire API key: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		key    string
+		status int
+	}{
+		{"missing key", "GET", "/api/v1/users", "", http.StatusUnauthorized},
+		{"unknown key", "GET", "/api/v1/users", "crd_000000000000_nope", http.StatusForbidden},
+		{"expired key", "GET", "/api/v1/users", expiredKey, http.StatusForbidden},
+		{"read scope", "GET", "/api/v1/users", readKey, http.StatusOK},
+		{"write without scope", "POST", "/api/v1/users", readKey, http.StatusForbidden},
+		{"admin without scope", "GET", "/api/v1/admin/jobs", readKey, http.StatusForbidden},
+		{"environment key", "GET", "/api/v1/admin/jobs", "env-secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// When: Calling the endpoint with the key
+			rr := sendWithKey(router, tt.method, tt.path, tt.key, `{"username": "scoped", "email": "scoped@example.com"}`)
+
+			// Then: The key's scopes decide the outcome
+			if rr.Code != tt.status {
+				t.Errorf("expected status %d, got %d: %s", tt.status, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	var lastUsed sql.NullTime
+	if err := db.QueryRow("SELECT last_used_at FROM api_keys WHERE name = 'reporting'").Scan(&lastUsed); err != nil || !lastUsed.Valid {
+		t.Errorf("expected last_used_at to be recorded, got %v (%v)", lastUsed, err)
+	}
+}
+
+func TestAPIKeyAuthWithoutEnvironmentKey(t *testing.T) {
+	// Given: Authentication with database keys only
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	router, services := setupRouterWithAuth(t, db, "")
+	adminKey, err := services.APIKeys.Create(context.Background(), &model.APIKey{
+		Name: "ops", Owner: "platform", Scopes: []string{model.ScopeAdmin},
+	})
+	if err != nil {
+		t.Fatalf("failed to create API key: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		key    string
+		status int
+	}{
+		{"users without key", "/api/v1/users", "", http.StatusUnauthorized},
+		{"admin without key", "/api/v1/admin/api-keys", "", http.StatusUnauthorized},
+		{"admin with key", "/api/v1/admin/api-keys", adminKey, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// When: Calling the endpoint
+			rr := sendWithKey(router, "GET", tt.path, tt.key, "")
+
+			// Then: A missing API_KEY does not let requests without a key through
+			if rr.Code != tt.status {
+				t.Errorf("expected status %d, got %d: %s", tt.status, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestAPIKeyManagement(t *testing.T) {
+	// Given: An admin authenticated with the environment key
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	router, _ := setupRouterWithAuth(t, db, "env-secret")
+
+	// When: Creating a key
+	rr := sendWithKey(router, "POST", "/api/v1/admin/api-keys", "env-secret",
+		`{"name": "crm sync", "owner": "integrations", "scopes": ["users:read"]}`)
+
+	// Then: The secret is returned once, uncached
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("expected Cache-Control: no-store, got %q", rr.Header().Get("Cache-Control"))
+	}
+	var created model.IssuedAPIKey
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil || created.Key == "" {
+		t.Fatalf("expected an issued key, got %s", rr.Body.String())
+	}
+	if rr := sendWithKey(router, "GET", "/api/v1/users", created.Key, ""); rr.Code != http.StatusOK {
+		t.Errorf("expected new key to work, got %d", rr.Code)
+	}
+	rr = sendWithKey(router, "GET", "/api/v1/admin/api-keys/"+created.ID, "env-secret", "")
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), created.Key) {
+		t.Errorf("expected key without secret, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// When: Rotating it with a grace period
+	rr = sendWithKey(router, "POST", "/api/v1/admin/api-keys/"+created.ID+"/rotate", "env-secret", `{"grace_period": "1h"}`)
+
+	// Then: Both the old and the new key work until the old one is revoked
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var rotated model.IssuedAPIKey
+	if err := json.Unmarshal(rr.Body.Bytes(), &rotated); err != nil || rotated.Key == "" || rotated.Key == created.Key {
+		t.Fatalf("expected a new key, got %s", rr.Body.String())
+	}
+	for _, key := range []string{created.Key, rotated.Key} {
+		if rr := sendWithKey(router, "GET", "/api/v1/users", key, ""); rr.Code != http.StatusOK {
+			t.Errorf("expected key to work during the grace period, got %d", rr.Code)
+		}
+	}
+	if rr := sendWithKey(router, "POST", "/api/v1/admin/api-keys/"+created.ID+"/rotate", "env-secret", ""); rr.Code != http.StatusConflict {
+		t.Errorf("expected second rotation to conflict, got %d", rr.Code)
+	}
+
+	if rr := sendWithKey(router, "DELETE", "/api/v1/admin/api-keys/"+created.ID, "env-secret", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", rr.Code)
+	}
+	if rr := sendWithKey(router, "GET", "/api/v1/users", created.Key, ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expected revoked key to be rejected, got %d", rr.Code)
+	}
+
+	// Then: Its use and the rejected attempt are recorded
+	rr = sendWithKey(router, "GET", "/api/v1/admin/api-keys/"+created.ID+"/events", "env-secret", "")
+	var events model.APIKeyEventList
+	if err := json.Unmarshal(rr.Body.Bytes(), &events); err != nil {
+		t.Fatalf("failed to unmarshal events: %v", err)
+	}
+	if len(events.Items) != 2 ||
+		events.Items[0].Event != model.APIKeyEventRejected || events.Items[0].Reason != model.APIKeyRejectedRevoked ||
+		events.Items[1].Event != model.APIKeyEventUsed {
+		t.Errorf("unexpected events %+v", events.Items)
+	}
+
+	rr = sendWithKey(router, "GET", "/api/v1/admin/api-keys", "env-secret", "")
+	var list model.APIKeyList
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil || len(list.Items) != 1 || list.Items[0].ID != rotated.ID {
+		t.Errorf("expected only the new key to be listed, got %s", rr.Body.String())
+	}
+}
+
+func TestAPIKeyManagement_NotFound(t *testing.T) {
+	// Given: An admin
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	router, _ := setupRouterWithAuth(t, db, "env-secret")
+
+	// When: Revoking a key that does not exist, by a malformed and a well-formed id
+	for _, id := range []string{"nope", "123e4567-e89b-12d3-a456-426614174000"} {
+		rr := sendWithKey(router, "DELETE", "/api/v1/admin/api-keys/"+id, "env-secret", "")
+
+		// Then: 404 is returned
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("%s: expected status 404, got %d", id, rr.Code)
+		}
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+	// Given: Bearer tokens signed with a key from a JWKS file, next to API keys
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	jwks := filepath.Join(t.TempDir(), "jwks.json")
+	data := `{"keys":[{"kty":"oct","kid":"k1","alg":"HS256","k":"MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY"}]}`
+	if err := os.WriteFile(jwks, []byte(data), 0o600); err != nil {
+		t.Fatalf("failed to write JWKS: %v", err)
+	}
+	verifier := auth.NewVerifier(auth.NewFileKeySet(jwks, 0), "https://issuer.example.com", "cruder")
+	router, _ := setupRouterWithBearer(t, db, "env-secret", verifier)
+
+	token := func(scope string, expiresIn time.Duration) string {
+		tok := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"sub":   "frontend-user",
+			"iss":   "https://issuer.example.com",
+			"aud":   "cruder",
+			"exp":   time.Now().Add(expiresIn).Unix(),
+			"scope": scope,
+		})
+		tok.Header["kid"] = "k1"
+		signed, err := tok.SignedString(secret)
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+		return signed
+	}
+	reader := token(model.ScopeUsersRead, time.Hour)
+	writer := token(model.ScopeUsersRead+" "+model.ScopeUsersWrite, time.Hour)
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		token   string
+		key     string
+		status  int
+		invalid bool
+	}{
+		{"read scope", "GET", "/api/v1/users", reader, "", http.StatusOK, false},
+		{"write without scope", "POST", "/api/v1/users", reader, "", http.StatusForbidden, false},
+		{"write scope", "POST", "/api/v1/users", writer, "", http.StatusCreated, false},
+		{"expired token", "GET", "/api/v1/users", token(model.ScopeUsersRead, -time.Hour), "", http.StatusUnauthorized, true},
+		{"tampered token", "GET", "/api/v1/users", writer + "x", "", http.StatusUnauthorized, true},
+		{"admin needs an API key", "GET", "/api/v1/admin/jobs", token(model.ScopeAdmin, time.Hour), "", http.StatusUnauthorized, false},
+		{"token wins over a key", "POST", "/api/v1/users:batch", reader, "env-secret", http.StatusForbidden, false},
+		{"API key still works", "GET", "/api/v1/users", "", "env-secret", http.StatusOK, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// When: Calling the endpoint with the token
+			req, _ := http.NewRequest(tt.method, tt.path, bytes.NewBufferString(`{"username": "bearer", "email": "bearer@example.com"}`))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.key != "" {
+				req.Header.Set(middleware.APIKeyHeader, tt.key)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			// Then: The token's scopes and the route group decide the outcome
+			if rr.Code != tt.status {
+				t.Errorf("expected status %d, got %d: %s", tt.status, rr.Code, rr.Body.String())
+			}
+			if tt.invalid && rr.Header().Get("WWW-Authenticate") != `Bearer error="invalid_token"` {
+				t.Errorf("expected a Bearer challenge, got %q", rr.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+
+	// Then: Changes are attributed to the token subject
+	var actor string
+	if err := db.QueryRow("SELECT actor FROM user_audit_log ORDER BY id DESC LIMIT 1").Scan(&actor); err != nil {
+		t.Fatalf("failed to read audit log: %v", err)
+	}
+	if actor != "jwt:frontend-user" {
+		t.Errorf("expected actor jwt:frontend-user, got %q", actor)
+	}
+
+	// When: The identity provider issues a token whose subject is a user
+	rr := sendWithKey(router, "POST", "/api/v1/users", "env-secret", `{"username": "alice", "email": "alice@example.com"}`)
+	var alice model.User
+	if rr.Code != http.StatusCreated || json.Unmarshal(rr.Body.Bytes(), &alice) != nil {
+		t.Fatalf("failed to create user: %d %s", rr.Code, rr.Body.String())
+	}
+	tok := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": alice.UUID,
+		"iss": "https://issuer.example.com",
+		"aud": "cruder",
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"amr": []string{auth.AMRPassword},
+	})
+	tok.Header["kid"] = "k1"
+	impostor, err := tok.SignedString(secret)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	// Then: It does not act as the user, whose account only logins here manage
+	for _, path := range []string{"/api/v1/users/" + alice.UUID + "/password", "/api/v1/users/" + alice.UUID + "/mfa/totp"} {
+		if rr := sendWithBearer(router, "POST", path, impostor, `{"new_password": "a brand new password"}`); rr.Code != http.StatusForbidden {
+			t.Errorf("%s: expected status 403, got %d: %s", path, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+const testOAuthIssuer = "https://cruder.example.com"
+
+// testArgon2Params keep password hashing fast in tests.
+var testArgon2Params = auth.Argon2Params{Memory: 8 * 1024, Time: 1, Threads: 1}
+
+// setupRouterWithOAuth enables the token endpoint with a new signing key and
+// accepts the tokens it issues as bearer tokens, and encrypts TOTP secrets
+// with a new key. API_KEY is "env-secret".
+func setupRouterWithOAuth(t *testing.T, db *sql.DB) *gin.Engine {
+	router, _ := setupRouterWithMail(t, db)
+	return router
+}
+
+// testMailer hands sent messages to the test.
+type testMailer chan *mail.Message
+
+func (m testMailer) Send(_ context.Context, msg *mail.Message) error {
+	m <- msg
+	return nil
+}
+
+// receive returns the next message, failing the test if none is sent soon.
+func (m testMailer) receive(t *testing.T) *mail.Message {
+	t.Helper()
+	select {
+	case msg := <-m:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an email")
+		return nil
+	}
+}
+
+// mailedToken extracts the token from a message sent without link pages.
+func mailedToken(t *testing.T, msg *mail.Message) string {
+	t.Helper()
+	for _, line := range strings.Split(msg.Body, "\n") {
+		if len(line) == 43 && !strings.Contains(line, " ") {
+			return line
+		}
+	}
+	t.Fatalf("no token in %q", msg.Body)
+	return ""
+}
+
+// setupRouterWithMail is setupRouterWithOAuth that also returns the mailer
+// of the account flows.
+func setupRouterWithMail(t *testing.T, db *sql.DB) (*gin.Engine, testMailer) {
+	t.Setenv("API_KEY", "env-secret")
+	gin.SetMode(gin.TestMode)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate signing key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to encode signing key: %v", err)
+	}
+	keys, err := auth.ParseSigningKeys(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatalf("failed to parse signing key: %v", err)
+	}
+
+	repositories := repository.NewRepository(db)
+	services := service.NewService(repositories)
+	services.Tokens = service.NewTokenService(repositories, keys, service.TokenConfig{Issuer: testOAuthIssuer})
+	mailer := make(testMailer, 10)
+	mailConfig := service.MailConfig{Mailer: mailer}
+	services.Passwords = service.NewPasswordService(repositories, auth.NewPasswordHasher(testArgon2Params), mailConfig)
+	services.EmailVerification = service.NewEmailVerificationService(repositories, mailConfig)
+	mfaKey := make([]byte, 32)
+	if _, err := rand.Read(mfaKey); err != nil {
+		t.Fatalf("failed to generate MFA key: %v", err)
+	}
+	mfaKeys, err := auth.ParseSecretBoxKeys(base64.StdEncoding.EncodeToString(mfaKey))
+	if err != nil {
+		t.Fatalf("failed to parse MFA key: %v", err)
+	}
+	services.MFA = service.NewMFAService(repositories, mfaKeys, "")
+	router := gin.New()
+	New(router, controller.NewController(services, scheduler.New(repositories.Jobs)), Middleware{
+		BearerAuth: middleware.BearerAuthMiddleware(auth.NewVerifier(keys, testOAuthIssuer, testOAuthIssuer)),
+		APIKeyAuth: middleware.APIKeyAuthMiddleware(services.APIKeys, false),
+		Audit:      middleware.AuditMiddleware(),
+
+		UserTokenIssuer: testOAuthIssuer,
+	})
+	return router, mailer
+}
+
+// postForm sends a form encoded request, with HTTP Basic credentials unless
+// clientID is empty.
+func postForm(router *gin.Engine, path string, form url.Values, clientID, secret string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if clientID != "" {
+		req.SetBasicAuth(clientID, secret)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+// registerOAuthClient registers a client with the admin API.
+func registerOAuthClient(t *testing.T, router *gin.Engine, body string) model.IssuedOAuthClient {
+	t.Helper()
+	rr := sendWithKey(router, "POST", "/api/v1/admin/oauth-clients", "env-secret", body)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	var client model.IssuedOAuthClient
+	if err := json.Unmarshal(rr.Body.Bytes(), &client); err != nil || client.Secret == "" {
+		t.Fatalf("failed to decode client: %v (%s)", err, rr.Body.String())
+	}
+	return client
+}
+
+func decodeOAuthError(t *testing.T, rr *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	var body struct {
+		Error string `json:"error"`
+	}
+	if rr.Code != status || json.Unmarshal(rr.Body.Bytes(), &body) != nil || body.Error != code {
+		t.Errorf("expected %d %s, got %d: %s", status, code, rr.Code, rr.Body.String())
+	}
+}
+
+func TestOAuthClientCredentials(t *testing.T) {
+	// Given: A registered client that may read users and refresh its tokens
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	router := setupRouterWithOAuth(t, db)
+	client := registerOAuthClient(t, router,
+		`{"name": "billing", "owner": "payments", "scopes": ["users:read"], "grant_types": ["client_credentials", "refresh_token"]}`)
+	gateway := registerOAuthClient(t, router, `{"name": "gateway", "owner": "platform", "scopes": ["users:read"]}`)
+
+	// When: Requesting a token with HTTP Basic client authentication
+	rr := postForm(router, "/oauth/token", url.Values{"grant_type": {"client_credentials"}}, client.ID, client.Secret)
+
+	// Then: A short-lived access token and a refresh token are issued
+	if rr.Code != http.StatusOK || rr.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("expected an uncached 200, got %d (%q): %s", rr.Code, rr.Header().Get("Cache-Control"), rr.Body.String())
+	}
+	var issued model.TokenResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &issued); err != nil {
+		t.Fatalf("failed to decode token response: %v", err)
+	}
+	if issued.TokenType != "Bearer" || issued.Scope != model.ScopeUsersRead || issued.ExpiresIn != 900 || issued.RefreshToken == "" {
+		t.Errorf("unexpected token response %+v", issued)
+	}
+
+	// Then: The access token authenticates API requests within its scope
+	for method, status := range map[string]int{"GET": http.StatusOK, "POST": http.StatusForbidden} {
+		req, _ := http.NewRequest(method, "/api/v1/users", strings.NewReader(`{"username": "svc", "email": "svc@example.com"}`))
+		req.Header.Set("Authorization", "Bearer "+issued.AccessToken)
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != status {
+			t.Errorf("%s /api/v1/users: expected status %d, got %d: %s", method, status, rr.Code, rr.Body.String())
+		}
+	}
+
+	// Then: Another client can introspect it, authenticating with form parameters
+	rr = postForm(router, "/oauth/introspect", url.Values{
+		"token": {issued.AccessToken}, "client_id": {gateway.ID}, "client_secret": {gateway.Secret},
+	}, "", "")
+	var info model.Introspection
+	if err := json.Unmarshal(rr.Body.Bytes(), &info); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("failed to introspect: %d %s", rr.Code, rr.Body.String())
+	}
+	if !info.Active || info.ClientID != client.ID || info.Scope != model.ScopeUsersRead || info.Issuer != testOAuthIssuer {
+		t.Errorf("unexpected introspection %+v", info)
+	}
+
+	// When: Refreshing, then presenting the used refresh token again
+	rr = postForm(router, "/oauth/token", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {issued.RefreshToken}}, client.ID, client.Secret)
+	var refreshed model.TokenResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &refreshed); err != nil || rr.Code != http.StatusOK || refreshed.RefreshToken == issued.RefreshToken {
+		t.Fatalf("expected a rotated refresh token, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = postForm(router, "/oauth/token", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {issued.RefreshToken}}, client.ID, client.Secret)
+
+	// Then: The reuse is rejected and revokes the successor too
+	decodeOAuthError(t, rr, http.StatusBadRequest, "invalid_grant")
+	rr = postForm(router, "/oauth/token", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshed.RefreshToken}}, client.ID, client.Secret)
+	decodeOAuthError(t, rr, http.StatusBadRequest, "invalid_grant")
+
+	// When: The client is revoked
+	if rr := sendWithKey(router, "DELETE", "/api/v1/admin/oauth-clients/"+client.ID, "env-secret", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusNoContent, rr.Code, rr.Body.String())
+	}
+
+	// Then: Its tokens are reported inactive and it cannot get new ones
+	rr = postForm(router, "/oauth/introspect", url.Values{"token": {issued.AccessToken}}, gateway.ID, gateway.Secret)
+	if rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != `{"active":false}` {
+		t.Errorf("expected an inactive token, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = postForm(router, "/oauth/token", url.Values{"grant_type": {"client_credentials"}}, client.ID, client.Secret)
+	decodeOAuthError(t, rr, http.StatusUnauthorized, "invalid_client")
+}
+
+func TestOAuthToken_Errors(t *testing.T) {
+	// Given: A client without the refresh token grant
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	router := setupRouterWithOAuth(t, db)
+	client := registerOAuthClient(t, router, `{"name": "reports", "owner": "analytics", "scopes": ["users:read"]}`)
+
+	tests := []struct {
+		name   string
+		form   url.Values
+		secret string
+		status int
+		code   string
+	}{
+		{"wrong secret", url.Values{"grant_type": {"client_credentials"}}, "wrong", http.StatusUnauthorized, "invalid_client"},
+		{"missing grant type", url.Values{}, client.Secret, http.StatusBadRequest, "invalid_request"},
+		{"unsupported grant type", url.Values{"grant_type": {"password"}}, client.Secret, http.StatusBadRequest, "unsupported_grant_type"},
+		{"grant not allowed", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"x"}}, client.Secret, http.StatusBadRequest, "unauthorized_client"},
+		{"scope not granted", url.Values{"grant_type": {"client_credentials"}, "scope": {"users:write"}}, client.Secret, http.StatusBadRequest, "invalid_scope"},
+		{"repeated parameter", url.Values{"grant_type": {"client_credentials", "client_credentials"}}, client.Secret, http.StatusBadRequest, "invalid_request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// When: Requesting a token
+			rr := postForm(router, "/oauth/token", tt.form, client.ID, tt.secret)
+
+			// Then: An OAuth error response is returned
+			decodeOAuthError(t, rr, tt.status, tt.code)
+		})
+	}
+
+	// Then: Without refresh tokens, client credentials still issue access tokens
+	rr := postForm(router, "/oauth/token", url.Values{"grant_type": {"client_credentials"}}, client.ID, client.Secret)
+	var issued model.TokenResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &issued); err != nil || rr.Code != http.StatusOK || issued.RefreshToken != "" {
+		t.Errorf("expected an access token only, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Then: The key set that verifies the tokens is published
+	req, _ := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var jwks struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &jwks); err != nil || len(jwks.Keys) != 1 || jwks.Keys[0]["kty"] != "EC" || jwks.Keys[0]["d"] != "" {
+		t.Errorf("expected one public EC key, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+// sendWithBearer sends a JSON request authenticated with an access token.
+func sendWithBearer(router *gin.Engine, method, path, token, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func login(router *gin.Engine, username, password string) (*httptest.ResponseRecorder, string) {
+	body, _ := json.Marshal(model.LoginRequest{Username: username, Password: password})
+	rr := sendWithKey(router, "POST", "/api/v1/auth/login", "", string(body))
+	var issued model.TokenResponse
+	_ = json.Unmarshal(rr.Body.Bytes(), &issued)
+	return rr, issued.AccessToken
+}
+
+func TestPasswordLogin(t *testing.T) {
+	// Given: Two users, one of whom gets a password from an administrator
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	router := setupRouterWithOAuth(t, db)
+	var users [2]model.User
+	for i, name := range []string{"alice", "bob"} {
+		rr := sendWithKey(router, "POST", "/api/v1/users", "env-secret",
+			`{"username": "`+name+`", "email": "`+name+`@example.com", "full_name": "`+name+`"}`)
+		if rr.Code != http.StatusCreated || json.Unmarshal(rr.Body.Bytes(), &users[i]) != nil {
+			t.Fatalf("failed to create %s: %d %s", name, rr.Code, rr.Body.String())
+		}
+	}
+	alice := users[0]
+	passwordPath := "/api/v1/users/" + alice.UUID + "/password"
+
+	rr := sendWithKey(router, "POST", passwordPath, "env-secret", `{"new_password": "alice-secret-1"}`)
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "new_password") {
+		t.Errorf("expected a password containing the username to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = sendWithKey(router, "POST", passwordPath, "env-secret", `{"new_password": "correct horse battery"}`)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusNoContent, rr.Code, rr.Body.String())
+	}
+
+	// When: Logging in with a wrong and then the right password
+	rr, _ = login(router, "alice", "wrong horse battery")
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d: %s", http.StatusUnauthorized, rr.Code, rr.Body.String())
+	}
+	rr, token := login(router, "alice", "correct horse battery")
+
+	// Then: An access token for the user is issued
+	if rr.Code != http.StatusOK || token == "" || rr.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("expected an uncached token, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Then: The token lets the user change their own password, but nothing else
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+	}{
+		{"list users", "/api/v1/users", "", http.StatusForbidden},
+		{"other user", "/api/v1/users/" + users[1].UUID + "/password", `{"new_password": "a brand new password"}`, http.StatusForbidden},
+		{"missing current password", passwordPath, `{"new_password": "a brand new password"}`, http.StatusUnprocessableEntity},
+		{"wrong current password", passwordPath, `{"current_password": "nope", "new_password": "a brand new password"}`, http.StatusForbidden},
+		{"own password", passwordPath, `{"current_password": "correct horse battery", "new_password": "a brand new password"}`, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		method := "POST"
+		if tt.body == "" {
+			method = "GET"
+		}
+		if rr := sendWithBearer(router, method, tt.path, token, tt.body); rr.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.name, tt.status, rr.Code, rr.Body.String())
+		}
+	}
+	if rr, _ := login(router, "alice", "correct horse battery"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected the old password to be rejected, got %d", rr.Code)
+	}
+
+	// Then: The change is audited without revealing the password
+	rr = sendWithKey(router, "GET", "/api/v1/users/"+alice.UUID+"/history", "env-secret", "")
+	var history model.UserAuditList
+	if err := json.Unmarshal(rr.Body.Bytes(), &history); err != nil || len(history.Items) != 3 ||
+		history.Items[0].Action != model.AuditActionPasswordChange || strings.Contains(rr.Body.String(), "argon2") {
+		t.Errorf("expected two audited password changes, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// When: Guessing wrong five times in a row
+	for range 5 {
+		login(router, "alice", "not the password")
+	}
+
+	// Then: The account is locked, and looks like any failed login
+	rr, _ = login(router, "alice", "a brand new password")
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected a locked account, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr, _ = login(router, "bob", "any password at all")
+	if rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Body.String(), "invalid username or password") {
+		t.Errorf("expected a user without password to fail alike, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestPasswordLoginWithoutTokens(t *testing.T) {
+	// Given: A service without a token signing key
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	router, _ := setupRouterWithAuth(t, db, "env-secret")
+
+	for _, path := range []string{"/api/v1/auth/login", "/api/v1/auth/login/mfa"} {
+		// When: Logging in
+		rr := sendWithKey(router, "POST", path, "", `{"username": "alice", "password": "correct horse battery"}`)
+
+		// Then: The login says it is disabled instead of not existing
+		if rr.Code != http.StatusNotImplemented {
+			t.Errorf("%s: expected status 501, got %d: %s", path, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	// Given: A user with a password
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	router, mailer := setupRouterWithMail(t, db)
+	var user model.User
+	rr := sendWithKey(router, "POST", "/api/v1/users", "env-secret", `{"username": "alice", "email": "alice@example.com"}`)
+	if rr.Code != http.StatusCreated || json.Unmarshal(rr.Body.Bytes(), &user) != nil {
+		t.Fatalf("failed to create user: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = sendWithKey(router, "POST", "/api/v1/users/"+user.UUID+"/password", "env-secret", `{"new_password": "correct horse battery"}`)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("failed to set password: %d %s", rr.Code, rr.Body.String())
+	}
+
+	// When: Requesting resets for an unknown and a known address
+	for _, email := range []string{"nobody@example.com", "alice@example.com", "alice@example.com"} {
+		rr := sendWithKey(router, "POST", "/api/v1/auth/password-reset", "", `{"email": "`+email+`"}`)
+
+		// Then: Both are accepted alike
+		if rr.Code != http.StatusAccepted {
+			t.Errorf("%s: expected status %d, got %d: %s", email, http.StatusAccepted, rr.Code, rr.Body.String())
+		}
+	}
+
+	// Then: Only the user is mailed, once
+	msg := mailer.receive(t)
+	if msg.To != "alice@example.com" {
+		t.Fatalf("expected a reset email to alice, got %+v", msg)
+	}
+	token := mailedToken(t, msg)
+	select {
+	case extra := <-mailer:
+		t.Errorf("expected repeated requests to be throttled, got %+v", extra)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// When: Resetting with a bad token, a weak password and then properly
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"unknown token", `{"token": "bogus", "new_password": "a brand new password"}`, http.StatusUnprocessableEntity},
+		{"weak password", `{"token": "` + token + `", "new_password": "short"}`, http.StatusUnprocessableEntity},
+		{"reset", `{"token": "` + token + `", "new_password": "a brand new password"}`, http.StatusNoContent},
+		{"used token", `{"token": "` + token + `", "new_password": "another new password"}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		if rr := sendWithKey(router, "POST", "/api/v1/auth/password-reset/confirm", "", tt.body); rr.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.name, tt.status, rr.Code, rr.Body.String())
+		}
+	}
+
+	// Then: Only the new password logs in, and the change is attributed to the user
+	if rr, _ := login(router, "alice", "correct horse battery"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected the old password to be rejected, got %d", rr.Code)
+	}
+	if rr, _ := login(router, "alice", "a brand new password"); rr.Code != http.StatusOK {
+		t.Errorf("expected the new password to log in, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = sendWithKey(router, "GET", "/api/v1/users/"+user.UUID+"/history", "env-secret", "")
+	var history model.UserAuditList
+	if err := json.Unmarshal(rr.Body.Bytes(), &history); err != nil || len(history.Items) == 0 ||
+		history.Items[0].Action != model.AuditActionPasswordChange || history.Items[0].Actor != "user:"+user.UUID {
+		t.Errorf("expected a password change by the user, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestEmailVerification(t *testing.T) {
+	// Given: A user and a token from their login
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	router, mailer := setupRouterWithMail(t, db)
+	var user model.User
+	rr := sendWithKey(router, "POST", "/api/v1/users", "env-secret", `{"username": "alice", "email": "alice@example.com"}`)
+	if rr.Code != http.StatusCreated || json.Unmarshal(rr.Body.Bytes(), &user) != nil {
+		t.Fatalf("failed to create user: %d %s", rr.Code, rr.Body.String())
+	}
+	if user.EmailVerifiedAt != nil {
+		t.Errorf("expected a new user to be unverified, got %v", user.EmailVerifiedAt)
+	}
+	sendWithKey(router, "POST", "/api/v1/users/"+user.UUID+"/password", "env-secret", `{"new_password": "correct horse battery"}`)
+	_, accessToken := login(router, "alice", "correct horse battery")
+
+	// When: The user asks for a verification email and confirms it
+	rr = sendWithBearer(router, "POST", "/api/v1/users/"+user.UUID+"/email-verification", accessToken, "")
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+	token := mailedToken(t, mailer.receive(t))
+	rr = sendWithKey(router, "POST", "/api/v1/auth/email-verification/confirm", "", `{"token": "`+token+`"}`)
+
+	// Then: The address is verified and the change is audited
+	var verified model.User
+	if rr.Code != http.StatusOK || json.Unmarshal(rr.Body.Bytes(), &verified) != nil || verified.EmailVerifiedAt == nil ||
+		verified.Version != user.Version+1 {
+		t.Fatalf("expected a verified user, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = sendWithKey(router, "POST", "/api/v1/users/"+user.UUID+"/email-verification", "env-secret", "")
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected a verified address to conflict, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// When: The email changes through an update
+	rr = sendWithKey(router, "PATCH", "/api/v1/users/"+user.UUID, "env-secret", `{"email": "alice@example.org"}`)
+
+	// Then: The new address is unverified
+	var updated model.User
+	if rr.Code != http.StatusOK || json.Unmarshal(rr.Body.Bytes(), &updated) != nil || updated.EmailVerifiedAt != nil {
+		t.Errorf("expected verification to be reset, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Then: A token sent to the old address no longer verifies the new one
+	sendWithKey(router, "PATCH", "/api/v1/users/"+user.UUID, "env-secret", `{"email": "alice@example.com"}`)
+	rr = sendWithKey(router, "POST", "/api/v1/users/"+user.UUID+"/email-verification", "env-secret", "")
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+	select {
+	case msg := <-mailer:
+		t.Errorf("expected the request to be throttled, got %+v", msg)
+	default:
+	}
+	if _, err := db.Exec(`UPDATE user_tokens SET created_at = created_at - interval '1 hour'`); err != nil {
+		t.Fatal(err)
+	}
+	sendWithKey(router, "POST", "/api/v1/users/"+user.UUID+"/email-verification", "env-secret", "")
+	stale := mailedToken(t, mailer.receive(t))
+	sendWithKey(router, "PATCH", "/api/v1/users/"+user.UUID, "env-secret", `{"email": "alice@example.net"}`)
+	rr = sendWithKey(router, "POST", "/api/v1/auth/email-verification/confirm", "", `{"token": "`+stale+`"}`)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected a token for another address to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestTOTPMultiFactorAuth(t *testing.T) {
+	// Given: A user with a password and a token from their login
+	db := setupTestDB(t)
+	defer db.Close()
+	cleanupTestDB(t, db)
+
+	router := setupRouterWithOAuth(t, db)
+	var user model.User
+	rr := sendWithKey(router, "POST", "/api/v1/users", "env-secret", `{"username": "alice", "email": "alice@example.com"}`)
+	if rr.Code != http.StatusCreated || json.Unmarshal(rr.Body.Bytes(), &user) != nil {
+		t.Fatalf("failed to create user: %d %s", rr.Code, rr.Body.String())
+	}
+	sendWithKey(router, "POST", "/api/v1/users/"+user.UUID+"/password", "env-secret", `{"new_password": "correct horse battery"}`)
+	_, accessToken := login(router, "alice", "correct horse battery")
+	mfaPath := "/api/v1/users/" + user.UUID + "/mfa"
+
+	// When: An administrator tries to enroll the user
+	rr = sendWithKey(router, "POST", mfaPath+"/totp", "env-secret", "")
+
+	// Then: Only the user may
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected status %d, got %d: %s", http.StatusForbidden, rr.Code, rr.Body.String())
+	}
+
+	// When: The user enrolls and activates with a wrong and then the right code
+	rr = sendWithBearer(router, "POST", mfaPath+"/totp", accessToken, "")
+	var enrollment model.TOTPEnrollment
+	if rr.Code != http.StatusCreated || json.Unmarshal(rr.Body.Bytes(), &enrollment) != nil ||
+		!strings.HasPrefix(enrollment.URI, "otpauth://totp/cruder:alice?") || rr.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("expected an uncached enrollment, got %d: %s", rr.Code, rr.Body.String())
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatalf("failed to decode secret: %v", err)
+	}
+	step := auth.TOTPStep(time.Now())
+	code := auth.TOTPCode(secret, step)
+	wrong := code[:5] + string(rune('0'+(code[5]-'0'+1)%10))
+	rr = sendWithBearer(router, "POST", mfaPath+"/totp/activate", accessToken, `{"code": "`+wrong+`"}`)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected a wrong code to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = sendWithBearer(router, "POST", mfaPath+"/totp/activate", accessToken, `{"code": "`+code+`"}`)
+
+	// Then: MFA is enabled with ten recovery codes, and the secret is stored encrypted
+	var recovery model.RecoveryCodes
+	if rr.Code != http.StatusOK || json.Unmarshal(rr.Body.Bytes(), &recovery) != nil || len(recovery.Codes) != 10 {
+		t.Fatalf("expected recovery codes, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = sendWithKey(router, "GET", mfaPath, "env-secret", "")
+	var status model.MFAStatus
+	if rr.Code != http.StatusOK || json.Unmarshal(rr.Body.Bytes(), &status) != nil || !status.Enabled || status.RecoveryCodesRemaining != 10 {
+		t.Errorf("expected MFA to be enabled, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var ciphertext []byte
+	if err := db.QueryRow(`SELECT secret_ciphertext FROM user_mfa WHERE user_uuid = $1`, user.UUID).Scan(&ciphertext); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(ciphertext, secret) {
+		t.Error("expected the secret to be encrypted")
+	}
+	if rr := sendWithBearer(router, "POST", mfaPath+"/totp", accessToken, ""); rr.Code != http.StatusConflict {
+		t.Errorf("expected enrolling again to conflict, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// When: Logging in with the password
+	challenge := func() string {
+		t.Helper()
+		rr, token := login(router, "alice", "correct horse battery")
+		var c model.MFAChallenge
+		if rr.Code != http.StatusOK || token != "" || json.Unmarshal(rr.Body.Bytes(), &c) != nil || !c.MFARequired || c.MFAToken == "" {
+			t.Fatalf("expected an MFA challenge, got %d: %s", rr.Code, rr.Body.String())
+		}
+		return c.MFAToken
+	}
+	mfaToken := challenge()
+
+	// Then: The challenge takes a fresh TOTP code or an unused recovery code
+	mfaLogin := func(token, code string) *httptest.ResponseRecorder {
+		return sendWithKey(router, "POST", "/api/v1/auth/login/mfa", "", `{"mfa_token": "`+token+`", "code": "`+code+`"}`)
+	}
+	tests := []struct {
+		name   string
+		token  string
+		code   string
+		status int
+	}{
+		{"unknown token", "bogus", auth.TOTPCode(secret, step+1), http.StatusUnauthorized},
+		{"used code", mfaToken, code, http.StatusUnauthorized},
+		{"wrong code", mfaToken, wrong, http.StatusUnauthorized},
+		{"next code", mfaToken, auth.TOTPCode(secret, step+1), http.StatusOK},
+		{"used token", mfaToken, recovery.Codes[0], http.StatusUnauthorized},
+		{"recovery code", challenge(), strings.ToUpper(recovery.Codes[0]), http.StatusOK},
+		{"used recovery code", challenge(), recovery.Codes[0], http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		rr := mfaLogin(tt.token, tt.code)
+		if rr.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.name, tt.status, rr.Code, rr.Body.String())
+		}
+		var issued model.TokenResponse
+		if rr.Code == http.StatusOK && (json.Unmarshal(rr.Body.Bytes(), &issued) != nil || issued.AccessToken == "") {
+			t.Errorf("%s: expected an access token, got %s", tt.name, rr.Body.String())
+		}
+	}
+
+	// When: Guessing codes five times in a row
+	for range 5 {
+		mfaLogin(challenge(), wrong)
+	}
+
+	// Then: The factor is locked, even for a right code after a new password login
+	if rr := mfaLogin(challenge(), recovery.Codes[1]); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected a locked factor, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// When: An administrator resets MFA for the locked-out user
+	rr = sendWithKey(router, "DELETE", "/api/v1/admin/users/"+user.UUID+"/mfa", "env-secret", "")
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusNoContent, rr.Code, rr.Body.String())
+	}
+
+	// Then: The password logs in alone, and the changes are audited
+	if rr, token := login(router, "alice", "correct horse battery"); rr.Code != http.StatusOK || token == "" {
+		t.Errorf("expected a token without MFA, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := sendWithKey(router, "DELETE", "/api/v1/admin/users/"+user.UUID+"/mfa", "env-secret", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected a second reset to find nothing, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = sendWithKey(router, "GET", "/api/v1/users/"+user.UUID+"/history", "env-secret", "")
+	var history model.UserAuditList
+	if err := json.Unmarshal(rr.Body.Bytes(), &history); err != nil || len(history.Items) < 2 ||
+		history.Items[0].Action != model.AuditActionMFAReset || history.Items[0].Actor != "api-key" ||
+		history.Items[1].Action != model.AuditActionMFAEnable || history.Items[1].Actor != "jwt:"+user.UUID {
+		t.Errorf("expected audited MFA changes, got %d: %s", rr.Code, rr.Body.String())
+	}
 }
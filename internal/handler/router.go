@@ -1,23 +1,162 @@
 package handler
 
 import (
+	"net/http"
+
 	"cruder/internal/controller"
+	"cruder/internal/middleware"
+	"cruder/internal/model"
+	"cruder/internal/problem"
 
 	"github.com/gin-gonic/gin"
 )
 
-func New(router *gin.Engine, userController *controller.UserController) *gin.Engine {
+// Middleware holds the middleware that only applies to some routes. Nil
+// entries are skipped.
+type Middleware struct {
+	// BearerAuth and APIKeyAuth authenticate requests; each route group
+	// lists the ones it accepts. A bearer token takes precedence.
+	BearerAuth gin.HandlerFunc
+	APIKeyAuth gin.HandlerFunc
+
+	// Audit records the authenticated caller, so it runs after them.
+	Audit gin.HandlerFunc
+
+	// Idempotency makes unsafe requests with an Idempotency-Key replayable.
+	Idempotency gin.HandlerFunc
+
+	// UserTokenIssuer is the issuer of the tokens the login endpoints
+	// issue. Only those tokens let users act on themselves; it is empty
+	// when there is no login.
+	UserTokenIssuer string
+}
+
+// chain returns handlers without the nil ones.
+func chain(handlers ...gin.HandlerFunc) []gin.HandlerFunc {
+	chained := make([]gin.HandlerFunc, 0, len(handlers))
+	for _, h := range handlers {
+		if h != nil {
+			chained = append(chained, h)
+		}
+	}
+	return chained
+}
+
+func New(router *gin.Engine, controllers *controller.Controller, mw Middleware) *gin.Engine {
+	userController := controllers.Users
+	idempotent := func(h gin.HandlerFunc) []gin.HandlerFunc {
+		return chain(mw.Idempotency, h)
+	}
+
+	// Users can be managed by frontends with tokens and by integrations with
+	// API keys; administration needs an API key.
+	usersAuth := func(scope string) []gin.HandlerFunc {
+		return chain(mw.BearerAuth, mw.APIKeyAuth, mw.Audit, middleware.RequireScope(scope))
+	}
+	adminAuth := chain(mw.APIKeyAuth, mw.Audit, middleware.RequireScope(model.ScopeAdmin))
+
 	v1 := router.Group("/api/v1")
 	{
-		userGroup := v1.Group("/users")
+		// Each group declares how callers authenticate and the scope its
+		// routes require. Routes that need more than their group say so
+		// themselves.
+		readUsers := v1.Group("/users", usersAuth(model.ScopeUsersRead)...)
 		{
-			userGroup.GET("", userController.GetAllUsers)
-			userGroup.GET("/username/:username", userController.GetUserByUsername)
-			userGroup.GET("/id/:id", userController.GetUserByID)
-			userGroup.POST("", userController.CreateUser)
-			userGroup.PATCH("/:uuid", userController.UpdateUser)
-			userGroup.DELETE("/:uuid", userController.DeleteUser)
+			readUsers.GET("", userController.GetAllUsers)
+			readUsers.GET("/search", userController.SearchUsers)
+			readUsers.GET("/export", userController.ExportUsers)
+			readUsers.GET("/username/:username", userController.GetUserByUsername)
+			readUsers.GET("/id/:id", userController.GetUserByID)
+			readUsers.GET("/:uuid/history", userController.GetUserHistory)
+		}
+
+		writeUsers := v1.Group("/users", usersAuth(model.ScopeUsersWrite)...)
+		{
+			writeUsers.POST("", idempotent(userController.CreateUser)...)
+			writeUsers.PATCH("/:uuid", idempotent(userController.UpdateUser)...)
+			writeUsers.PUT("/:uuid", userController.ReplaceUser)
+			writeUsers.PUT("/by-external-id/:source/:external_id", userController.UpsertUserByExternalID)
+			// Purging cannot be undone, unlike soft-deleting.
+			writeUsers.DELETE("/:uuid", middleware.RequireScopeWhenQuery("hard", model.ScopeAdmin), userController.DeleteUser)
+			writeUsers.POST("/:uuid/restore", idempotent(userController.RestoreUser)...)
+			writeUsers.POST("/import", controllers.Imports.ImportUsers)
+			writeUsers.GET("/import/:id", controllers.Imports.GetImportJob)
+			writeUsers.GET("/import/:id/report", controllers.Imports.GetImportReport)
+		}
+
+		// Users manage their own account with a token from a login.
+		selfOrUsersWrite := func(h gin.HandlerFunc) []gin.HandlerFunc {
+			return chain(mw.BearerAuth, mw.APIKeyAuth, mw.Audit, middleware.RequireSelfOrScope("uuid", model.ScopeUsersWrite, mw.UserTokenIssuer), h)
 		}
+		v1.POST("/users/:uuid/password", selfOrUsersWrite(controllers.Auth.ChangePassword)...)
+		v1.POST("/users/:uuid/email-verification", selfOrUsersWrite(controllers.Auth.RequestEmailVerification)...)
+		v1.GET("/users/:uuid/mfa", chain(mw.BearerAuth, mw.APIKeyAuth, mw.Audit,
+			middleware.RequireSelfOrScope("uuid", model.ScopeUsersRead, mw.UserTokenIssuer), controllers.MFA.GetMFAStatus)...)
+
+		// Only users enroll their own authenticator.
+		self := v1.Group("/users/:uuid/mfa", chain(mw.BearerAuth, mw.APIKeyAuth, mw.Audit, middleware.RequireSelf("uuid", mw.UserTokenIssuer))...)
+		{
+			self.POST("/totp", controllers.MFA.EnrollTOTP)
+			self.POST("/totp/activate", controllers.MFA.ActivateTOTP)
+		}
+
+		// Mailed tokens authenticate the confirmations.
+		account := v1.Group("/auth", chain(mw.Audit)...)
+		{
+			account.POST("/password-reset", controllers.Auth.RequestPasswordReset)
+			account.POST("/password-reset/confirm", controllers.Auth.ResetPassword)
+			account.POST("/email-verification/confirm", controllers.Auth.VerifyEmail)
+		}
+
+		// Custom methods such as POST /users:batch share one route: gin only
+		// unescapes a literal colon ("\:") when started through Run.
+		batch := idempotent(customMethods(map[string]gin.HandlerFunc{
+			":batch": userController.BatchUsers,
+		}))
+		v1.POST("/users:method", append(usersAuth(model.ScopeUsersWrite), batch...)...)
+
+		adminGroup := v1.Group("/admin", adminAuth...)
+		{
+			adminGroup.GET("/jobs", controllers.Jobs.ListJobs)
+			adminGroup.GET("/api-keys", controllers.APIKeys.ListAPIKeys)
+			adminGroup.POST("/api-keys", controllers.APIKeys.CreateAPIKey)
+			adminGroup.GET("/api-keys/:id", controllers.APIKeys.GetAPIKey)
+			adminGroup.DELETE("/api-keys/:id", controllers.APIKeys.RevokeAPIKey)
+			adminGroup.POST("/api-keys/:id/rotate", controllers.APIKeys.RotateAPIKey)
+			adminGroup.GET("/api-keys/:id/events", controllers.APIKeys.ListAPIKeyEvents)
+			adminGroup.GET("/oauth-clients", controllers.OAuthClients.ListOAuthClients)
+			adminGroup.POST("/oauth-clients", controllers.OAuthClients.CreateOAuthClient)
+			adminGroup.GET("/oauth-clients/:id", controllers.OAuthClients.GetOAuthClient)
+			adminGroup.DELETE("/oauth-clients/:id", controllers.OAuthClients.RevokeOAuthClient)
+			adminGroup.DELETE("/users/:uuid/mfa", controllers.MFA.ResetMFA)
+		}
+	}
+
+	// The token endpoints authenticate clients, and the login users,
+	// themselves. Without a token endpoint the login answers that it is
+	// disabled, rather than 404.
+	v1.POST("/auth/login", controllers.Auth.Login)
+	v1.POST("/auth/login/mfa", controllers.Auth.LoginMFA)
+	if controllers.OAuth != nil {
+		oauth := router.Group("/oauth")
+		{
+			oauth.POST("/token", controllers.OAuth.Token)
+			oauth.POST("/introspect", controllers.OAuth.Introspect)
+		}
+		router.GET("/.well-known/jwks.json", controllers.OAuth.JWKS)
 	}
 	return router
 }
+
+// customMethods dispatches on the ":method" path parameter, which includes
+// the leading colon, and answers unknown methods with 404.
+func customMethods(handlers map[string]gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h, ok := handlers[c.Param("method")]
+		if !ok {
+			problem.Write(c, problem.New(http.StatusNotFound, problem.TypeNotFound, "unknown method"))
+			return
+		}
+		h(c)
+	}
+}
@@ -0,0 +1,128 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func TestWriterMailer(t *testing.T) {
+	var buf bytes.Buffer
+	mailer := NewWriterMailer(&buf, "Cruder <no-reply@example.com>")
+	err := mailer.Send(context.Background(), &Message{
+		To:      "jdoe@example.com",
+		Subject: "Réinitialiser",
+		Body:    "Hello,\n\nfollow the link.\n",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	out := buf.String()
+	for _, want := range []string{
+		"From: Cruder <no-reply@example.com>\r\n",
+		"To: jdoe@example.com\r\n",
+		"Subject: =?utf-8?q?R=C3=A9initialiser?=\r\n",
+		"@example.com>\r\n",
+		"\r\n\r\nHello,\r\n\r\nfollow the link.\r\n\r\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in message:\n%s", want, out)
+		}
+	}
+}
+
+func TestWriterMailer_InvalidMessage(t *testing.T) {
+	mailer := NewWriterMailer(&bytes.Buffer{}, "no-reply@example.com")
+	for name, msg := range map[string]*Message{
+		"bad recipient":      {To: "not an address", Subject: "Hi"},
+		"injected recipient": {To: "jdoe@example.com\r\nBcc: all@example.com", Subject: "Hi"},
+		"injected subject":   {To: "jdoe@example.com", Subject: "Hi\r\nBcc: all@example.com"},
+	} {
+		if err := mailer.Send(context.Background(), msg); !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("%s: expected ErrInvalidMessage, got %v", name, err)
+		}
+	}
+}
+
+// fakeSMTP accepts one message without TLS or authentication and returns
+// the envelope and data it received.
+func fakeSMTP(t *testing.T) (string, <-chan []string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		var lines []string
+		_ = tp.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.Fields(line + " ")[0])
+			switch cmd {
+			case "EHLO", "HELO":
+				_ = tp.PrintfLine("250 localhost")
+			case "DATA":
+				_ = tp.PrintfLine("354 go ahead")
+				data, _ := tp.ReadDotLines()
+				lines = append(lines, data...)
+				_ = tp.PrintfLine("250 queued")
+			case "QUIT":
+				_ = tp.PrintfLine("221 bye")
+				received <- lines
+				return
+			default:
+				lines = append(lines, line)
+				_ = tp.PrintfLine("250 ok")
+			}
+		}
+	}()
+	return ln.Addr().String(), received
+}
+
+func TestSMTPMailer(t *testing.T) {
+	addr, received := fakeSMTP(t)
+	mailer, err := NewSMTPMailer(addr, "Cruder <no-reply@example.com>", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mailer.Send(context.Background(), &Message{To: "jdoe@example.com", Subject: "Hi", Body: "Hello\n.\n"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	lines := strings.Join(<-received, "\n")
+	for _, want := range []string{"MAIL FROM:<no-reply@example.com>", "RCPT TO:<jdoe@example.com>", "Subject: Hi", "Hello\n."} {
+		if !strings.Contains(lines, want) {
+			t.Errorf("expected %q in session:\n%s", want, lines)
+		}
+	}
+}
+
+func TestSMTPMailer_AuthRequiresTLS(t *testing.T) {
+	addr, _ := fakeSMTP(t)
+	mailer, err := NewSMTPMailer(addr, "no-reply@example.com", "user", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mailer.Send(context.Background(), &Message{To: "jdoe@example.com", Subject: "Hi", Body: "Hello"})
+	if err == nil || !strings.Contains(err.Error(), "without TLS") {
+		t.Errorf("expected a refusal to authenticate, got %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+)
+
+// SMTPMailer delivers messages through an SMTP relay. It upgrades the
+// connection with STARTTLS when the server offers it, and refuses to send
+// credentials over a connection without TLS.
+type SMTPMailer struct {
+	addr     string
+	host     string
+	from     string
+	username string
+	password string
+}
+
+// NewSMTPMailer returns a mailer for the relay at addr ("host:port"). The
+// envelope sender is the address in from; username may be empty for relays
+// that do not authenticate.
+func NewSMTPMailer(addr, from, username, password string) (*SMTPMailer, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := envelopeAddress(from); err != nil {
+		return nil, errors.New("smtp: invalid sender address " + from)
+	}
+	return &SMTPMailer{addr: addr, host: host, from: from, username: username, password: password}, nil
+}
+
+// defaultSMTPTimeout bounds a delivery whose context has no deadline.
+const defaultSMTPTimeout = 30 * time.Second
+
+func (m *SMTPMailer) Send(ctx context.Context, msg *Message) error {
+	data, err := format(m.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	sender, err := envelopeAddress(m.from)
+	if err != nil {
+		return err
+	}
+	recipient, err := envelopeAddress(msg.To)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultSMTPTimeout)
+		defer cancel()
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	client, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer func() { _ = client.Close() }()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: m.host, MinVersion: tls.VersionTLS12}); err != nil {
+			return err
+		}
+	}
+	if m.username != "" {
+		if _, tlsOn := client.TLSConnectionState(); !tlsOn {
+			return errors.New("smtp: refusing to authenticate without TLS")
+		}
+		if err := client.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(sender); err != nil {
+		return err
+	}
+	if err := client.Rcpt(recipient); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// envelopeAddress returns the bare address of a header address such as
+// "Cruder <no-reply@example.com>".
+func envelopeAddress(address string) (string, error) {
+	addr, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", ErrInvalidMessage
+	}
+	return addr.Address, nil
+}
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"cruder/internal/audit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// anonymousActor is recorded when authentication is disabled.
+const anonymousActor = "anonymous"
+
+// AuditMiddleware stores the caller, request id and client IP in the request
+// context so that repositories can attach them to audit records. It must run
+// after RequestIDMiddleware and the authentication middleware.
+func AuditMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := c.GetString(ActorKey)
+		if actor == "" {
+			actor = anonymousActor
+		}
+
+		ctx := audit.WithSource(c.Request.Context(), audit.Source{
+			Actor:     actor,
+			RequestID: c.GetString(RequestIDKey),
+			IP:        c.ClientIP(),
+		})
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
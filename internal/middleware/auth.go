@@ -1,44 +1,181 @@
 package middleware
 
 import (
+	"context"
+	"crypto/subtle"
 	"net/http"
 	"os"
+	"slices"
+	"strconv"
+
+	"cruder/internal/auth"
+	"cruder/internal/model"
+	"cruder/internal/problem"
 
 	"github.com/gin-gonic/gin"
 )
 
-// APIKeyAuthMiddleware creates a middleware that validates X-API-Key header
-// Returns 401 Unauthorized if header is missing
-// Returns 403 Forbidden if header value is incorrect
-func APIKeyAuthMiddleware() gin.HandlerFunc {
-	expectedAPIKey := os.Getenv("API_KEY")
-	if expectedAPIKey == "" {
-		// If no API key is configured, allow all requests (for development)
-		// In production, you should set API_KEY environment variable
-		return func(c *gin.Context) {
+const (
+	// ActorKey is the gin context key holding the authenticated caller, as
+	// recorded in the audit log.
+	ActorKey = "actor"
+
+	// APIKeyKey holds the *model.APIKey a request was authenticated with.
+	// It is not set for the API_KEY environment key.
+	APIKeyKey = "api_key"
+
+	// scopesKey holds the scopes granted to the caller. It is absent until
+	// a middleware authenticated the request.
+	scopesKey = "scopes"
+
+	// anonymousKey is set for requests let through without credentials
+	// because authentication is disabled.
+	anonymousKey = "anonymous"
+
+	APIKeyHeader = "X-API-Key"
+)
+
+// APIKeyAuthenticator looks up the key for a token; it returns nil for
+// unknown, expired or revoked keys.
+type APIKeyAuthenticator interface {
+	Authenticate(ctx context.Context, token string, client model.APIKeyClient) (*model.APIKey, error)
+}
+
+// APIKeyAuthMiddleware authenticates the X-API-Key header against the keys
+// in the database and the API_KEY environment variable, which grants every
+// scope. The caller is stored under ActorKey ("api-key:<id>", or "api-key"
+// for API_KEY) and its scopes are checked by RequireScope.
+// Returns 401 Unauthorized if the header is missing
+// Returns 403 Forbidden if the key is unknown, expired or revoked
+// With allowAnonymous, requests without a key are allowed with every scope
+// (development mode); it is never implied by a missing API_KEY.
+// Requests already authenticated by BearerAuthMiddleware are let through.
+func APIKeyAuthMiddleware(keys APIKeyAuthenticator, allowAnonymous bool) gin.HandlerFunc {
+	envKey := os.Getenv("API_KEY")
+
+	return func(c *gin.Context) {
+		if authenticated(c) {
 			c.Next()
+			return
 		}
-	}
 
-	return func(c *gin.Context) {
-		apiKey := c.GetHeader("X-API-Key")
+		token := c.GetHeader(APIKeyHeader)
+		if token == "" {
+			if allowAnonymous {
+				c.Set(anonymousKey, true)
+				c.Set(scopesKey, []string{model.ScopeAdmin})
+				c.Next()
+				return
+			}
+			problem.Abort(c, problem.New(http.StatusUnauthorized, problem.TypeBlank, "missing X-API-Key header"))
+			return
+		}
 
-		if apiKey == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "missing X-API-Key header",
-			})
-			c.Abort()
+		if envKey != "" && subtle.ConstantTimeCompare([]byte(token), []byte(envKey)) == 1 {
+			c.Set(ActorKey, "api-key")
+			c.Set(scopesKey, []string{model.ScopeAdmin})
+			c.Next()
 			return
 		}
 
-		if apiKey != expectedAPIKey {
-			c.JSON(http.StatusForbidden, gin.H{
-				"error": "invalid API key",
-			})
+		key, err := keys.Authenticate(c.Request.Context(), token, model.APIKeyClient{
+			IP:        c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+		})
+		if err != nil {
+			problem.Error(c, err)
 			c.Abort()
 			return
 		}
+		if key == nil {
+			problem.Abort(c, problem.New(http.StatusForbidden, problem.TypeForbidden, "invalid API key"))
+			return
+		}
 
+		c.Set(ActorKey, "api-key:"+key.ID)
+		c.Set(APIKeyKey, key)
+		c.Set(scopesKey, key.Scopes)
 		c.Next()
 	}
 }
+
+// authenticated reports whether an earlier middleware authenticated c.
+func authenticated(c *gin.Context) bool {
+	_, ok := c.Get(scopesKey)
+	return ok
+}
+
+// RequireScope rejects callers whose credentials lack scope with 403, and
+// requests no middleware authenticated with 401. The admin scope satisfies
+// every scope.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, ok := c.Get(scopesKey)
+		if !ok {
+			problem.Abort(c, problem.New(http.StatusUnauthorized, problem.TypeBlank, "authentication required"))
+			return
+		}
+		if !model.HasScope(value.([]string), scope) {
+			problem.Abort(c, problem.New(http.StatusForbidden, problem.TypeForbidden, "missing scope "+scope))
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireScopeWhenQuery requires scope like RequireScope of requests whose
+// boolean query parameter name is true, for variants of a route that need
+// more than the route itself. Malformed values are left to the handler.
+func RequireScopeWhenQuery(name, scope string) gin.HandlerFunc {
+	requireScope := RequireScope(scope)
+	return func(c *gin.Context) {
+		if on, err := strconv.ParseBool(c.Query(name)); err == nil && on {
+			requireScope(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSelfOrScope lets users act on themselves: it passes requests whose
+// bearer token the login of issuer issued to the user named by the path
+// parameter param, and otherwise requires scope like RequireScope. Client
+// tokens and tokens of other issuers never count as a user, even if their
+// subject looks like one.
+func RequireSelfOrScope(param, scope, issuer string) gin.HandlerFunc {
+	requireScope := RequireScope(scope)
+	return func(c *gin.Context) {
+		if isSelf(c, param, issuer) {
+			c.Next()
+			return
+		}
+		requireScope(c)
+	}
+}
+
+// RequireSelf only passes requests whose bearer token the login of issuer
+// issued to the user named by the path parameter param, for changes no one
+// else may make on a user's behalf. Requests are let through when
+// authentication is disabled.
+func RequireSelf(param, issuer string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetBool(anonymousKey) || isSelf(c, param, issuer) {
+			c.Next()
+			return
+		}
+		problem.Abort(c, problem.New(http.StatusForbidden, problem.TypeForbidden, "only the user may do this"))
+	}
+}
+
+// isSelf reports whether c carries a token that a password login at issuer
+// issued to the user named by the path parameter param. Without an issuer
+// there is no login, and no token counts as a user.
+func isSelf(c *gin.Context, param, issuer string) bool {
+	value, ok := c.Get(ClaimsKey)
+	if !ok || issuer == "" {
+		return false
+	}
+	claims := value.(*auth.Claims)
+	return claims.Issuer == issuer && claims.ClientID == "" && slices.Contains(claims.AMR, auth.AMRPassword) &&
+		claims.Subject != "" && claims.Subject == c.Param(param)
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"cruder/internal/auth"
+	"cruder/internal/problem"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ClaimsKey holds the *auth.Claims of a request authenticated with a bearer
+// token.
+const ClaimsKey = "claims"
+
+// TokenVerifier validates a bearer token and returns its claims.
+type TokenVerifier interface {
+	Verify(ctx context.Context, token string) (*auth.Claims, error)
+}
+
+// BearerAuthMiddleware authenticates an "Authorization: Bearer <JWT>"
+// header. The token subject becomes the actor ("jwt:<sub>") and its scope
+// claim the scopes checked by RequireScope. Requests without a bearer token
+// are passed on unchanged so that APIKeyAuthMiddleware, which must come
+// after it, can authenticate them instead.
+// Returns 401 Unauthorized with WWW-Authenticate if the token is invalid
+func BearerAuthMiddleware(verifier TokenVerifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := bearerToken(c.GetHeader("Authorization"))
+		if !ok {
+			c.Next()
+			return
+		}
+
+		claims, err := verifier.Verify(c.Request.Context(), token)
+		if err != nil {
+			if unavailableKeys(err) {
+				problem.Error(c, err)
+				c.Abort()
+				return
+			}
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			problem.Abort(c, problem.New(http.StatusUnauthorized, problem.TypeBlank, "invalid bearer token"))
+			return
+		}
+
+		c.Set(ActorKey, "jwt:"+claims.Subject)
+		c.Set(ClaimsKey, claims)
+		c.Set(scopesKey, claims.Scopes())
+		c.Next()
+	}
+}
+
+// bearerToken extracts the token of a Bearer authorization header.
+func bearerToken(header string) (string, bool) {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// unavailableKeys reports whether a token could not be checked because the
+// key set could not be loaded, which is the server's fault, not the client's.
+func unavailableKeys(err error) bool {
+	return errors.Is(err, jwt.ErrTokenUnverifiable) &&
+		!errors.Is(err, auth.ErrUnknownKey) && !errors.Is(err, auth.ErrKeyType)
+}
@@ -0,0 +1,193 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"cruder/internal/model"
+	"cruder/internal/problem"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// IdempotentReplayedHeader marks a response that was replayed from the
+	// store instead of being produced by the handler.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+
+	// maxIdempotentBodyBytes bounds request bodies, which are read in full
+	// to fingerprint them.
+	maxIdempotentBodyBytes = 8 << 20
+
+	// idempotencyLockTimeout is how long a reservation is honored before a
+	// retry may take it over, in case the first request never completed.
+	idempotencyLockTimeout = time.Minute
+)
+
+// IdempotencyStore persists responses by caller and key.
+type IdempotencyStore interface {
+	Reserve(ctx context.Context, scope, key, fingerprint string, ttl, lockTimeout time.Duration) (*model.IdempotencyRecord, bool, error)
+	Complete(ctx context.Context, record *model.IdempotencyRecord) error
+	Release(ctx context.Context, record *model.IdempotencyRecord) error
+}
+
+// unreplayedHeaders are produced anew for every request.
+var unreplayedHeaders = map[string]bool{
+	problem.RequestIDHeader: true,
+	"Content-Length":        true,
+	"Date":                  true,
+}
+
+// IdempotencyMiddleware makes requests that carry an Idempotency-Key safe to
+// retry. The first response with a status below 500 is stored for ttl and
+// replayed for later requests from the same caller with the same key,
+// method, URL and body. Reusing a key for a different request returns 422;
+// a retry while the first request is still running returns 409. Server
+// errors are not stored, so the request can be retried with the same key.
+// It must run after the authentication middleware. A non-positive ttl
+// disables it.
+func IdempotencyMiddleware(store IdempotencyStore, ttl time.Duration) gin.HandlerFunc {
+	if ttl <= 0 {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if !validIdempotencyKey(key) {
+			problem.Abort(c, problem.New(http.StatusBadRequest, problem.TypeIdempotency,
+				"Idempotency-Key must be 1-255 printable ASCII characters"))
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxIdempotentBodyBytes))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				problem.Abort(c, problem.New(http.StatusRequestEntityTooLarge, problem.TypeBadRequest, "request body too large"))
+				return
+			}
+			problem.Abort(c, problem.New(http.StatusBadRequest, problem.TypeBadRequest, "failed to read request body"))
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := c.GetString(ActorKey)
+		if scope == "" {
+			scope = anonymousActor
+		}
+		fingerprint := requestFingerprint(c.Request, body)
+
+		record, reserved, err := store.Reserve(c.Request.Context(), scope, key, fingerprint, ttl, idempotencyLockTimeout)
+		if err != nil {
+			problem.Error(c, err)
+			c.Abort()
+			return
+		}
+		if !reserved {
+			switch {
+			case record.Fingerprint != fingerprint:
+				problem.Abort(c, problem.New(http.StatusUnprocessableEntity, problem.TypeIdempotency,
+					"Idempotency-Key was already used for a different request"))
+			case record.Status == 0:
+				c.Header("Retry-After", "1")
+				problem.Abort(c, problem.New(http.StatusConflict, problem.TypeIdempotency,
+					"a request with this Idempotency-Key is still in progress"))
+			default:
+				replay(c, record)
+			}
+			return
+		}
+
+		// Storing the outcome must not depend on the request deadline.
+		storeCtx := context.WithoutCancel(c.Request.Context())
+		completed := false
+		defer func() {
+			if !completed {
+				if err := store.Release(storeCtx, record); err != nil {
+					_ = c.Error(err)
+				}
+			}
+		}()
+
+		recorder := &bodyRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			return
+		}
+		record.Status = c.Writer.Status()
+		record.Header = make(map[string][]string)
+		for name, values := range c.Writer.Header() {
+			if !unreplayedHeaders[name] {
+				record.Header[name] = values
+			}
+		}
+		record.Body = recorder.body.Bytes()
+		if err := store.Complete(storeCtx, record); err != nil {
+			_ = c.Error(err)
+			return
+		}
+		completed = true
+	}
+}
+
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestFingerprint identifies a request by method, URL and body.
+func requestFingerprint(r *http.Request, body []byte) string {
+	sum := sha256.Sum256(append([]byte(r.Method+" "+r.URL.RequestURI()+"\n"), body...))
+	return hex.EncodeToString(sum[:])
+}
+
+func replay(c *gin.Context, record *model.IdempotencyRecord) {
+	for name, values := range record.Header {
+		c.Writer.Header()[name] = values
+	}
+	c.Header(IdempotentReplayedHeader, "true")
+	c.Status(record.Status)
+	_, _ = c.Writer.Write(record.Body)
+	c.Abort()
+}
+
+// bodyRecorder keeps a copy of the response body.
+type bodyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
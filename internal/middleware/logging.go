@@ -19,6 +19,8 @@ type LogEntry struct {
 	ServerAddress             string `json:"server.address"`
 	HTTPRequestHost           string `json:"http.request.host"`
 	UserID                    string `json:"user_id,omitempty"`
+	RequestID                 string `json:"http.request.id,omitempty"`
+	ErrorMessage              string `json:"error.message,omitempty"`
 }
 
 func JSONLoggingMiddleware() gin.HandlerFunc {
@@ -68,6 +70,13 @@ func JSONLoggingMiddleware() gin.HandlerFunc {
 			logEntry.UserID = userID
 		}
 
+		logEntry.RequestID = c.GetString(RequestIDKey)
+
+		// Errors hidden from clients by problem responses are recorded here
+		if len(c.Errors) > 0 {
+			logEntry.ErrorMessage = c.Errors.String()
+		}
+
 		logJSON, err := json.Marshal(logEntry)
 		if err != nil {
 			// If JSON marshaling fails, log error and continue (logging failure shouldn't break requests)
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"cruder/internal/problem"
+
+	"github.com/gin-gonic/gin"
+)
+
+const RequestIDKey = "request_id"
+
+// RequestIDMiddleware assigns every request an id, reusing a client supplied
+// X-Request-ID when present. The id is echoed in the response header,
+// stored in the gin context and reported as trace_id in problem responses.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(problem.RequestIDHeader)
+		if requestID == "" || len(requestID) > 128 {
+			requestID = newRequestID()
+		}
+
+		c.Set(RequestIDKey, requestID)
+		c.Header(problem.RequestIDHeader, requestID)
+		c.Next()
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
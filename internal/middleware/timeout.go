@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestTimeoutMiddleware attaches a deadline to the request context.
+// Database calls made with that context are cancelled once it expires.
+// A non-positive timeout disables the deadline. Routes listed in exempt, by
+// their registered path, run without a deadline; they are meant for
+// long-running streams.
+func RequestTimeoutMiddleware(timeout time.Duration, exempt ...string) gin.HandlerFunc {
+	if timeout <= 0 {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+
+	skip := make(map[string]bool, len(exempt))
+	for _, path := range exempt {
+		skip[path] = true
+	}
+
+	return func(c *gin.Context) {
+		if skip[c.FullPath()] {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
@@ -0,0 +1,98 @@
+package model
+
+import "time"
+
+// API key scopes. ScopeAdmin grants every other scope as well.
+const (
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+	ScopeAdmin      = "admin"
+)
+
+// Scopes lists every scope a key can be granted.
+var Scopes = []string{ScopeUsersRead, ScopeUsersWrite, ScopeAdmin}
+
+// API key events. A rejected event's reason is one of the APIKeyRejected
+// constants.
+const (
+	APIKeyEventUsed     = "used"
+	APIKeyEventRejected = "rejected"
+
+	APIKeyRejectedUnknown = "unknown"
+	APIKeyRejectedExpired = "expired"
+	APIKeyRejectedRevoked = "revoked"
+)
+
+const (
+	DefaultAPIKeyEventLimit = 50
+	MaxAPIKeyEventLimit     = 500
+
+	// DefaultRotationGracePeriod is how long a rotated key keeps working
+	// unless the rotation asks for another period.
+	DefaultRotationGracePeriod = 24 * time.Hour
+	MaxRotationGracePeriod     = 30 * 24 * time.Hour
+)
+
+// APIKey describes a key without its secret. Prefix is the public part of
+// the key, used to look it up; only a hash of the whole key is stored.
+// A rotated key names its successor in ReplacedBy and expires at the end of
+// the grace period.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Owner      string     `json:"owner"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	ReplacedBy *string    `json:"replaced_by,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+	KeyHash    []byte     `json:"-"`
+}
+
+// IssuedAPIKey is a newly created key together with its secret, which is
+// only ever returned once.
+type IssuedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+type APIKeyList struct {
+	Items []APIKey `json:"items"`
+}
+
+// APIKeyClient identifies the client presenting a key.
+type APIKeyClient struct {
+	IP        string
+	UserAgent string
+}
+
+// APIKeyEvent records a use of a key or a rejected attempt. KeyID is nil
+// when the presented key is unknown.
+type APIKeyEvent struct {
+	ID        int64     `json:"id"`
+	KeyID     *string   `json:"key_id,omitempty"`
+	Prefix    string    `json:"prefix,omitempty"`
+	Event     string    `json:"event"`
+	Reason    string    `json:"reason,omitempty"`
+	SourceIP  string    `json:"source_ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type APIKeyEventList struct {
+	Items      []APIKeyEvent `json:"items"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// HasScope reports whether the granted scopes include scope.
+func HasScope(granted []string, scope string) bool {
+	for _, s := range granted {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
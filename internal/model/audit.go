@@ -0,0 +1,53 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	AuditActionCreate      = "create"
+	AuditActionUpdate      = "update"
+	AuditActionDelete      = "delete"
+	AuditActionRestore     = "restore"
+	AuditActionPurge       = "purge"
+	AuditActionVerifyEmail = "verify_email"
+
+	// AuditActionPasswordChange records that a password was set, and
+	// AuditActionMFAEnable and AuditActionMFAReset that a second factor was
+	// enabled or removed. These entries have neither snapshots nor changes.
+	AuditActionPasswordChange = "password_change"
+	AuditActionMFAEnable      = "mfa_enable"
+	AuditActionMFAReset       = "mfa_reset"
+)
+
+const (
+	DefaultHistoryLimit = 50
+	MaxHistoryLimit     = 500
+)
+
+// FieldChange is the old and new value of a single user attribute.
+type FieldChange struct {
+	Old any `json:"old"`
+	New any `json:"new"`
+}
+
+// UserAuditEntry records one change to a user. Before is null for creations
+// and After is null for purges; both are null for password and MFA changes.
+type UserAuditEntry struct {
+	ID        int64                  `json:"id"`
+	UserUUID  string                 `json:"user_uuid"`
+	Action    string                 `json:"action"`
+	Actor     string                 `json:"actor"`
+	RequestID string                 `json:"request_id,omitempty"`
+	SourceIP  string                 `json:"source_ip,omitempty"`
+	Before    json.RawMessage        `json:"before"`
+	After     json.RawMessage        `json:"after"`
+	Changes   map[string]FieldChange `json:"changes"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+type UserAuditList struct {
+	Items      []UserAuditEntry `json:"items"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
@@ -0,0 +1,18 @@
+package model
+
+const MaxBatchOperations = 10000
+
+const (
+	BatchOpCreate = "create"
+	BatchOpUpdate = "update"
+	BatchOpDelete = "delete"
+)
+
+// BatchOperation is one item of a batch request. Update replaces all mutable
+// fields like PUT; Version, when set, must match like If-Match.
+type BatchOperation struct {
+	Op      string `json:"op"`
+	UUID    string `json:"uuid,omitempty"`
+	Version int    `json:"version,omitempty"`
+	User    *User  `json:"user,omitempty"`
+}
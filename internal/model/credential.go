@@ -0,0 +1,21 @@
+package model
+
+import "time"
+
+// Credential is the password of a user. It is kept apart from User so that
+// the hash never reaches user responses, snapshots or exports.
+type Credential struct {
+	UserUUID       string
+	PasswordHash   string
+	FailedAttempts int
+	LockedUntil    *time.Time
+	LastLoginAt    *time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// LoginRequest is a user's password login.
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+// IdempotencyRecord is the stored outcome of a request sent with an
+// Idempotency-Key. Keys are unique per Scope, the authenticated caller.
+// Status is zero while the first request is still in flight.
+// ReservationID identifies the request that holds the key.
+type IdempotencyRecord struct {
+	Scope         string
+	Key           string
+	ReservationID string
+	Fingerprint   string
+	Status        int
+	Header        map[string][]string
+	Body          []byte
+	CreatedAt     time.Time
+	ExpiresAt     time.Time
+}
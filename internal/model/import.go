@@ -0,0 +1,43 @@
+package model
+
+import "time"
+
+const (
+	ImportFormatCSV    = "csv"
+	ImportFormatNDJSON = "ndjson"
+)
+
+const (
+	ImportJobPending   = "pending"
+	ImportJobRunning   = "running"
+	ImportJobSucceeded = "succeeded"
+	ImportJobFailed    = "failed"
+)
+
+// ImportError describes why a line of an import was rejected. Field is
+// empty when the whole line could not be parsed.
+type ImportError struct {
+	Line    int    `json:"line"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// ImportStats counts the rows of an import. In a dry run Succeeded counts
+// the rows that passed validation.
+type ImportStats struct {
+	Processed int `json:"processed_rows"`
+	Succeeded int `json:"succeeded_rows"`
+	Failed    int `json:"failed_rows"`
+}
+
+// ImportJob tracks an import that runs in the background.
+type ImportJob struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Format string `json:"format"`
+	DryRun bool   `json:"dry_run"`
+	ImportStats
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
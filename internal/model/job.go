@@ -0,0 +1,33 @@
+package model
+
+import "time"
+
+const (
+	JobRunSucceeded = "succeeded"
+	JobRunFailed    = "failed"
+)
+
+// JobRun is the recorded outcome of one execution of a background job.
+type JobRun struct {
+	ID           int64     `json:"id"`
+	Job          string    `json:"job"`
+	StartedAt    time.Time `json:"started_at"`
+	FinishedAt   time.Time `json:"finished_at"`
+	Status       string    `json:"status"`
+	RowsAffected int64     `json:"rows_affected"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// JobStatus describes a scheduled job. LastRun may have been executed by
+// any replica; NextRunAt is only known once the local scheduler is running.
+type JobStatus struct {
+	Name      string     `json:"name"`
+	Interval  string     `json:"interval"`
+	Running   bool       `json:"running"`
+	LastRun   *JobRun    `json:"last_run,omitempty"`
+	NextRunAt *time.Time `json:"next_run_at,omitempty"`
+}
+
+type JobStatusList struct {
+	Items []JobStatus `json:"items"`
+}
@@ -0,0 +1,56 @@
+package model
+
+import "time"
+
+// TOTPFactor is a user's TOTP authenticator. It is pending until EnabledAt
+// is set by a first valid code. The secret is stored encrypted, and
+// LastUsedStep keeps a code from being used twice.
+type TOTPFactor struct {
+	UserUUID         string
+	SecretCiphertext []byte
+	EnabledAt        *time.Time
+	LastUsedStep     int64
+	FailedAttempts   int
+	LockedUntil      *time.Time
+	CreatedAt        time.Time
+}
+
+// TOTPEnrollment is a new TOTP secret, which is only ever returned once,
+// both as base32 and as an otpauth:// URI for QR codes.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// TOTPActivation confirms an enrollment with a code from the authenticator.
+type TOTPActivation struct {
+	Code string `json:"code"`
+}
+
+// RecoveryCodes are single-use codes that replace a TOTP code when the
+// authenticator is lost. Only hashes are stored; the codes are returned
+// once, on activation.
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+// MFAStatus describes a user's second factor.
+type MFAStatus struct {
+	Enabled                bool       `json:"enabled"`
+	EnabledAt              *time.Time `json:"enabled_at,omitempty"`
+	RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
+}
+
+// MFAChallenge answers a login with the right password of a user with
+// MFA. MFAToken is exchanged for an access token together with a code.
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// MFALogin completes a login with a TOTP code or a recovery code.
+type MFALogin struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
@@ -0,0 +1,101 @@
+package model
+
+import "time"
+
+// OAuth 2.0 grant types supported by the token endpoint.
+const (
+	GrantClientCredentials = "client_credentials"
+	GrantRefreshToken      = "refresh_token"
+)
+
+// GrantTypes lists every grant type a client can be allowed to use.
+var GrantTypes = []string{GrantClientCredentials, GrantRefreshToken}
+
+const (
+	TokenTypeBearer = "Bearer"
+
+	// Token type hints of RFC 7662 introspection requests.
+	TokenHintAccessToken  = "access_token"
+	TokenHintRefreshToken = "refresh_token"
+)
+
+// OAuthClient is a service registered to obtain access tokens. Only a hash
+// of its secret is stored. Scopes bound the scopes of its tokens, and it
+// receives refresh tokens only if GrantTypes includes GrantRefreshToken.
+type OAuthClient struct {
+	ID         string     `json:"client_id"`
+	Name       string     `json:"name"`
+	Owner      string     `json:"owner"`
+	Scopes     []string   `json:"scopes"`
+	GrantTypes []string   `json:"grant_types"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	SecretHash []byte     `json:"-"`
+}
+
+// IssuedOAuthClient is a newly registered client together with its secret,
+// which is only ever returned once.
+type IssuedOAuthClient struct {
+	OAuthClient
+	Secret string `json:"client_secret"`
+}
+
+type OAuthClientList struct {
+	Items []OAuthClient `json:"items"`
+}
+
+// RefreshToken is an opaque, single-use token stored as a hash. Using it
+// issues a successor in the same family; presenting a used token again
+// revokes the whole family, since it means the token was leaked.
+type RefreshToken struct {
+	ID        string
+	ClientID  string
+	FamilyID  string
+	Scopes    []string
+	TokenHash []byte
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+// ClientCredentials authenticate a client at the token and introspection
+// endpoints.
+type ClientCredentials struct {
+	ID     string
+	Secret string
+}
+
+// TokenRequest holds the parameters of a token request (RFC 6749 section 4.4
+// and 6). Scope is a space separated list.
+type TokenRequest struct {
+	Client       ClientCredentials
+	GrantType    string
+	Scope        string
+	RefreshToken string
+}
+
+// TokenResponse is a successful token response (RFC 6749 section 5.1).
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope"`
+}
+
+// Introspection describes a token (RFC 7662 section 2.2). Inactive tokens
+// carry no other members.
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	Audience  string `json:"aud,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	ID        string `json:"jti,omitempty"`
+}
@@ -0,0 +1,26 @@
+package model
+
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 500
+)
+
+// ListParams describes a page request for list endpoints.
+// After is an opaque keyset cursor returned as NextCursor by a previous page.
+// Filter and Sort hold the raw filter expression and sort list from the query string.
+// Soft-deleted users are only listed when IncludeDeleted is set.
+type ListParams struct {
+	Limit          int
+	Offset         int
+	After          string
+	WithTotal      bool
+	Filter         string
+	Sort           string
+	IncludeDeleted bool
+}
+
+type UserList struct {
+	Items      []User `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	Total      *int64 `json:"total,omitempty"`
+}
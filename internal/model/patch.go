@@ -0,0 +1,26 @@
+package model
+
+import "encoding/json"
+
+// PatchString is a string field of a JSON Merge Patch (RFC 7396).
+// Set reports whether the member was present; Null whether it was null.
+type PatchString struct {
+	Set   bool
+	Null  bool
+	Value string
+}
+
+// UserPatch lists the changes requested by a merge patch on a user.
+// Fields that are not Set are left unchanged.
+type UserPatch struct {
+	Username PatchString
+	Email    PatchString
+	FullName PatchString
+}
+
+// JSONPatchOperation is a single RFC 6902 JSON Patch operation.
+type JSONPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
@@ -0,0 +1,17 @@
+package model
+
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+// UserSearchResult is a user matched by full-text or fuzzy search
+// together with its relevance score; higher is more relevant.
+type UserSearchResult struct {
+	User
+	Score float64 `json:"score"`
+}
+
+type UserSearchResults struct {
+	Items []UserSearchResult `json:"items"`
+}
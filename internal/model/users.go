@@ -1,9 +1,18 @@
 package model
 
+import "time"
+
 type User struct {
-	ID       int    `json:"id"`
-	UUID     string `json:"uuid"`
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	FullName string `json:"full_name"`
+	ID        int        `json:"id"`
+	UUID      string     `json:"uuid"`
+	Username  string     `json:"username"`
+	Email     string     `json:"email"`
+	FullName  string     `json:"full_name"`
+	CreatedAt time.Time  `json:"created_at"`
+	Version   int        `json:"version"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+
+	// EmailVerifiedAt is set when the user confirms Email and cleared when
+	// Email changes.
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
 }
@@ -0,0 +1,41 @@
+package model
+
+import "time"
+
+// Purposes of user tokens.
+const (
+	TokenPurposeVerifyEmail   = "verify_email"
+	TokenPurposeResetPassword = "reset_password"
+	TokenPurposeMFALogin      = "mfa_login"
+)
+
+// UserToken is a single-use token mailed to a user to prove they control
+// Email, or handed out by a login that still needs a second factor. Only a
+// hash of the token is stored.
+type UserToken struct {
+	ID        string
+	UserUUID  string
+	Purpose   string
+	Email     string
+	TokenHash []byte
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// PasswordResetRequest asks for a password reset link for the user with
+// the given email address.
+type PasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+// PasswordReset sets a new password with a mailed reset token.
+type PasswordReset struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// EmailVerification confirms an email address with a mailed token.
+type EmailVerification struct {
+	Token string `json:"token"`
+}
@@ -0,0 +1,141 @@
+// Package problem renders errors as RFC 7807 application/problem+json
+// responses and maps service errors to HTTP statuses.
+package problem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"cruder/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ContentType = "application/problem+json"
+
+	// RequestIDHeader carries the id that is reported as trace_id.
+	RequestIDHeader = "X-Request-ID"
+)
+
+// Problem type URIs, relative to the API root.
+const (
+	TypeBlank        = "about:blank"
+	TypeNotFound     = "/problems/not-found"
+	TypeConflict     = "/problems/conflict"
+	TypeValidation   = "/problems/validation"
+	TypeForbidden    = "/problems/forbidden"
+	TypeUnauthorized = "/problems/unauthorized"
+	TypePrecondition = "/problems/precondition-failed"
+	TypeBadRequest   = "/problems/bad-request"
+	TypeTimeout      = "/problems/timeout"
+	TypeInternal     = "/problems/internal"
+	TypeIdempotency  = "/problems/idempotency-key"
+)
+
+type Problem struct {
+	Type     string               `json:"type"`
+	Title    string               `json:"title"`
+	Status   int                  `json:"status"`
+	Detail   string               `json:"detail,omitempty"`
+	Instance string               `json:"instance,omitempty"`
+	TraceID  string               `json:"trace_id,omitempty"`
+	Field    string               `json:"field,omitempty"`
+	Position *int                 `json:"position,omitempty"`
+	Index    *int                 `json:"index,omitempty"`
+	Errors   []service.FieldError `json:"errors,omitempty"`
+}
+
+// New creates a problem of the given type with the standard status title.
+func New(status int, problemType, detail string) *Problem {
+	return &Problem{
+		Type:   problemType,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// Write renders p, filling in instance and trace id from the request.
+func Write(c *gin.Context, p *Problem) {
+	if p.Instance == "" {
+		p.Instance = c.Request.URL.Path
+	}
+	if p.TraceID == "" {
+		p.TraceID = c.Writer.Header().Get(RequestIDHeader)
+	}
+	c.Header("Content-Type", ContentType)
+	c.JSON(p.Status, p)
+}
+
+// Abort renders p and stops the handler chain.
+func Abort(c *gin.Context, p *Problem) {
+	Write(c, p)
+	c.Abort()
+}
+
+func BadRequest(c *gin.Context, detail string) {
+	Write(c, New(http.StatusBadRequest, TypeBadRequest, detail))
+}
+
+// Error maps err to a problem response. Unknown errors become a generic 500;
+// their message is recorded on the gin context for logging but never sent.
+func Error(c *gin.Context, err error) {
+	Write(c, FromError(c, err))
+}
+
+func FromError(c *gin.Context, err error) *Problem {
+	var (
+		notFound   *service.NotFoundError
+		conflict   *service.ConflictError
+		validation *service.ValidationError
+		forbidden  *service.ForbiddenError
+		unauthed   *service.UnauthorizedError
+		precond    *service.PreconditionFailedError
+		parseErr   *service.ParseError
+		batchErr   *service.BatchError
+	)
+
+	if errors.As(err, &batchErr) {
+		p := FromError(c, batchErr.Err)
+		p.Detail = fmt.Sprintf("operation %d: %s", batchErr.Index, p.Detail)
+		p.Index = &batchErr.Index
+		return p
+	}
+
+	switch {
+	case errors.As(err, &notFound):
+		return New(http.StatusNotFound, TypeNotFound, notFound.Error())
+	case errors.As(err, &conflict):
+		p := New(http.StatusConflict, TypeConflict, conflict.Error())
+		p.Field = conflict.Field
+		return p
+	case errors.As(err, &validation):
+		p := New(http.StatusUnprocessableEntity, TypeValidation, "request failed validation")
+		p.Errors = validation.Errors
+		return p
+	case errors.As(err, &precond):
+		return New(http.StatusPreconditionFailed, TypePrecondition, precond.Error())
+	case errors.As(err, &forbidden):
+		return New(http.StatusForbidden, TypeForbidden, forbidden.Error())
+	case errors.As(err, &unauthed):
+		return New(http.StatusUnauthorized, TypeUnauthorized, unauthed.Error())
+	case errors.As(err, &parseErr):
+		p := New(http.StatusBadRequest, TypeBadRequest, parseErr.Error())
+		p.Position = &parseErr.Position
+		return p
+	case errors.Is(err, service.ErrInvalidCursor), errors.Is(err, service.ErrEmptySearchQuery):
+		return New(http.StatusBadRequest, TypeBadRequest, err.Error())
+	}
+
+	_ = c.Error(err)
+
+	// The driver reports a cancelled statement as its own error,
+	// so the request deadline is checked as well.
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(c.Request.Context().Err(), context.DeadlineExceeded) {
+		return New(http.StatusGatewayTimeout, TypeTimeout, "request timed out")
+	}
+	return New(http.StatusInternalServerError, TypeInternal, "an unexpected error occurred")
+}
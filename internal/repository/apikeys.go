@@ -0,0 +1,221 @@
+package repository
+
+import (
+	"context"
+	"cruder/internal/model"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *model.APIKey) error
+	Get(ctx context.Context, id string, forUpdate bool) (*model.APIKey, error)
+	GetByPrefix(ctx context.Context, prefix string) (*model.APIKey, error)
+	List(ctx context.Context, includeRevoked bool) ([]model.APIKey, error)
+	Revoke(ctx context.Context, id string) (bool, error)
+	Replace(ctx context.Context, id, replacedBy string, expiresAt time.Time) error
+	RecordUse(ctx context.Context, id string, client model.APIKeyClient) error
+	RecordRejected(ctx context.Context, event *model.APIKeyEvent) error
+	ListEvents(ctx context.Context, id string, limit int, after string) (*model.APIKeyEventList, error)
+	PurgeEvents(ctx context.Context, retention time.Duration) (int64, error)
+}
+
+type apiKeyRepository struct {
+	db dbtx
+}
+
+func NewAPIKeyRepository(db *sql.DB) APIKeyRepository {
+	return &apiKeyRepository{db: db}
+}
+
+const apiKeyColumns = `id, name, owner, prefix, key_hash, scopes, created_at, expires_at, revoked_at, replaced_by,
+	last_used_at, host(last_used_ip)`
+
+// apiKeyEventsSort tags event cursors so other cursors cannot be replayed here.
+const apiKeyEventsSort = "api_key_events"
+
+func scanAPIKey(row rowScanner) (*model.APIKey, error) {
+	var key model.APIKey
+	var expiresAt, revokedAt, lastUsedAt sql.NullTime
+	var replacedBy, lastUsedIP sql.NullString
+	if err := row.Scan(&key.ID, &key.Name, &key.Owner, &key.Prefix, &key.KeyHash, pq.Array(&key.Scopes),
+		&key.CreatedAt, &expiresAt, &revokedAt, &replacedBy, &lastUsedAt, &lastUsedIP); err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	if replacedBy.Valid {
+		key.ReplacedBy = &replacedBy.String
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	key.LastUsedIP = lastUsedIP.String
+	return &key, nil
+}
+
+// Create inserts key and fills in its generated id and creation time.
+func (r *apiKeyRepository) Create(ctx context.Context, key *model.APIKey) error {
+	return r.db.QueryRowContext(ctx,
+		`INSERT INTO api_keys (name, owner, prefix, key_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`,
+		key.Name, key.Owner, key.Prefix, key.KeyHash, pq.Array(key.Scopes), key.ExpiresAt,
+	).Scan(&key.ID, &key.CreatedAt)
+}
+
+// Get returns the key with the given id, or nil. With forUpdate the row is
+// locked until the surrounding transaction ends.
+func (r *apiKeyRepository) Get(ctx context.Context, id string, forUpdate bool) (*model.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE id = $1`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+	key, err := scanAPIKey(r.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return key, err
+}
+
+// GetByPrefix returns the key with the given public prefix, or nil.
+func (r *apiKeyRepository) GetByPrefix(ctx context.Context, prefix string) (*model.APIKey, error) {
+	key, err := scanAPIKey(r.db.QueryRowContext(ctx,
+		`SELECT `+apiKeyColumns+` FROM api_keys WHERE prefix = $1`, prefix))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return key, err
+}
+
+// List returns all keys, oldest first, leaving out revoked ones unless
+// includeRevoked is set.
+func (r *apiKeyRepository) List(ctx context.Context, includeRevoked bool) ([]model.APIKey, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+apiKeyColumns+` FROM api_keys WHERE $1 OR revoked_at IS NULL ORDER BY created_at, id`, includeRevoked)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []model.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// Revoke disables a key immediately. Revoking a revoked key keeps the
+// original time. It reports whether the key exists.
+func (r *apiKeyRepository) Revoke(ctx context.Context, id string) (bool, error) {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE api_keys SET revoked_at = COALESCE(revoked_at, now()) WHERE id = $1`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Replace records that key id was rotated to replacedBy and makes it expire
+// at expiresAt, unless it already expires earlier.
+func (r *apiKeyRepository) Replace(ctx context.Context, id, replacedBy string, expiresAt time.Time) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE api_keys SET replaced_by = $2, expires_at = LEAST(expires_at, $3) WHERE id = $1`,
+		id, replacedBy, expiresAt)
+	return err
+}
+
+// RecordUse stores when and from where a key was used. To spare a write on
+// every request, it only records one use per key and minute, both in the
+// key and as a used event.
+func (r *apiKeyRepository) RecordUse(ctx context.Context, id string, client model.APIKeyClient) error {
+	_, err := r.db.ExecContext(ctx,
+		`WITH used AS (
+			UPDATE api_keys SET last_used_at = now(), last_used_ip = NULLIF($2, '')::inet
+			WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')
+			RETURNING id, prefix)
+		INSERT INTO api_key_events (key_id, prefix, event, source_ip, user_agent)
+		SELECT id, prefix, $4::varchar, NULLIF($2, '')::inet, $3::text FROM used`,
+		id, client.IP, client.UserAgent, model.APIKeyEventUsed)
+	return err
+}
+
+func (r *apiKeyRepository) RecordRejected(ctx context.Context, event *model.APIKeyEvent) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO api_key_events (key_id, prefix, event, reason, source_ip, user_agent)
+		VALUES ($1, $2, $3, $4, NULLIF($5, '')::inet, $6)`,
+		event.KeyID, event.Prefix, model.APIKeyEventRejected, event.Reason, event.SourceIP, event.UserAgent)
+	return err
+}
+
+// ListEvents returns the events of a key, newest first.
+func (r *apiKeyRepository) ListEvents(ctx context.Context, id string, limit int, after string) (*model.APIKeyEventList, error) {
+	args := &queryArgs{}
+	query := `SELECT id, key_id, prefix, event, reason, host(source_ip), user_agent, created_at
+		FROM api_key_events WHERE key_id = ` + args.add(id)
+	if after != "" {
+		c, err := decodeCursor(after)
+		if err != nil || c.Sort != apiKeyEventsSort {
+			return nil, ErrInvalidCursor
+		}
+		lastID, err := strconv.ParseInt(c.Values[0], 10, 64)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		query += ` AND id < ` + args.add(lastID)
+	}
+	// Fetch one extra row to find out whether there is a next page.
+	query += ` ORDER BY id DESC LIMIT ` + args.add(limit+1)
+
+	rows, err := r.db.QueryContext(ctx, query, args.values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]model.APIKeyEvent, 0, limit)
+	for rows.Next() {
+		var e model.APIKeyEvent
+		var keyID, sourceIP sql.NullString
+		if err := rows.Scan(&e.ID, &keyID, &e.Prefix, &e.Event, &e.Reason, &sourceIP, &e.UserAgent, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if keyID.Valid {
+			e.KeyID = &keyID.String
+		}
+		e.SourceIP = sourceIP.String
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	list := &model.APIKeyEventList{Items: events}
+	if len(events) > limit {
+		list.Items = events[:limit]
+		last := list.Items[limit-1]
+		list.NextCursor = encodeCursor(cursor{Sort: apiKeyEventsSort, Values: []string{strconv.FormatInt(last.ID, 10)}})
+	}
+	return list, nil
+}
+
+// PurgeEvents deletes events older than retention.
+func (r *apiKeyRepository) PurgeEvents(ctx context.Context, retention time.Duration) (int64, error) {
+	res, err := r.db.ExecContext(ctx,
+		`DELETE FROM api_key_events WHERE created_at < now() - make_interval(secs => $1)`, retention.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
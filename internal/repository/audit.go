@@ -0,0 +1,189 @@
+package repository
+
+import (
+	"context"
+	"cruder/internal/audit"
+	"cruder/internal/model"
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type AuditRepository interface {
+	ListUserHistory(ctx context.Context, uuid string, limit int, after string) (*model.UserAuditList, error)
+}
+
+type auditRepository struct {
+	db dbtx
+}
+
+func NewAuditRepository(db *sql.DB) AuditRepository {
+	return &auditRepository{db: db}
+}
+
+// historySort tags history cursors so list cursors cannot be replayed here.
+const historySort = "history"
+
+// ListUserHistory returns the audit entries of a user, newest first.
+func (r *auditRepository) ListUserHistory(ctx context.Context, uuid string, limit int, after string) (*model.UserAuditList, error) {
+	args := &queryArgs{}
+	query := `SELECT id, user_uuid, action, actor, request_id, host(source_ip), before, after, changes, created_at
+		FROM user_audit_log WHERE user_uuid = ` + args.add(uuid)
+	if after != "" {
+		c, err := decodeCursor(after)
+		if err != nil || c.Sort != historySort {
+			return nil, ErrInvalidCursor
+		}
+		id, err := strconv.ParseInt(c.Values[0], 10, 64)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		query += ` AND id < ` + args.add(id)
+	}
+	// Fetch one extra row to find out whether there is a next page.
+	query += ` ORDER BY id DESC LIMIT ` + args.add(limit+1)
+
+	rows, err := r.db.QueryContext(ctx, query, args.values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]model.UserAuditEntry, 0, limit)
+	for rows.Next() {
+		var e model.UserAuditEntry
+		var requestID, sourceIP sql.NullString
+		var before, after []byte
+		var changes []byte
+		if err := rows.Scan(&e.ID, &e.UserUUID, &e.Action, &e.Actor, &requestID, &sourceIP,
+			&before, &after, &changes, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.RequestID = requestID.String
+		e.SourceIP = sourceIP.String
+		e.Before = jsonOrNull(before)
+		e.After = jsonOrNull(after)
+		if err := json.Unmarshal(changes, &e.Changes); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	list := &model.UserAuditList{Items: entries}
+	if len(entries) > limit {
+		list.Items = entries[:limit]
+		last := list.Items[limit-1]
+		list.NextCursor = encodeCursor(cursor{Sort: historySort, Values: []string{strconv.FormatInt(last.ID, 10)}})
+	}
+	return list, nil
+}
+
+func jsonOrNull(data []byte) json.RawMessage {
+	if data == nil {
+		return json.RawMessage("null")
+	}
+	return data
+}
+
+// auditRecord is a change to the user identified by UUID.
+type auditRecord struct {
+	action string
+	uuid   string
+	before *model.User
+	after  *model.User
+}
+
+// recordAudit appends a change of the user identified by uuid to the audit
+// log. Callers pass the transaction that made the change so the record is
+// committed or rolled back together with it. The actor, request id and
+// source IP are taken from the audit.Source in ctx.
+func recordAudit(ctx context.Context, q dbtx, action, uuid string, before, after *model.User) error {
+	return recordAudits(ctx, q, []auditRecord{{action: action, uuid: uuid, before: before, after: after}})
+}
+
+// recordAudits appends several changes with a single multi-row insert.
+func recordAudits(ctx context.Context, q dbtx, records []auditRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	source := audit.SourceFrom(ctx)
+	args := &queryArgs{}
+	values := make([]string, len(records))
+	for i, rec := range records {
+		beforeJSON, beforeFields, err := userSnapshot(rec.before)
+		if err != nil {
+			return err
+		}
+		afterJSON, afterFields, err := userSnapshot(rec.after)
+		if err != nil {
+			return err
+		}
+		changes, err := json.Marshal(diffFields(beforeFields, afterFields))
+		if err != nil {
+			return err
+		}
+		values[i] = "(" + strings.Join([]string{
+			args.add(rec.uuid), args.add(rec.action), args.add(source.Actor),
+			"NULLIF(" + args.add(source.RequestID) + ", '')", "NULLIF(" + args.add(source.IP) + ", '')::inet",
+			args.add(nullJSON(beforeJSON)), args.add(nullJSON(afterJSON)), args.add(changes),
+		}, ", ") + ")"
+	}
+
+	// #nosec G202 -- values only contains placeholders
+	_, err := q.ExecContext(ctx,
+		`INSERT INTO user_audit_log (user_uuid, action, actor, request_id, source_ip, before, after, changes)
+		VALUES `+strings.Join(values, ", "), args.values...)
+	return err
+}
+
+// nullJSON turns a missing snapshot into SQL NULL; a nil []byte would be
+// sent as an empty, invalid JSON document.
+func nullJSON(data []byte) any {
+	if data == nil {
+		return nil
+	}
+	return data
+}
+
+// userSnapshot returns the JSON representation of u, as served by the API,
+// both encoded and decoded into its members. A nil user yields SQL NULL.
+func userSnapshot(u *model.User) ([]byte, map[string]any, error) {
+	if u == nil {
+		return nil, nil, nil
+	}
+	data, err := json.Marshal(u)
+	if err != nil {
+		return nil, nil, err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, nil, err
+	}
+	return data, fields, nil
+}
+
+// diffFields lists the members whose value differs between before and after.
+// version is left out since every change bumps it.
+func diffFields(before, after map[string]any) map[string]model.FieldChange {
+	changes := make(map[string]model.FieldChange)
+	for name, old := range before {
+		if name == "version" {
+			continue
+		}
+		if value := after[name]; !reflect.DeepEqual(old, value) {
+			changes[name] = model.FieldChange{Old: old, New: value}
+		}
+	}
+	for name, value := range after {
+		if _, ok := before[name]; !ok && name != "version" {
+			changes[name] = model.FieldChange{Old: nil, New: value}
+		}
+	}
+	return changes
+}
@@ -0,0 +1,31 @@
+package repository
+
+import (
+	"cruder/internal/model"
+	"reflect"
+	"testing"
+)
+
+func TestDiffFields(t *testing.T) {
+	before := map[string]any{"username": "alice", "email": "a@example.com", "version": float64(1)}
+	after := map[string]any{"username": "alice", "email": "b@example.com", "version": float64(2), "deleted_at": "2025-12-01T00:00:00Z"}
+
+	got := diffFields(before, after)
+	want := map[string]model.FieldChange{
+		"email":      {Old: "a@example.com", New: "b@example.com"},
+		"deleted_at": {Old: nil, New: "2025-12-01T00:00:00Z"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffFields() = %v, want %v", got, want)
+	}
+}
+
+func TestDiffFields_Purge(t *testing.T) {
+	before := map[string]any{"username": "alice", "version": float64(3)}
+
+	got := diffFields(before, nil)
+	want := map[string]model.FieldChange{"username": {Old: "alice", New: nil}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffFields() = %v, want %v", got, want)
+	}
+}
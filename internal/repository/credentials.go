@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+	"cruder/internal/model"
+	"database/sql"
+	"time"
+)
+
+type CredentialRepository interface {
+	Get(ctx context.Context, uuid string, forUpdate bool) (*model.Credential, error)
+	SetPassword(ctx context.Context, uuid, hash string) error
+	RecordFailure(ctx context.Context, uuid string, maxAttempts int, lockFor time.Duration) error
+	RecordLogin(ctx context.Context, uuid, oldHash, newHash string) error
+}
+
+type credentialRepository struct {
+	db dbtx
+}
+
+func NewCredentialRepository(db *sql.DB) CredentialRepository {
+	return &credentialRepository{db: db}
+}
+
+// Get returns the credential of the user with the given uuid, or nil. With
+// forUpdate the row is locked until the surrounding transaction ends.
+func (r *credentialRepository) Get(ctx context.Context, uuid string, forUpdate bool) (*model.Credential, error) {
+	query := `SELECT user_uuid, password_hash, failed_attempts, locked_until, last_login_at, created_at, updated_at
+		FROM user_credentials WHERE user_uuid = $1`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+	var cred model.Credential
+	var lockedUntil, lastLoginAt sql.NullTime
+	err := r.db.QueryRowContext(ctx, query, uuid).Scan(&cred.UserUUID, &cred.PasswordHash, &cred.FailedAttempts,
+		&lockedUntil, &lastLoginAt, &cred.CreatedAt, &cred.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if lockedUntil.Valid {
+		cred.LockedUntil = &lockedUntil.Time
+	}
+	if lastLoginAt.Valid {
+		cred.LastLoginAt = &lastLoginAt.Time
+	}
+	return &cred, nil
+}
+
+// SetPassword stores a new password hash for the user, which also lifts a
+// lockout, and records the change in the audit log.
+func (r *credentialRepository) SetPassword(ctx context.Context, uuid, hash string) error {
+	return runInTx(ctx, r.db, nil, func(tx dbtx) error {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO user_credentials (user_uuid, password_hash) VALUES ($1, $2)
+			ON CONFLICT (user_uuid) DO UPDATE SET password_hash = EXCLUDED.password_hash,
+				failed_attempts = 0, locked_until = NULL, updated_at = now()`,
+			uuid, hash); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, model.AuditActionPasswordChange, uuid, nil, nil)
+	})
+}
+
+// RecordFailure counts a wrong password. The maxAttempts-th failure in a row
+// locks the credential for lockFor and starts counting again.
+func (r *credentialRepository) RecordFailure(ctx context.Context, uuid string, maxAttempts int, lockFor time.Duration) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE user_credentials SET
+			locked_until = CASE WHEN failed_attempts + 1 >= $2 THEN now() + make_interval(secs => $3) ELSE locked_until END,
+			failed_attempts = CASE WHEN failed_attempts + 1 >= $2 THEN 0 ELSE failed_attempts + 1 END
+		WHERE user_uuid = $1`,
+		uuid, maxAttempts, lockFor.Seconds())
+	return err
+}
+
+// RecordLogin resets the failure count after a successful login. A non-empty
+// newHash replaces the stored hash, unless the password was changed since
+// oldHash was read.
+func (r *credentialRepository) RecordLogin(ctx context.Context, uuid, oldHash, newHash string) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE user_credentials SET failed_attempts = 0, locked_until = NULL, last_login_at = now(),
+			password_hash = CASE WHEN $3 <> '' AND password_hash = $2 THEN $3 ELSE password_hash END
+		WHERE user_uuid = $1`,
+		uuid, oldHash, newHash)
+	return err
+}
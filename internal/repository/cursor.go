@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursor is the keyset position of the last row of a page: the values of
+// every sort key of that row, in order. Sort records the ordering the cursor
+// was issued for so it cannot be replayed against a different one.
+// It is serialized as base64url JSON so clients treat it as opaque.
+type cursor struct {
+	Sort   string   `json:"s,omitempty"`
+	Values []string `json:"v"`
+}
+
+func encodeCursor(c cursor) string {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &c); err != nil || len(c.Values) == 0 {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
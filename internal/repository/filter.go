@@ -0,0 +1,367 @@
+package repository
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ParseError reports a malformed filter or sort expression.
+// Position is the zero-based byte offset in the input where parsing failed.
+type ParseError struct {
+	Input    string
+	Position int
+	Message  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: %s at position %d", e.Input, e.Message, e.Position)
+}
+
+type fieldKind int
+
+const (
+	kindInt fieldKind = iota
+	kindText
+	kindUUID
+	kindTime
+)
+
+// field describes a column that may be referenced from filter and sort expressions.
+type field struct {
+	expr     string
+	kind     fieldKind
+	sortable bool
+}
+
+// parseValue converts a literal from a filter or cursor into a value of the field kind.
+func (f field) parseValue(raw string) (any, error) {
+	switch f.kind {
+	case kindInt:
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected integer, got %q", raw)
+		}
+		return v, nil
+	case kindTime:
+		if t, err := time.Parse(time.DateOnly, raw); err == nil {
+			return t, nil
+		}
+		t, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			return nil, fmt.Errorf("expected date (YYYY-MM-DD) or RFC 3339 timestamp, got %q", raw)
+		}
+		return t, nil
+	default:
+		return raw, nil
+	}
+}
+
+type operator struct {
+	sql   string
+	kinds []fieldKind
+	// pattern wraps the escaped value for LIKE based operators.
+	pattern func(string) string
+}
+
+var (
+	orderedKinds  = []fieldKind{kindInt, kindText, kindTime}
+	equalityKinds = []fieldKind{kindInt, kindText, kindUUID, kindTime}
+	textKinds     = []fieldKind{kindText}
+)
+
+var operators = map[string]operator{
+	"=":           {sql: "=", kinds: equalityKinds},
+	"eq":          {sql: "=", kinds: equalityKinds},
+	"!=":          {sql: "<>", kinds: equalityKinds},
+	"ne":          {sql: "<>", kinds: equalityKinds},
+	">":           {sql: ">", kinds: orderedKinds},
+	"gt":          {sql: ">", kinds: orderedKinds},
+	">=":          {sql: ">=", kinds: orderedKinds},
+	"ge":          {sql: ">=", kinds: orderedKinds},
+	"<":           {sql: "<", kinds: orderedKinds},
+	"lt":          {sql: "<", kinds: orderedKinds},
+	"<=":          {sql: "<=", kinds: orderedKinds},
+	"le":          {sql: "<=", kinds: orderedKinds},
+	"contains":    {sql: "ILIKE", kinds: textKinds, pattern: func(v string) string { return "%" + v + "%" }},
+	"starts_with": {sql: "ILIKE", kinds: textKinds, pattern: func(v string) string { return v + "%" }},
+	"ends_with":   {sql: "ILIKE", kinds: textKinds, pattern: func(v string) string { return "%" + v }},
+}
+
+func (o operator) supports(kind fieldKind) bool {
+	for _, k := range o.kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// queryArgs collects positional arguments while a WHERE clause is being built.
+type queryArgs struct {
+	values []any
+}
+
+func (a *queryArgs) add(v any) string {
+	a.values = append(a.values, v)
+	return "$" + strconv.Itoa(len(a.values))
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenOperator
+	tokenLParen
+	tokenRParen
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value string
+	pos   int
+}
+
+func tokenize(input string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(input) {
+		c := input[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")", pos: i})
+			i++
+		case c == '"':
+			start := i
+			var sb strings.Builder
+			i++
+			closed := false
+			for i < len(input) {
+				if input[i] == '\\' && i+1 < len(input) {
+					sb.WriteByte(input[i+1])
+					i += 2
+					continue
+				}
+				if input[i] == '"' {
+					closed = true
+					i++
+					break
+				}
+				sb.WriteByte(input[i])
+				i++
+			}
+			if !closed {
+				return nil, &ParseError{Input: "filter", Position: start, Message: "unterminated string"}
+			}
+			tokens = append(tokens, token{kind: tokenString, text: input[start:i], value: sb.String(), pos: start})
+		case strings.ContainsRune("=!<>", rune(c)):
+			start := i
+			for i < len(input) && strings.ContainsRune("=!<>", rune(input[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: input[start:i], pos: start})
+		default:
+			start := i
+			for i < len(input) && !unicode.IsSpace(rune(input[i])) && !strings.ContainsRune(`()"=!<>`, rune(input[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: input[start:i], value: input[start:i], pos: start})
+		}
+	}
+	tokens = append(tokens, token{kind: tokenEOF, pos: len(input)})
+	return tokens, nil
+}
+
+// filterParser is a recursive descent parser for expressions such as
+//
+//	email ends_with "@acme.com" and (created_at > 2025-01-01 or username = admin)
+//
+// It writes a parameterized SQL condition; field names are resolved
+// through the allow-list so user input never reaches the query text.
+type filterParser struct {
+	tokens []token
+	pos    int
+	fields map[string]field
+	args   *queryArgs
+}
+
+// parseFilter converts a filter expression into an SQL condition.
+// Literal values are appended to args and referenced by placeholder.
+func parseFilter(input string, fields map[string]field, args *queryArgs) (string, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return "", err
+	}
+	p := &filterParser{tokens: tokens, fields: fields, args: args}
+	cond, err := p.parseOr()
+	if err != nil {
+		return "", err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return "", p.errorf(tok, "unexpected %q", tok.text)
+	}
+	return cond, nil
+}
+
+func (p *filterParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *filterParser) isKeyword(word string) bool {
+	tok := p.peek()
+	return tok.kind == tokenWord && strings.EqualFold(tok.text, word)
+}
+
+func (p *filterParser) errorf(tok token, format string, args ...any) error {
+	msg := fmt.Sprintf(format, args...)
+	if tok.kind == tokenEOF {
+		msg = "unexpected end of input"
+	}
+	return &ParseError{Input: "filter", Position: tok.pos, Message: msg}
+}
+
+func (p *filterParser) parseOr() (string, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return "", err
+	}
+	for p.isKeyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return "", err
+		}
+		left = "(" + left + " OR " + right + ")"
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (string, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return "", err
+	}
+	for p.isKeyword("and") {
+		p.next()
+		right, err := p.parsePrimary()
+		if err != nil {
+			return "", err
+		}
+		left = "(" + left + " AND " + right + ")"
+	}
+	return left, nil
+}
+
+func (p *filterParser) parsePrimary() (string, error) {
+	tok := p.peek()
+	if tok.kind == tokenLParen {
+		p.next()
+		cond, err := p.parseOr()
+		if err != nil {
+			return "", err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return "", p.errorf(closing, "expected \")\"")
+		}
+		return cond, nil
+	}
+	return p.parseCondition()
+}
+
+func (p *filterParser) parseCondition() (string, error) {
+	nameTok := p.next()
+	if nameTok.kind != tokenWord {
+		return "", p.errorf(nameTok, "expected field name, got %q", nameTok.text)
+	}
+	f, ok := p.fields[nameTok.text]
+	if !ok {
+		return "", p.errorf(nameTok, "unknown field %q", nameTok.text)
+	}
+
+	opTok := p.next()
+	if opTok.kind != tokenOperator && opTok.kind != tokenWord {
+		return "", p.errorf(opTok, "expected operator, got %q", opTok.text)
+	}
+	op, ok := operators[strings.ToLower(opTok.text)]
+	if !ok {
+		return "", p.errorf(opTok, "unknown operator %q", opTok.text)
+	}
+	if !op.supports(f.kind) {
+		return "", p.errorf(opTok, "operator %q is not supported for field %q", opTok.text, nameTok.text)
+	}
+
+	valueTok := p.next()
+	if valueTok.kind != tokenWord && valueTok.kind != tokenString {
+		return "", p.errorf(valueTok, "expected value, got %q", valueTok.text)
+	}
+	if op.pattern != nil {
+		return f.expr + " " + op.sql + " " + p.args.add(op.pattern(likeEscaper.Replace(valueTok.value))), nil
+	}
+	value, err := f.parseValue(valueTok.value)
+	if err != nil {
+		return "", p.errorf(valueTok, "%s", err.Error())
+	}
+	return f.expr + " " + op.sql + " " + p.args.add(value), nil
+}
+
+// sortKey is a single ORDER BY term.
+type sortKey struct {
+	name  string
+	field field
+	desc  bool
+}
+
+// parseSort parses a comma separated list of field names, each optionally
+// prefixed with "-" for descending order, e.g. "-created_at,username".
+func parseSort(input string, fields map[string]field) ([]sortKey, error) {
+	var keys []sortKey
+	seen := make(map[string]bool)
+	pos := 0
+	for _, part := range strings.Split(input, ",") {
+		start := pos + len(part) - len(strings.TrimLeft(part, " "))
+		pos += len(part) + 1
+
+		name := strings.TrimSpace(part)
+		desc := false
+		if strings.HasPrefix(name, "-") {
+			desc = true
+			name = name[1:]
+		} else if strings.HasPrefix(name, "+") {
+			name = name[1:]
+		}
+		if name == "" {
+			return nil, &ParseError{Input: "sort", Position: start, Message: "expected field name"}
+		}
+
+		f, ok := fields[name]
+		if !ok || !f.sortable {
+			return nil, &ParseError{Input: "sort", Position: start, Message: fmt.Sprintf("unknown or unsortable field %q", name)}
+		}
+		if seen[name] {
+			return nil, &ParseError{Input: "sort", Position: start, Message: fmt.Sprintf("duplicate field %q", name)}
+		}
+		seen[name] = true
+		keys = append(keys, sortKey{name: name, field: f, desc: desc})
+	}
+	return keys, nil
+}
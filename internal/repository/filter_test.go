@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseFilter_Valid(t *testing.T) {
+	tests := []struct {
+		input string
+		sql   string
+		args  []any
+	}{
+		{
+			input: `email ends_with "@acme.com" and created_at > 2025-01-01`,
+			sql:   `(email ILIKE $1 AND created_at > $2)`,
+			args:  []any{"%@acme.com", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			input: `username = alice or (id >= 10 and full_name contains "50%")`,
+			sql:   `(username = $1 OR (id >= $2 AND COALESCE(full_name, '') ILIKE $3))`,
+			args:  []any{"alice", int64(10), `%50\%%`},
+		},
+	}
+
+	for _, tt := range tests {
+		args := &queryArgs{}
+		sql, err := parseFilter(tt.input, userFields, args)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.input, err)
+		}
+		if sql != tt.sql {
+			t.Errorf("%s: expected sql %q, got %q", tt.input, tt.sql, sql)
+		}
+		if len(args.values) != len(tt.args) {
+			t.Fatalf("%s: expected %d args, got %d", tt.input, len(tt.args), len(args.values))
+		}
+		for i := range tt.args {
+			if args.values[i] != tt.args[i] {
+				t.Errorf("%s: arg %d: expected %v, got %v", tt.input, i, tt.args[i], args.values[i])
+			}
+		}
+	}
+}
+
+func TestParseFilter_Errors(t *testing.T) {
+	tests := []struct {
+		input    string
+		position int
+	}{
+		{input: `password = x`, position: 0},
+		{input: `email like "x"`, position: 6},
+		{input: `id > abc`, position: 5},
+		{input: `uuid contains "a"`, position: 5},
+		{input: `email = "unterminated`, position: 8},
+		{input: `(email = a`, position: 10},
+		{input: `email = a and`, position: 13},
+		{input: `email = a b`, position: 10},
+	}
+
+	for _, tt := range tests {
+		_, err := parseFilter(tt.input, userFields, &queryArgs{})
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("%s: expected ParseError, got %v", tt.input, err)
+		}
+		if parseErr.Position != tt.position {
+			t.Errorf("%s: expected position %d, got %d", tt.input, tt.position, parseErr.Position)
+		}
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	keys, err := parseSort("-created_at, username", userFields)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := sortSignature(withIDTiebreak(keys)); got != "-created_at,username,id" {
+		t.Errorf("expected signature -created_at,username,id, got %s", got)
+	}
+
+	_, err = parseSort("username,uuid", userFields)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.Position != 9 {
+		t.Errorf("expected ParseError at position 9, got %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"context"
+	"cruder/internal/model"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+type IdempotencyRepository interface {
+	Reserve(ctx context.Context, scope, key, fingerprint string, ttl, lockTimeout time.Duration) (*model.IdempotencyRecord, bool, error)
+	Complete(ctx context.Context, record *model.IdempotencyRecord) error
+	Release(ctx context.Context, record *model.IdempotencyRecord) error
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+type idempotencyRepository struct {
+	db dbtx
+}
+
+func NewIdempotencyRepository(db *sql.DB) IdempotencyRepository {
+	return &idempotencyRepository{db: db}
+}
+
+const idempotencyColumns = `scope, key, reservation_id, fingerprint, status_code, response_header, response_body, created_at, expires_at`
+
+func scanIdempotencyRecord(row rowScanner) (*model.IdempotencyRecord, error) {
+	var record model.IdempotencyRecord
+	var status sql.NullInt64
+	var header []byte
+	if err := row.Scan(&record.Scope, &record.Key, &record.ReservationID, &record.Fingerprint, &status, &header,
+		&record.Body, &record.CreatedAt, &record.ExpiresAt); err != nil {
+		return nil, err
+	}
+	record.Status = int(status.Int64)
+	if header != nil {
+		if err := json.Unmarshal(header, &record.Header); err != nil {
+			return nil, err
+		}
+	}
+	return &record, nil
+}
+
+// Reserve claims key for a request with the given fingerprint. If the key
+// is new, expired, or held by a request that has not completed within
+// lockTimeout, it is reserved for ttl and reserved is true. Otherwise the
+// existing record is returned so that the caller can replay or reject.
+func (r *idempotencyRepository) Reserve(ctx context.Context, scope, key, fingerprint string, ttl, lockTimeout time.Duration) (*model.IdempotencyRecord, bool, error) {
+	for attempt := 1; ; attempt++ {
+		record, err := scanIdempotencyRecord(r.db.QueryRowContext(ctx,
+			`INSERT INTO idempotency_keys (scope, key, fingerprint, locked_until, expires_at)
+			VALUES ($1, $2, $3, now() + make_interval(secs => $4), now() + make_interval(secs => $5))
+			ON CONFLICT (scope, key) DO UPDATE SET
+				reservation_id = gen_random_uuid(),
+				fingerprint = EXCLUDED.fingerprint,
+				status_code = NULL,
+				response_header = NULL,
+				response_body = NULL,
+				created_at = now(),
+				locked_until = EXCLUDED.locked_until,
+				expires_at = EXCLUDED.expires_at
+			WHERE idempotency_keys.expires_at <= now()
+				OR (idempotency_keys.status_code IS NULL AND idempotency_keys.locked_until <= now())
+			RETURNING `+idempotencyColumns,
+			scope, key, fingerprint, lockTimeout.Seconds(), ttl.Seconds()))
+		if err == nil {
+			return record, true, nil
+		}
+		if err != sql.ErrNoRows {
+			return nil, false, err
+		}
+
+		record, err = scanIdempotencyRecord(r.db.QueryRowContext(ctx,
+			`SELECT `+idempotencyColumns+` FROM idempotency_keys WHERE scope = $1 AND key = $2`, scope, key))
+		if err == nil {
+			return record, false, nil
+		}
+		if err != sql.ErrNoRows || attempt == 3 {
+			return nil, false, err
+		}
+		// The holder released the key in between; try to reserve it again.
+	}
+}
+
+// Complete stores the response of a reserved record. It does nothing if
+// the reservation has since expired and been taken over by another request.
+func (r *idempotencyRepository) Complete(ctx context.Context, record *model.IdempotencyRecord) error {
+	header, err := json.Marshal(record.Header)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx,
+		`UPDATE idempotency_keys SET status_code = $4, response_header = $5, response_body = $6
+		WHERE scope = $1 AND key = $2 AND reservation_id = $3 AND status_code IS NULL`,
+		record.Scope, record.Key, record.ReservationID, record.Status, header, record.Body)
+	return err
+}
+
+// Release drops a reservation that has not been completed, so that the
+// request can be retried with the same key. Like Complete, it leaves a
+// reservation that another request took over alone.
+func (r *idempotencyRepository) Release(ctx context.Context, record *model.IdempotencyRecord) error {
+	_, err := r.db.ExecContext(ctx,
+		`DELETE FROM idempotency_keys WHERE scope = $1 AND key = $2 AND reservation_id = $3 AND status_code IS NULL`,
+		record.Scope, record.Key, record.ReservationID)
+	return err
+}
+
+func (r *idempotencyRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= now()`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
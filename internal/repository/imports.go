@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"cruder/internal/model"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+type ImportRepository interface {
+	Create(ctx context.Context, job *model.ImportJob) error
+	Get(ctx context.Context, id string) (*model.ImportJob, error)
+	Update(ctx context.Context, job *model.ImportJob) error
+	AddErrors(ctx context.Context, jobID string, errs []model.ImportError) error
+	Errors(ctx context.Context, jobID string, fn func(model.ImportError) error) error
+	FailStale(ctx context.Context, staleFor time.Duration, message string) (int64, error)
+}
+
+type importRepository struct {
+	db dbtx
+}
+
+func NewImportRepository(db *sql.DB) ImportRepository {
+	return &importRepository{db: db}
+}
+
+const importJobColumns = `id, status, format, dry_run, processed_rows, succeeded_rows, failed_rows, error, created_at, finished_at`
+
+func scanImportJob(row rowScanner) (*model.ImportJob, error) {
+	var job model.ImportJob
+	var jobErr sql.NullString
+	var finishedAt sql.NullTime
+	if err := row.Scan(&job.ID, &job.Status, &job.Format, &job.DryRun,
+		&job.Processed, &job.Succeeded, &job.Failed, &jobErr, &job.CreatedAt, &finishedAt); err != nil {
+		return nil, err
+	}
+	job.Error = jobErr.String
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	return &job, nil
+}
+
+// Create inserts job and fills in its generated id and creation time.
+func (r *importRepository) Create(ctx context.Context, job *model.ImportJob) error {
+	return r.db.QueryRowContext(ctx,
+		`INSERT INTO user_import_jobs (status, format, dry_run) VALUES ($1, $2, $3) RETURNING id, created_at`,
+		job.Status, job.Format, job.DryRun).Scan(&job.ID, &job.CreatedAt)
+}
+
+func (r *importRepository) Get(ctx context.Context, id string) (*model.ImportJob, error) {
+	job, err := scanImportJob(r.db.QueryRowContext(ctx,
+		`SELECT `+importJobColumns+` FROM user_import_jobs WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return job, err
+}
+
+// Update stores the status, counters, error and finish time of job.
+func (r *importRepository) Update(ctx context.Context, job *model.ImportJob) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE user_import_jobs SET status = $2, processed_rows = $3, succeeded_rows = $4, failed_rows = $5,
+			error = NULLIF($6, ''), finished_at = $7, updated_at = now()
+		WHERE id = $1`,
+		job.ID, job.Status, job.Processed, job.Succeeded, job.Failed, job.Error, job.FinishedAt)
+	return err
+}
+
+func (r *importRepository) AddErrors(ctx context.Context, jobID string, errs []model.ImportError) error {
+	// Four parameters per row stay well below the bind parameter limit.
+	for start := 0; start < len(errs); start += createChunkSize {
+		chunk := errs[start:min(start+createChunkSize, len(errs))]
+		args := &queryArgs{}
+		values := make([]string, len(chunk))
+		for i, e := range chunk {
+			values[i] = "(" + args.add(jobID) + ", " + args.add(e.Line) + ", " + args.add(e.Field) + ", " + args.add(e.Message) + ")"
+		}
+		// #nosec G202 -- values only contains placeholders
+		if _, err := r.db.ExecContext(ctx,
+			`INSERT INTO user_import_errors (job_id, line, field, message) VALUES `+strings.Join(values, ", "),
+			args.values...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Errors calls fn for every error of the job in line order, stopping at the
+// first error fn returns.
+func (r *importRepository) Errors(ctx context.Context, jobID string, fn func(model.ImportError) error) error {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT line, field, message FROM user_import_errors WHERE job_id = $1 ORDER BY line, id`, jobID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e model.ImportError
+		if err := rows.Scan(&e.Line, &e.Field, &e.Message); err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// FailStale marks unfinished jobs that have not been updated for staleFor as
+// failed with message, and returns how many there were. Such jobs belonged
+// to a process that stopped without finishing them.
+func (r *importRepository) FailStale(ctx context.Context, staleFor time.Duration, message string) (int64, error) {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE user_import_jobs SET status = $1, error = $2, finished_at = now(), updated_at = now()
+		WHERE status IN ($3, $4) AND updated_at < now() - make_interval(secs => $5)`,
+		model.ImportJobFailed, message, model.ImportJobPending, model.ImportJobRunning, staleFor.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
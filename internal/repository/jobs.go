@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+	"cruder/internal/model"
+	"database/sql"
+	"database/sql/driver"
+)
+
+type JobRepository interface {
+	TryLock(ctx context.Context, name string) (unlock func(), acquired bool, err error)
+	RecordRun(ctx context.Context, run *model.JobRun) error
+	LastRuns(ctx context.Context) (map[string]model.JobRun, error)
+}
+
+type jobRepository struct {
+	db dbtx
+}
+
+func NewJobRepository(db *sql.DB) JobRepository {
+	return &jobRepository{db: db}
+}
+
+// TryLock takes a session level advisory lock named after the job so that
+// only one replica runs it at a time. The lock is held on a dedicated
+// connection until unlock is called; acquired is false if another session
+// holds it. Inside a transaction the lock is released when it ends instead.
+func (r *jobRepository) TryLock(ctx context.Context, name string) (func(), bool, error) {
+	pool, ok := r.db.(*sql.DB)
+	if !ok {
+		var acquired bool
+		err := r.db.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock(hashtext($1))`, name).Scan(&acquired)
+		return func() {}, acquired, err
+	}
+
+	conn, err := pool.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock(hashtext($1))`, name).Scan(&acquired); err != nil {
+		_ = conn.Close()
+		return nil, false, err
+	}
+	if !acquired {
+		_ = conn.Close()
+		return nil, false, nil
+	}
+
+	unlock := func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock(hashtext($1))`, name); err != nil {
+			// Never hand a connection that may still hold the lock back to the pool.
+			_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+		_ = conn.Close()
+	}
+	return unlock, true, nil
+}
+
+func (r *jobRepository) RecordRun(ctx context.Context, run *model.JobRun) error {
+	query := `INSERT INTO job_runs (job_name, started_at, finished_at, status, rows_affected, error)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, '')) RETURNING id`
+	return r.db.QueryRowContext(ctx, query,
+		run.Job, run.StartedAt, run.FinishedAt, run.Status, run.RowsAffected, run.Error).Scan(&run.ID)
+}
+
+// LastRuns returns the most recent run of every job, keyed by job name.
+func (r *jobRepository) LastRuns(ctx context.Context) (map[string]model.JobRun, error) {
+	query := `SELECT DISTINCT ON (job_name) id, job_name, started_at, finished_at, status, rows_affected, error
+		FROM job_runs ORDER BY job_name, started_at DESC`
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := make(map[string]model.JobRun)
+	for rows.Next() {
+		var run model.JobRun
+		var runErr sql.NullString
+		if err := rows.Scan(&run.ID, &run.Job, &run.StartedAt, &run.FinishedAt, &run.Status, &run.RowsAffected, &runErr); err != nil {
+			return nil, err
+		}
+		run.Error = runErr.String
+		runs[run.Job] = run
+	}
+	return runs, rows.Err()
+}
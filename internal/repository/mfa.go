@@ -0,0 +1,199 @@
+package repository
+
+import (
+	"context"
+	"cruder/internal/model"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrMFAEnabled = errors.New("MFA is already enabled")
+
+type MFARepository interface {
+	Get(ctx context.Context, uuid string, forUpdate bool) (*model.TOTPFactor, error)
+	Enroll(ctx context.Context, uuid string, ciphertext []byte) error
+	Enable(ctx context.Context, uuid string, step int64, codeHashes [][]byte) error
+	UpdateSecret(ctx context.Context, uuid string, ciphertext []byte) error
+	UseStep(ctx context.Context, uuid string, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, uuid string, hash []byte) (bool, error)
+	RecordFailure(ctx context.Context, uuid string, maxAttempts int, lockFor time.Duration) error
+	CountRecoveryCodes(ctx context.Context, uuid string) (int, error)
+	Delete(ctx context.Context, uuid string) (bool, error)
+}
+
+type mfaRepository struct {
+	db dbtx
+}
+
+func NewMFARepository(db *sql.DB) MFARepository {
+	return &mfaRepository{db: db}
+}
+
+// Get returns the TOTP factor of the user with the given uuid, or nil. With
+// forUpdate the row is locked until the surrounding transaction ends.
+func (r *mfaRepository) Get(ctx context.Context, uuid string, forUpdate bool) (*model.TOTPFactor, error) {
+	query := `SELECT user_uuid, secret_ciphertext, enabled_at, last_used_step, failed_attempts, locked_until, created_at
+		FROM user_mfa WHERE user_uuid = $1`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+	var factor model.TOTPFactor
+	var enabledAt, lockedUntil sql.NullTime
+	err := r.db.QueryRowContext(ctx, query, uuid).Scan(&factor.UserUUID, &factor.SecretCiphertext, &enabledAt,
+		&factor.LastUsedStep, &factor.FailedAttempts, &lockedUntil, &factor.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if enabledAt.Valid {
+		factor.EnabledAt = &enabledAt.Time
+	}
+	if lockedUntil.Valid {
+		factor.LockedUntil = &lockedUntil.Time
+	}
+	return &factor, nil
+}
+
+// Enroll stores a pending factor with a new secret, replacing a pending
+// one. It returns ErrMFAEnabled if the user's factor is enabled.
+func (r *mfaRepository) Enroll(ctx context.Context, uuid string, ciphertext []byte) error {
+	res, err := r.db.ExecContext(ctx,
+		`INSERT INTO user_mfa (user_uuid, secret_ciphertext) VALUES ($1, $2)
+		ON CONFLICT (user_uuid) DO UPDATE SET secret_ciphertext = EXCLUDED.secret_ciphertext,
+			last_used_step = 0, failed_attempts = 0, locked_until = NULL, created_at = now()
+		WHERE user_mfa.enabled_at IS NULL`,
+		uuid, ciphertext)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrMFAEnabled
+	}
+	return nil
+}
+
+// Enable enables a pending factor whose code for step was just used, stores
+// its recovery codes and records the change in the audit log. It returns
+// ErrMFAEnabled if the factor is enabled already.
+func (r *mfaRepository) Enable(ctx context.Context, uuid string, step int64, codeHashes [][]byte) error {
+	return runInTx(ctx, r.db, nil, func(tx dbtx) error {
+		res, err := tx.ExecContext(ctx,
+			`UPDATE user_mfa SET enabled_at = now(), last_used_step = $2
+			WHERE user_uuid = $1 AND enabled_at IS NULL`,
+			uuid, step)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrMFAEnabled
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO user_recovery_codes (user_uuid, code_hash) SELECT $1::uuid, unnest($2::bytea[])`,
+			uuid, pq.ByteaArray(codeHashes)); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, model.AuditActionMFAEnable, uuid, nil, nil)
+	})
+}
+
+// UpdateSecret replaces the encrypted secret, e.g. to encrypt it with a new
+// key. The secret itself stays the same.
+func (r *mfaRepository) UpdateSecret(ctx context.Context, uuid string, ciphertext []byte) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE user_mfa SET secret_ciphertext = $2 WHERE user_uuid = $1`,
+		uuid, ciphertext)
+	return err
+}
+
+// UseStep records that the code of step was used and resets the failure
+// count. It reports false if a code of step or a later one was used before,
+// so that concurrent logins cannot both use a code.
+func (r *mfaRepository) UseStep(ctx context.Context, uuid string, step int64) (bool, error) {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE user_mfa SET last_used_step = $2, failed_attempts = 0, locked_until = NULL
+		WHERE user_uuid = $1 AND last_used_step < $2`,
+		uuid, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// UseRecoveryCode marks the unused recovery code with the given hash as used
+// and resets the failure count. It reports false if there is no such code.
+func (r *mfaRepository) UseRecoveryCode(ctx context.Context, uuid string, hash []byte) (bool, error) {
+	var used bool
+	err := r.db.QueryRowContext(ctx,
+		`WITH used AS (
+			UPDATE user_recovery_codes SET used_at = now()
+			WHERE user_uuid = $1 AND code_hash = $2 AND used_at IS NULL
+			RETURNING user_uuid
+		), reset AS (
+			UPDATE user_mfa SET failed_attempts = 0, locked_until = NULL
+			WHERE user_uuid IN (SELECT user_uuid FROM used)
+		)
+		SELECT EXISTS (SELECT 1 FROM used)`,
+		uuid, hash).Scan(&used)
+	return used, err
+}
+
+// RecordFailure counts a wrong code. The maxAttempts-th failure in a row
+// locks the factor for lockFor and starts counting again.
+func (r *mfaRepository) RecordFailure(ctx context.Context, uuid string, maxAttempts int, lockFor time.Duration) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE user_mfa SET
+			locked_until = CASE WHEN failed_attempts + 1 >= $2 THEN now() + make_interval(secs => $3) ELSE locked_until END,
+			failed_attempts = CASE WHEN failed_attempts + 1 >= $2 THEN 0 ELSE failed_attempts + 1 END
+		WHERE user_uuid = $1`,
+		uuid, maxAttempts, lockFor.Seconds())
+	return err
+}
+
+// CountRecoveryCodes returns how many of the user's recovery codes are
+// unused.
+func (r *mfaRepository) CountRecoveryCodes(ctx context.Context, uuid string) (int, error) {
+	var n int
+	err := r.db.QueryRowContext(ctx,
+		`SELECT count(*) FROM user_recovery_codes WHERE user_uuid = $1 AND used_at IS NULL`,
+		uuid).Scan(&n)
+	return n, err
+}
+
+// Delete removes the user's factor, pending or enabled, with its recovery
+// codes. Removing an enabled factor is recorded in the audit log. It
+// reports false if the user has none.
+func (r *mfaRepository) Delete(ctx context.Context, uuid string) (bool, error) {
+	var deleted bool
+	err := runInTx(ctx, r.db, nil, func(tx dbtx) error {
+		var enabled bool
+		err := tx.QueryRowContext(ctx,
+			`DELETE FROM user_mfa WHERE user_uuid = $1 RETURNING enabled_at IS NOT NULL`,
+			uuid).Scan(&enabled)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		deleted = true
+		if !enabled {
+			return nil
+		}
+		return recordAudit(ctx, tx, model.AuditActionMFAReset, uuid, nil, nil)
+	})
+	return deleted, err
+}
@@ -0,0 +1,167 @@
+package repository
+
+import (
+	"context"
+	"cruder/internal/model"
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+type OAuthRepository interface {
+	CreateClient(ctx context.Context, client *model.OAuthClient) error
+	GetClient(ctx context.Context, id string) (*model.OAuthClient, error)
+	ListClients(ctx context.Context, includeRevoked bool) ([]model.OAuthClient, error)
+	RevokeClient(ctx context.Context, id string) (bool, error)
+	CreateRefreshToken(ctx context.Context, token *model.RefreshToken) error
+	GetRefreshToken(ctx context.Context, hash []byte, forUpdate bool) (*model.RefreshToken, error)
+	UseRefreshToken(ctx context.Context, id string) error
+	RevokeRefreshTokens(ctx context.Context, familyID string) error
+	DeleteExpiredRefreshTokens(ctx context.Context) (int64, error)
+}
+
+type oauthRepository struct {
+	db dbtx
+}
+
+func NewOAuthRepository(db *sql.DB) OAuthRepository {
+	return &oauthRepository{db: db}
+}
+
+const oauthClientColumns = `id, name, owner, secret_hash, scopes, grant_types, created_at, revoked_at`
+
+func scanOAuthClient(row rowScanner) (*model.OAuthClient, error) {
+	var client model.OAuthClient
+	var revokedAt sql.NullTime
+	if err := row.Scan(&client.ID, &client.Name, &client.Owner, &client.SecretHash, pq.Array(&client.Scopes),
+		pq.Array(&client.GrantTypes), &client.CreatedAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		client.RevokedAt = &revokedAt.Time
+	}
+	return &client, nil
+}
+
+// CreateClient inserts client and fills in its generated id and creation
+// time.
+func (r *oauthRepository) CreateClient(ctx context.Context, client *model.OAuthClient) error {
+	return r.db.QueryRowContext(ctx,
+		`INSERT INTO oauth_clients (name, owner, secret_hash, scopes, grant_types)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`,
+		client.Name, client.Owner, client.SecretHash, pq.Array(client.Scopes), pq.Array(client.GrantTypes),
+	).Scan(&client.ID, &client.CreatedAt)
+}
+
+// GetClient returns the client with the given id, or nil.
+func (r *oauthRepository) GetClient(ctx context.Context, id string) (*model.OAuthClient, error) {
+	client, err := scanOAuthClient(r.db.QueryRowContext(ctx,
+		`SELECT `+oauthClientColumns+` FROM oauth_clients WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return client, err
+}
+
+// ListClients returns all clients, oldest first, leaving out revoked ones
+// unless includeRevoked is set.
+func (r *oauthRepository) ListClients(ctx context.Context, includeRevoked bool) ([]model.OAuthClient, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+oauthClientColumns+` FROM oauth_clients WHERE $1 OR revoked_at IS NULL ORDER BY created_at, id`,
+		includeRevoked)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []model.OAuthClient{}
+	for rows.Next() {
+		client, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, *client)
+	}
+	return clients, rows.Err()
+}
+
+// RevokeClient disables a client and revokes its refresh tokens. Revoking a
+// revoked client keeps the original time. It reports whether the client
+// exists.
+func (r *oauthRepository) RevokeClient(ctx context.Context, id string) (bool, error) {
+	var found bool
+	err := r.db.QueryRowContext(ctx,
+		`WITH client AS (
+			UPDATE oauth_clients SET revoked_at = COALESCE(revoked_at, now()) WHERE id = $1 RETURNING id
+		), tokens AS (
+			UPDATE oauth_refresh_tokens SET revoked_at = COALESCE(revoked_at, now())
+			WHERE client_id IN (SELECT id FROM client)
+		)
+		SELECT EXISTS (SELECT 1 FROM client)`, id).Scan(&found)
+	return found, err
+}
+
+// CreateRefreshToken inserts token and fills in its generated id and
+// creation time. A token without a family starts a new one.
+func (r *oauthRepository) CreateRefreshToken(ctx context.Context, token *model.RefreshToken) error {
+	var familyID sql.NullString
+	if token.FamilyID != "" {
+		familyID = sql.NullString{String: token.FamilyID, Valid: true}
+	}
+	return r.db.QueryRowContext(ctx,
+		`INSERT INTO oauth_refresh_tokens (client_id, family_id, token_hash, scopes, expires_at)
+		VALUES ($1, COALESCE($2::uuid, gen_random_uuid()), $3, $4, $5) RETURNING id, family_id, created_at`,
+		token.ClientID, familyID, token.TokenHash, pq.Array(token.Scopes), token.ExpiresAt,
+	).Scan(&token.ID, &token.FamilyID, &token.CreatedAt)
+}
+
+// GetRefreshToken returns the token with the given hash, or nil. With
+// forUpdate the row is locked until the surrounding transaction ends.
+func (r *oauthRepository) GetRefreshToken(ctx context.Context, hash []byte, forUpdate bool) (*model.RefreshToken, error) {
+	query := `SELECT id, client_id, family_id, scopes, token_hash, created_at, expires_at, used_at, revoked_at
+		FROM oauth_refresh_tokens WHERE token_hash = $1`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+	var token model.RefreshToken
+	var usedAt, revokedAt sql.NullTime
+	err := r.db.QueryRowContext(ctx, query, hash).Scan(&token.ID, &token.ClientID, &token.FamilyID,
+		pq.Array(&token.Scopes), &token.TokenHash, &token.CreatedAt, &token.ExpiresAt, &usedAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if usedAt.Valid {
+		token.UsedAt = &usedAt.Time
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+	return &token, nil
+}
+
+// UseRefreshToken marks a token as used.
+func (r *oauthRepository) UseRefreshToken(ctx context.Context, id string) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE oauth_refresh_tokens SET used_at = now() WHERE id = $1`, id)
+	return err
+}
+
+// RevokeRefreshTokens revokes every token of a family.
+func (r *oauthRepository) RevokeRefreshTokens(ctx context.Context, familyID string) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE oauth_refresh_tokens SET revoked_at = COALESCE(revoked_at, now()) WHERE family_id = $1`, familyID)
+	return err
+}
+
+// DeleteExpiredRefreshTokens deletes tokens that have expired. Used tokens
+// are kept until then so that their reuse is still detected.
+func (r *oauthRepository) DeleteExpiredRefreshTokens(ctx context.Context) (int64, error) {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM oauth_refresh_tokens WHERE expires_at < now()`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -3,11 +3,38 @@ package repository
 import "database/sql"
 
 type Repository struct {
-	Users UserRepository
+	Users       UserRepository
+	Jobs        JobRepository
+	Audit       AuditRepository
+	Imports     ImportRepository
+	Idempotency IdempotencyRepository
+	APIKeys     APIKeyRepository
+	OAuth       OAuthRepository
+	Credentials CredentialRepository
+	UserTokens  UserTokenRepository
+	MFA         MFARepository
+
+	db dbtx
 }
 
 func NewRepository(db *sql.DB) *Repository {
+	return newRepository(db)
+}
+
+// newRepository binds all repositories to db, which is either the pool or a
+// transaction started by WithTx.
+func newRepository(db dbtx) *Repository {
 	return &Repository{
-		Users: NewUserRepository(db),
+		Users:       &userRepository{db: db},
+		Jobs:        &jobRepository{db: db},
+		Audit:       &auditRepository{db: db},
+		Imports:     &importRepository{db: db},
+		Idempotency: &idempotencyRepository{db: db},
+		APIKeys:     &apiKeyRepository{db: db},
+		OAuth:       &oauthRepository{db: db},
+		Credentials: &credentialRepository{db: db},
+		UserTokens:  &userTokenRepository{db: db},
+		MFA:         &mfaRepository{db: db},
+		db:          db,
 	}
 }
@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// dbtx is implemented by both *sql.DB and *sql.Tx, so repositories can run
+// against the pool or inside a transaction.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+const (
+	defaultTxAttempts = 3
+	txRetryBaseDelay  = 20 * time.Millisecond
+	txRetryMaxDelay   = time.Second
+)
+
+type txConfig struct {
+	options     sql.TxOptions
+	maxAttempts int
+}
+
+// TxOption configures a WithTx call.
+type TxOption func(*txConfig)
+
+// WithIsolation sets the isolation level of the transaction. The default is
+// the database default, READ COMMITTED for Postgres.
+func WithIsolation(level sql.IsolationLevel) TxOption {
+	return func(c *txConfig) { c.options.Isolation = level }
+}
+
+// ReadOnly starts a read-only transaction.
+func ReadOnly() TxOption {
+	return func(c *txConfig) { c.options.ReadOnly = true }
+}
+
+// WithMaxAttempts sets how often the transaction is run before a
+// serialization failure or deadlock is returned to the caller.
+func WithMaxAttempts(n int) TxOption {
+	return func(c *txConfig) { c.maxAttempts = max(n, 1) }
+}
+
+// WithTx runs fn in a transaction with repositories bound to it. The
+// transaction commits if fn returns nil and rolls back otherwise.
+//
+// Serialization failures and deadlocks (SQLSTATE 40001 and 40P01) roll the
+// transaction back and run fn again after a jittered exponential backoff,
+// so fn must not have side effects outside the database.
+//
+// Calling WithTx on repositories that are already bound to a transaction
+// runs fn in that transaction; options and retries are then up to the
+// outermost call.
+func (r *Repository) WithTx(ctx context.Context, fn func(repos *Repository) error, opts ...TxOption) error {
+	if _, ok := r.db.(*sql.Tx); ok {
+		return fn(r)
+	}
+
+	cfg := txConfig{maxAttempts: defaultTxAttempts}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := runInTx(ctx, r.db, &cfg.options, func(tx dbtx) error {
+			return fn(newRepository(tx))
+		})
+		if err == nil || !isRetryable(err) || attempt >= cfg.maxAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(retryDelay(attempt)):
+		}
+	}
+}
+
+// runInTx runs fn in a new transaction on db, or directly on db if it is
+// already a transaction so that nested units of work join the outer one.
+func runInTx(ctx context.Context, db dbtx, opts *sql.TxOptions, fn func(tx dbtx) error) error {
+	pool, ok := db.(*sql.DB)
+	if !ok {
+		return fn(db)
+	}
+
+	tx, err := pool.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// isRetryable reports whether err is a serialization failure or a deadlock,
+// after which the whole transaction may succeed when run again.
+func isRetryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == "40001" || pqErr.Code == "40P01"
+}
+
+// retryDelay returns the backoff before the given retry: an exponentially
+// growing delay capped at txRetryMaxDelay, with full jitter so that
+// conflicting transactions do not retry in lockstep.
+func retryDelay(attempt int) time.Duration {
+	d := txRetryMaxDelay
+	if attempt < 16 {
+		d = min(txRetryBaseDelay<<(attempt-1), txRetryMaxDelay)
+	}
+	// #nosec G404 -- jitter does not need a cryptographic source
+	return time.Duration(rand.Int64N(int64(d)) + 1)
+}
@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&pq.Error{Code: "40001"}, true},
+		{&pq.Error{Code: "40P01"}, true},
+		{fmt.Errorf("commit: %w", &pq.Error{Code: "40001"}), true},
+		{&pq.Error{Code: "23505"}, false},
+		{errors.New("connection reset"), false},
+		{nil, false},
+	}
+
+	for _, tt := range tests {
+		if got := isRetryable(tt.err); got != tt.want {
+			t.Errorf("isRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempt := 1; attempt <= 40; attempt++ {
+		ceiling := min(txRetryBaseDelay<<min(attempt-1, 15), txRetryMaxDelay)
+		for range 20 {
+			if d := retryDelay(attempt); d <= 0 || d > ceiling {
+				t.Fatalf("retryDelay(%d) = %v, want within (0, %v]", attempt, d, ceiling)
+			}
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package repository
+
+import (
+	"cruder/internal/model"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const userColumns = `id, uuid, username, email, full_name, created_at, version, deleted_at, email_verified_at`
+
+// liveUser restricts a query to users that have not been soft-deleted.
+const liveUser = `deleted_at IS NULL`
+
+// userFields is the allow-list of model.User fields that can be used in
+// filter and sort expressions, keyed by their JSON name.
+var userFields = map[string]field{
+	"id":         {expr: "id", kind: kindInt, sortable: true},
+	"uuid":       {expr: "uuid", kind: kindUUID},
+	"username":   {expr: "username", kind: kindText, sortable: true},
+	"email":      {expr: "email", kind: kindText, sortable: true},
+	"full_name":  {expr: "COALESCE(full_name, '')", kind: kindText, sortable: true},
+	"created_at": {expr: "created_at", kind: kindTime, sortable: true},
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanUser scans the userColumns of a row, followed by any extra columns.
+// A NULL full_name is returned as an empty string.
+func scanUser(row rowScanner, extra ...any) (*model.User, error) {
+	var (
+		u               model.User
+		fullName        sql.NullString
+		deletedAt       sql.NullTime
+		emailVerifiedAt sql.NullTime
+	)
+	dest := append([]any{&u.ID, &u.UUID, &u.Username, &u.Email, &fullName, &u.CreatedAt, &u.Version, &deletedAt,
+		&emailVerifiedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	u.FullName = fullName.String
+	if deletedAt.Valid {
+		u.DeletedAt = &deletedAt.Time
+	}
+	if emailVerifiedAt.Valid {
+		u.EmailVerifiedAt = &emailVerifiedAt.Time
+	}
+	return &u, nil
+}
+
+// userFieldValue returns the cursor representation of a sortable field.
+func userFieldValue(u *model.User, name string) string {
+	switch name {
+	case "id":
+		return strconv.Itoa(u.ID)
+	case "username":
+		return u.Username
+	case "email":
+		return u.Email
+	case "full_name":
+		return u.FullName
+	case "created_at":
+		return u.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return ""
+}
+
+// userListQuery is the WHERE and ORDER BY part of a list query.
+// filter holds every condition except the keyset one and filterArgs is the
+// number of leading args it uses, so it can be reused for the total count.
+type userListQuery struct {
+	keys       []sortKey
+	sort       string
+	filter     string
+	where      string
+	orderBy    string
+	args       *queryArgs
+	filterArgs int
+}
+
+func buildUserListQuery(params model.ListParams) (*userListQuery, error) {
+	q := &userListQuery{args: &queryArgs{}}
+
+	if strings.TrimSpace(params.Sort) != "" {
+		keys, err := parseSort(params.Sort, userFields)
+		if err != nil {
+			return nil, err
+		}
+		q.keys = keys
+	}
+	q.keys = withIDTiebreak(q.keys)
+	q.sort = sortSignature(q.keys)
+
+	var conds []string
+	if !params.IncludeDeleted {
+		conds = append(conds, liveUser)
+	}
+	if strings.TrimSpace(params.Filter) != "" {
+		cond, err := parseFilter(params.Filter, userFields, q.args)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, cond)
+	}
+	q.filter = strings.Join(conds, " AND ")
+	q.filterArgs = len(q.args.values)
+
+	if params.After != "" {
+		c, err := decodeCursor(params.After)
+		if err != nil {
+			return nil, err
+		}
+		cond, err := keysetCondition(q.keys, q.sort, c, q.args)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, cond)
+	}
+
+	if len(conds) > 0 {
+		q.where = " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	order := make([]string, len(q.keys))
+	for i, k := range q.keys {
+		order[i] = k.field.expr
+		if k.desc {
+			order[i] += " DESC"
+		}
+	}
+	q.orderBy = " ORDER BY " + strings.Join(order, ", ")
+
+	return q, nil
+}
+
+// nextCursor builds the cursor that continues after the given row.
+func (q *userListQuery) nextCursor(last *model.User) string {
+	values := make([]string, len(q.keys))
+	for i, k := range q.keys {
+		values[i] = userFieldValue(last, k.name)
+	}
+	return encodeCursor(cursor{Sort: q.sort, Values: values})
+}
+
+// withIDTiebreak appends id as the final sort key so the ordering is total.
+func withIDTiebreak(keys []sortKey) []sortKey {
+	for _, k := range keys {
+		if k.name == "id" {
+			return keys
+		}
+	}
+	return append(keys, sortKey{name: "id", field: userFields["id"]})
+}
+
+func sortSignature(keys []sortKey) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k.name
+		if k.desc {
+			parts[i] = "-" + k.name
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// keysetCondition expands (k1, k2, ...) > (v1, v2, ...) honoring the
+// direction of every key:
+//
+//	k1 > v1 OR (k1 = v1 AND k2 > v2) OR ...
+func keysetCondition(keys []sortKey, sort string, c cursor, args *queryArgs) (string, error) {
+	if c.Sort != sort || len(c.Values) != len(keys) {
+		return "", ErrInvalidCursor
+	}
+
+	placeholders := make([]string, len(keys))
+	for i, k := range keys {
+		v, err := k.field.parseValue(c.Values[i])
+		if err != nil {
+			return "", ErrInvalidCursor
+		}
+		placeholders[i] = args.add(v)
+	}
+
+	terms := make([]string, len(keys))
+	for i, k := range keys {
+		op := " > "
+		if k.desc {
+			op = " < "
+		}
+		parts := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			parts = append(parts, keys[j].field.expr+" = "+placeholders[j])
+		}
+		parts = append(parts, k.field.expr+op+placeholders[i])
+		terms[i] = "(" + strings.Join(parts, " AND ") + ")"
+	}
+	return "(" + strings.Join(terms, " OR ") + ")", nil
+}
@@ -5,142 +5,711 @@ import (
 	"cruder/internal/model"
 	"database/sql"
 	"errors"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/lib/pq"
 )
 
-var ErrUniqueConstraint = errors.New("username or email already exists")
+var (
+	ErrUniqueConstraint = errors.New("username or email already exists")
+	ErrVersionMismatch  = errors.New("user version does not match")
+	ErrNotDeleted       = errors.New("user is not deleted")
+	ErrEmailChanged     = errors.New("user email has changed")
+)
+
+// UniqueViolationError reports which column violated a unique constraint.
+// It matches ErrUniqueConstraint with errors.Is.
+type UniqueViolationError struct {
+	Field string
+}
+
+func (e *UniqueViolationError) Error() string {
+	if e.Field == "" {
+		return ErrUniqueConstraint.Error()
+	}
+	return e.Field + " already exists"
+}
+
+func (e *UniqueViolationError) Is(target error) bool {
+	return target == ErrUniqueConstraint
+}
 
 type UserRepository interface {
-	GetAll() ([]model.User, error)
-	GetByUsername(username string) (*model.User, error)
-	GetByID(id int64) (*model.User, error)
-	GetByUUID(uuid string) (*model.User, error)
-	Create(user *model.User) (*model.User, error)
-	Update(uuid string, user *model.User) (*model.User, error)
-	Delete(uuid string) error
+	GetAll(ctx context.Context, params model.ListParams) (*model.UserList, error)
+	Export(ctx context.Context, params model.ListParams, fn func(*model.User) error) error
+	Search(ctx context.Context, query string, limit int, includeDeleted bool) ([]model.UserSearchResult, error)
+	GetByUsername(ctx context.Context, username string, includeDeleted bool) (*model.User, error)
+	GetByID(ctx context.Context, id int64, includeDeleted bool) (*model.User, error)
+	GetByUUID(ctx context.Context, uuid string, includeDeleted bool) (*model.User, error)
+	GetByEmail(ctx context.Context, email string, includeDeleted bool) (*model.User, error)
+	Create(ctx context.Context, user *model.User) (*model.User, error)
+	CreateMany(ctx context.Context, users []*model.User) ([]CreateResult, error)
+	Update(ctx context.Context, uuid string, user *model.User, expectedVersion int) (*model.User, error)
+	UpsertByExternalID(ctx context.Context, source, externalID string, user *model.User, expectedVersion int) (*model.User, bool, error)
+	Patch(ctx context.Context, uuid string, patch *model.UserPatch, expectedVersion int) (*model.User, error)
+	PatchWith(ctx context.Context, uuid string, expectedVersion int, fn func(current *model.User) (*model.UserPatch, error)) (*model.User, error)
+	Delete(ctx context.Context, uuid string, expectedVersion int) error
+	Restore(ctx context.Context, uuid string, expectedVersion int) (*model.User, error)
+	VerifyEmail(ctx context.Context, uuid, email string) (*model.User, error)
+	Purge(ctx context.Context, uuid string, expectedVersion int) error
+	PurgeDeleted(ctx context.Context, retention time.Duration, batchSize int) (int64, error)
 }
 
+// Read methods skip soft-deleted users unless includeDeleted is set, and
+// updates only apply to live users.
+// Mutations take an expectedVersion; when it is not zero the row is only
+// changed if its version matches, otherwise ErrVersionMismatch is returned.
 type userRepository struct {
-	db *sql.DB
+	db dbtx
 }
 
 func NewUserRepository(db *sql.DB) UserRepository {
 	return &userRepository{db: db}
 }
 
-func (r *userRepository) GetAll() ([]model.User, error) {
-	rows, err := r.db.QueryContext(context.Background(), `SELECT id, uuid, username, email, full_name FROM users`)
+func (r *userRepository) GetAll(ctx context.Context, params model.ListParams) (*model.UserList, error) {
+	q, err := buildUserListQuery(params)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fetch one extra row to find out whether there is a next page.
+	limit := q.args.add(params.Limit + 1)
+	offset := q.args.add(params.Offset)
+	// #nosec G202 -- where and orderBy only contain allow-listed columns and placeholders
+	query := `SELECT ` + userColumns + ` FROM users` + q.where + q.orderBy + ` LIMIT ` + limit + ` OFFSET ` + offset
+	rows, err := r.db.QueryContext(ctx, query, q.args.values...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var users []model.User
+	users := make([]model.User, 0, params.Limit)
 	for rows.Next() {
-		var u model.User
-		if err := rows.Scan(&u.ID, &u.UUID, &u.Username, &u.Email, &u.FullName); err != nil {
+		u, err := scanUser(rows)
+		if err != nil {
 			return nil, err
 		}
-		users = append(users, u)
+		users = append(users, *u)
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
-	return users, nil
+	list := &model.UserList{Items: users}
+	if len(users) > params.Limit {
+		list.Items = users[:params.Limit]
+		list.NextCursor = q.nextCursor(&list.Items[params.Limit-1])
+	}
+
+	if params.WithTotal {
+		countQuery := `SELECT count(*) FROM users`
+		if q.filter != "" {
+			// #nosec G202 -- filter only contains allow-listed columns and placeholders
+			countQuery += ` WHERE ` + q.filter
+		}
+		var total int64
+		if err := r.db.QueryRowContext(ctx, countQuery, q.args.values[:q.filterArgs]...).Scan(&total); err != nil {
+			return nil, err
+		}
+		list.Total = &total
+	}
+
+	return list, nil
 }
 
-func (r *userRepository) GetByUsername(username string) (*model.User, error) {
-	var u model.User
-	if err := r.db.QueryRowContext(context.Background(), `SELECT id, uuid, username, email, full_name FROM users WHERE username = $1`, username).
-		Scan(&u.ID, &u.UUID, &u.Username, &u.Email, &u.FullName); err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
+// Export calls fn for every user matching the filter of params, in its sort
+// order. Limit and Offset are ignored. Rows are read from the connection as
+// fn consumes them, so memory use does not grow with the result; an error
+// from fn stops the export and is returned.
+func (r *userRepository) Export(ctx context.Context, params model.ListParams, fn func(*model.User) error) error {
+	q, err := buildUserListQuery(params)
+	if err != nil {
+		return err
+	}
+
+	// #nosec G202 -- where and orderBy only contain allow-listed columns and placeholders
+	query := `SELECT ` + userColumns + ` FROM users` + q.where + q.orderBy
+	rows, err := r.db.QueryContext(ctx, query, q.args.values...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// searchSimilarityThreshold is the minimum trigram word similarity for a
+// fuzzy match; it is low enough to tolerate transposed letters ("jhon").
+const searchSimilarityThreshold = "0.3"
+
+func (r *userRepository) Search(ctx context.Context, query string, limit int, includeDeleted bool) ([]model.UserSearchResult, error) {
+	var results []model.UserSearchResult
+	err := runInTx(ctx, r.db, &sql.TxOptions{ReadOnly: true}, func(tx dbtx) error {
+		// The <% operator is index backed but only honors the session threshold.
+		if _, err := tx.ExecContext(ctx,
+			`SELECT set_config('pg_trgm.word_similarity_threshold', $1, true)`, searchSimilarityThreshold); err != nil {
+			return err
+		}
+
+		rows, err := tx.QueryContext(
+			ctx,
+			`SELECT `+userColumns+`,
+				ts_rank(search_vector, websearch_to_tsquery('simple', $1)) + word_similarity(lower($1), search_text) AS score
+			FROM users
+			WHERE (search_vector @@ websearch_to_tsquery('simple', $1) OR lower($1) <% search_text)
+				AND ($3 OR deleted_at IS NULL)
+			ORDER BY score DESC, id
+			LIMIT $2`,
+			query, limit, includeDeleted,
+		)
+		if err != nil {
+			return err
 		}
+		defer rows.Close()
+
+		results = make([]model.UserSearchResult, 0, limit)
+		for rows.Next() {
+			var score float64
+			u, err := scanUser(rows, &score)
+			if err != nil {
+				return err
+			}
+			results = append(results, model.UserSearchResult{User: *u, Score: score})
+		}
+		return rows.Err()
+	})
+	if err != nil {
 		return nil, err
 	}
-	return &u, nil
+	return results, nil
+}
+
+func (r *userRepository) GetByUsername(ctx context.Context, username string, includeDeleted bool) (*model.User, error) {
+	return r.getBy(ctx, "username", username, includeDeleted)
 }
 
-func (r *userRepository) GetByID(id int64) (*model.User, error) {
-	var u model.User
-	if err := r.db.QueryRowContext(context.Background(), `SELECT id, uuid, username, email, full_name FROM users WHERE id = $1`, id).
-		Scan(&u.ID, &u.UUID, &u.Username, &u.Email, &u.FullName); err != nil {
+func (r *userRepository) GetByID(ctx context.Context, id int64, includeDeleted bool) (*model.User, error) {
+	return r.getBy(ctx, "id", id, includeDeleted)
+}
+
+func (r *userRepository) GetByUUID(ctx context.Context, uuid string, includeDeleted bool) (*model.User, error) {
+	return r.getBy(ctx, "uuid", uuid, includeDeleted)
+}
+
+func (r *userRepository) GetByEmail(ctx context.Context, email string, includeDeleted bool) (*model.User, error) {
+	return r.getBy(ctx, "email", email, includeDeleted)
+}
+
+// getBy returns the user whose column equals value. Usernames are only unique
+// among live users, so a live match is preferred over deleted ones.
+func (r *userRepository) getBy(ctx context.Context, column string, value any, includeDeleted bool) (*model.User, error) {
+	// #nosec G202 -- column is one of the fixed names passed by the getters
+	query := `SELECT ` + userColumns + ` FROM users WHERE ` + column + ` = $1`
+	if !includeDeleted {
+		query += ` AND ` + liveUser
+	}
+	query += ` ORDER BY deleted_at DESC NULLS FIRST LIMIT 1`
+
+	u, err := scanUser(r.db.QueryRowContext(ctx, query, value))
+	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		return nil, err
 	}
-	return &u, nil
+	return u, nil
 }
 
-func (r *userRepository) GetByUUID(uuid string) (*model.User, error) {
-	var u model.User
-	if err := r.db.QueryRowContext(context.Background(), `SELECT id, uuid, username, email, full_name FROM users WHERE uuid = $1`, uuid).
-		Scan(&u.ID, &u.UUID, &u.Username, &u.Email, &u.FullName); err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
+func (r *userRepository) Create(ctx context.Context, user *model.User) (*model.User, error) {
+	var created *model.User
+	err := runInTx(ctx, r.db, nil, func(tx dbtx) error {
+		u, err := scanUser(tx.QueryRowContext(
+			ctx,
+			`INSERT INTO users (username, email, full_name) VALUES ($1, $2, $3) RETURNING `+userColumns,
+			user.Username, user.Email, user.FullName,
+		))
+		if err != nil {
+			if uniqueErr := asUniqueViolation(err); uniqueErr != nil {
+				return uniqueErr
+			}
+			return err
 		}
+		created = u
+		return recordAudit(ctx, tx, model.AuditActionCreate, u.UUID, nil, u)
+	})
+	if err != nil {
 		return nil, err
 	}
-	return &u, nil
+	return created, nil
+}
+
+// createChunkSize keeps multi-row inserts well below the limit of 65535
+// bind parameters per statement.
+const createChunkSize = 1000
+
+// CreateResult is the outcome of one user passed to CreateMany: the created
+// user, or a *UniqueViolationError if its username or email is taken.
+type CreateResult struct {
+	User *model.User
+	Err  error
 }
 
-func (r *userRepository) Create(user *model.User) (*model.User, error) {
-	var u model.User
-	err := r.db.QueryRowContext(
-		context.Background(),
-		`INSERT INTO users (username, email, full_name) VALUES ($1, $2, $3) RETURNING id, uuid, username, email, full_name`,
-		user.Username, user.Email, user.FullName,
-	).Scan(&u.ID, &u.UUID, &u.Username, &u.Email, &u.FullName)
+// CreateMany inserts users with multi-row INSERT statements of up to
+// createChunkSize rows, each in its own transaction unless r is bound to
+// one. Rows that collide with a live user, or with an earlier row of the
+// same call, are skipped and reported in their result instead of failing
+// the others. On error, the results of the chunks written so far are
+// returned with it.
+func (r *userRepository) CreateMany(ctx context.Context, users []*model.User) ([]CreateResult, error) {
+	results := make([]CreateResult, 0, len(users))
+	for start := 0; start < len(users); start += createChunkSize {
+		chunk := users[start:min(start+createChunkSize, len(users))]
+		var chunkResults []CreateResult
+		err := runInTx(ctx, r.db, nil, func(tx dbtx) error {
+			var err error
+			chunkResults, err = createChunk(ctx, tx, chunk)
+			return err
+		})
+		if err != nil {
+			return results, err
+		}
+		results = append(results, chunkResults...)
+	}
+	return results, nil
+}
+
+func createChunk(ctx context.Context, tx dbtx, users []*model.User) ([]CreateResult, error) {
+	args := &queryArgs{}
+	values := make([]string, len(users))
+	for i, u := range users {
+		values[i] = "(" + args.add(u.Username) + ", " + args.add(u.Email) + ", " + args.add(u.FullName) + ")"
+	}
+
+	// #nosec G202 -- values only contains placeholders
+	rows, err := tx.QueryContext(ctx,
+		`INSERT INTO users (username, email, full_name) VALUES `+strings.Join(values, ", ")+
+			` ON CONFLICT DO NOTHING RETURNING `+userColumns,
+		args.values...)
 	if err != nil {
-		if isUniqueConstraintError(err) {
-			return nil, ErrUniqueConstraint
+		return nil, err
+	}
+	created := make(map[[2]string]*model.User, len(users))
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			_ = rows.Close()
+			return nil, err
 		}
+		created[[2]string{u.Username, u.Email}] = u
+	}
+	if err := rows.Close(); err != nil {
 		return nil, err
 	}
-	return &u, nil
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Only the first of several rows with the same username and email can
+	// have been inserted; RETURNING order is not guaranteed, so rows are
+	// matched by value.
+	results := make([]CreateResult, len(users))
+	records := make([]auditRecord, 0, len(created))
+	var skipped []int
+	for i, u := range users {
+		key := [2]string{u.Username, u.Email}
+		if c, ok := created[key]; ok {
+			results[i].User = c
+			records = append(records, auditRecord{action: model.AuditActionCreate, uuid: c.UUID, after: c})
+			delete(created, key)
+			continue
+		}
+		skipped = append(skipped, i)
+	}
+
+	if len(skipped) > 0 {
+		if err := explainSkipped(ctx, tx, users, skipped, results); err != nil {
+			return nil, err
+		}
+	}
+	return results, recordAudits(ctx, tx, records)
 }
 
-func (r *userRepository) Update(uuid string, user *model.User) (*model.User, error) {
-	var u model.User
-	err := r.db.QueryRowContext(
-		context.Background(),
-		`UPDATE users SET username = $1, email = $2, full_name = $3 WHERE uuid = $4 RETURNING id, uuid, username, email, full_name`,
-		user.Username, user.Email, user.FullName, uuid,
-	).Scan(&u.ID, &u.UUID, &u.Username, &u.Email, &u.FullName)
+// explainSkipped sets a UniqueViolationError naming the conflicting column
+// on the results of users that were not inserted.
+func explainSkipped(ctx context.Context, tx dbtx, users []*model.User, skipped []int, results []CreateResult) error {
+	usernames := make([]string, len(skipped))
+	for i, idx := range skipped {
+		usernames[i] = users[idx].Username
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT username FROM users WHERE `+liveUser+` AND username = ANY($1)`, pq.Array(usernames))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	taken := make(map[string]bool)
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return err
+		}
+		taken[username] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, idx := range skipped {
+		field := "email"
+		if taken[users[idx].Username] {
+			field = "username"
+		}
+		results[idx].Err = &UniqueViolationError{Field: field}
+	}
+	return nil
+}
+
+func (r *userRepository) Update(ctx context.Context, uuid string, user *model.User, expectedVersion int) (*model.User, error) {
+	patch := &model.UserPatch{
+		Username: model.PatchString{Set: true, Value: user.Username},
+		Email:    model.PatchString{Set: true, Value: user.Email},
+		FullName: model.PatchString{Set: true, Value: user.FullName},
+	}
+	return r.Patch(ctx, uuid, patch, expectedVersion)
+}
+
+// UpsertByExternalID replaces the live user linked to externalID of source,
+// or creates a user and links it if there is none. It reports whether the
+// user was created. A link to a deleted user is moved to the new user.
+// expectedVersion only matches an existing user; a concurrent upsert that
+// linked the same id first yields a UniqueViolationError on external_id.
+func (r *userRepository) UpsertByExternalID(ctx context.Context, source, externalID string, user *model.User, expectedVersion int) (*model.User, bool, error) {
+	var upserted *model.User
+	var created bool
+	err := runInTx(ctx, r.db, nil, func(tx dbtx) error {
+		txRepo := &userRepository{db: tx}
+
+		var userID int64
+		err := tx.QueryRowContext(ctx,
+			`SELECT user_id FROM user_external_ids WHERE source = $1 AND external_id = $2 FOR UPDATE`,
+			source, externalID).Scan(&userID)
+		linked := err == nil
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+
+		if linked {
+			current, err := txRepo.GetByID(ctx, userID, false)
+			if err != nil {
+				return err
+			}
+			if current != nil {
+				upserted, err = txRepo.Update(ctx, current.UUID, user, expectedVersion)
+				return err
+			}
+		}
+		if expectedVersion != 0 {
+			return ErrVersionMismatch
+		}
+
+		if upserted, err = txRepo.Create(ctx, user); err != nil {
+			return err
+		}
+		created = true
+
+		result, err := tx.ExecContext(ctx,
+			`INSERT INTO user_external_ids (source, external_id, user_id) VALUES ($1, $2, $3)
+			ON CONFLICT (source, external_id) DO UPDATE SET user_id = EXCLUDED.user_id, created_at = CURRENT_TIMESTAMP
+			WHERE $4`,
+			source, externalID, upserted.ID, linked)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return &UniqueViolationError{Field: "external_id"}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return upserted, created, nil
+}
+
+var uniqueKeyPattern = regexp.MustCompile(`^Key \(([a-z_]+)\)=`)
+
+// asUniqueViolation returns a UniqueViolationError if err is a unique_violation,
+// naming the column from the error detail ("Key (email)=(...) already exists.").
+func asUniqueViolation(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != "23505" { // unique_violation
+		return nil
+	}
+	var field string
+	if m := uniqueKeyPattern.FindStringSubmatch(pqErr.Detail); m != nil {
+		field = m[1]
+	}
+	return &UniqueViolationError{Field: field}
+}
+
+// Patch updates only the fields present in patch. A patch without changes
+// returns the current row.
+func (r *userRepository) Patch(ctx context.Context, uuid string, patch *model.UserPatch, expectedVersion int) (*model.User, error) {
+	return r.PatchWith(ctx, uuid, expectedVersion, func(*model.User) (*model.UserPatch, error) {
+		return patch, nil
+	})
+}
+
+// PatchWith locks the user row, asks fn for the changes to apply to the
+// current state and applies them, all in one transaction. An error from fn
+// rolls the transaction back and is returned unchanged.
+func (r *userRepository) PatchWith(ctx context.Context, uuid string, expectedVersion int, fn func(current *model.User) (*model.UserPatch, error)) (*model.User, error) {
+	u, err := r.mutateUser(ctx, uuid, expectedVersion, liveUser, func(tx dbtx, current *model.User) (string, *model.User, error) {
+		patch, err := fn(current)
+		if err != nil {
+			return "", nil, err
+		}
+		u, err := patchUser(ctx, tx, uuid, patch, expectedVersion)
+		return model.AuditActionUpdate, u, err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return u, err
+}
+
+// mutateUser locks the user with the given uuid, restricted by scope (an
+// extra condition such as liveUser, or ""), and checks its version. fn then
+// changes the row in the same transaction and returns the audit action and
+// the new state, nil once the row is gone. The change is recorded in the
+// audit log unless it left the version untouched.
+// It returns sql.ErrNoRows if no user matched.
+func (r *userRepository) mutateUser(ctx context.Context, uuid string, expectedVersion int, scope string, fn func(tx dbtx, current *model.User) (string, *model.User, error)) (*model.User, error) {
+	var updated *model.User
+	err := runInTx(ctx, r.db, nil, func(tx dbtx) error {
+		query := `SELECT ` + userColumns + ` FROM users WHERE uuid = $1`
+		if scope != "" {
+			query += ` AND ` + scope
+		}
+		// #nosec G202 -- scope is one of the fixed conditions passed by the callers
+		current, err := scanUser(tx.QueryRowContext(ctx, query+` FOR UPDATE`, uuid))
+		if err != nil {
+			return err
+		}
+		if expectedVersion != 0 && current.Version != expectedVersion {
+			return ErrVersionMismatch
+		}
+
+		action, u, err := fn(tx, current)
+		if err != nil {
+			return err
+		}
+		updated = u
+		if u != nil && u.Version == current.Version {
+			return nil
+		}
+		return recordAudit(ctx, tx, action, uuid, current, u)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// patchUser writes the fields set in patch and bumps the version.
+func patchUser(ctx context.Context, q dbtx, uuid string, patch *model.UserPatch, expectedVersion int) (*model.User, error) {
+	args := &queryArgs{}
+	var sets []string
+	if patch.Username.Set {
+		sets = append(sets, "username = "+args.add(patch.Username.Value))
+	}
+	if patch.Email.Set {
+		sets = append(sets, "email = "+args.add(patch.Email.Value))
+	}
+	if patch.FullName.Set {
+		if patch.FullName.Null {
+			sets = append(sets, "full_name = NULL")
+		} else {
+			sets = append(sets, "full_name = "+args.add(patch.FullName.Value))
+		}
+	}
+
+	where := ` WHERE uuid = ` + args.add(uuid) + ` AND ` + liveUser
+	if expectedVersion != 0 {
+		where += ` AND version = ` + args.add(expectedVersion)
+	}
+
+	var query string
+	if len(sets) == 0 {
+		query = `SELECT ` + userColumns + ` FROM users` + where
+	} else {
+		sets = append(sets, "version = version + 1")
+		// #nosec G202 -- sets only contains fixed column names and placeholders
+		query = `UPDATE users SET ` + strings.Join(sets, ", ") + where + ` RETURNING ` + userColumns
+	}
+
+	u, err := scanUser(q.QueryRowContext(ctx, query, args.values...))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, nil
+			return nil, versionMismatchOrNotFound(ctx, q, uuid, expectedVersion, liveUser)
 		}
-		if isUniqueConstraintError(err) {
-			return nil, ErrUniqueConstraint
+		if uniqueErr := asUniqueViolation(err); uniqueErr != nil {
+			return nil, uniqueErr
 		}
 		return nil, err
 	}
-	return &u, nil
+	return u, nil
 }
 
-func isUniqueConstraintError(err error) bool {
-	if pqErr, ok := err.(*pq.Error); ok {
-		return pqErr.Code == "23505" // unique_violation
+// Delete soft-deletes a live user by setting deleted_at.
+func (r *userRepository) Delete(ctx context.Context, uuid string, expectedVersion int) error {
+	_, err := r.mutateUser(ctx, uuid, expectedVersion, liveUser, func(tx dbtx, _ *model.User) (string, *model.User, error) {
+		u, err := scanUser(tx.QueryRowContext(ctx,
+			`UPDATE users SET deleted_at = now(), version = version + 1 WHERE uuid = $1 RETURNING `+userColumns, uuid))
+		return model.AuditActionDelete, u, err
+	})
+	return err
+}
+
+// Purge permanently removes a user, whether it is live or soft-deleted.
+func (r *userRepository) Purge(ctx context.Context, uuid string, expectedVersion int) error {
+	_, err := r.mutateUser(ctx, uuid, expectedVersion, "", func(tx dbtx, _ *model.User) (string, *model.User, error) {
+		_, err := tx.ExecContext(ctx, `DELETE FROM users WHERE uuid = $1`, uuid)
+		return model.AuditActionPurge, nil, err
+	})
+	return err
+}
+
+// PurgeDeleted permanently removes users that were soft-deleted more than
+// retention ago. Rows are deleted in batches of batchSize so that a large
+// backlog does not hold locks for long; it returns the total removed.
+func (r *userRepository) PurgeDeleted(ctx context.Context, retention time.Duration, batchSize int) (int64, error) {
+	var total int64
+	for {
+		n, err := r.purgeDeletedBatch(ctx, retention, batchSize)
+		total += n
+		if err != nil || n < int64(batchSize) {
+			return total, err
+		}
 	}
-	return false
 }
 
-func (r *userRepository) Delete(uuid string) error {
-	result, err := r.db.ExecContext(context.Background(), `DELETE FROM users WHERE uuid = $1`, uuid)
+func (r *userRepository) purgeDeletedBatch(ctx context.Context, retention time.Duration, batchSize int) (int64, error) {
+	var purged []*model.User
+	err := runInTx(ctx, r.db, nil, func(tx dbtx) error {
+		purged = nil
+		rows, err := tx.QueryContext(ctx,
+			`DELETE FROM users WHERE id IN (
+				SELECT id FROM users WHERE deleted_at < now() - make_interval(secs => $1) LIMIT $2 FOR UPDATE SKIP LOCKED)
+			RETURNING `+userColumns,
+			retention.Seconds(), batchSize)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			u, err := scanUser(rows)
+			if err != nil {
+				_ = rows.Close()
+				return err
+			}
+			purged = append(purged, u)
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, u := range purged {
+			if err := recordAudit(ctx, tx, model.AuditActionPurge, u.UUID, u, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return err
+		return 0, err
 	}
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
+	return int64(len(purged)), nil
+}
+
+// Restore brings back a soft-deleted user. It returns ErrNotDeleted if the
+// user is live and a UniqueViolationError if its username or email has been
+// taken in the meantime.
+func (r *userRepository) Restore(ctx context.Context, uuid string, expectedVersion int) (*model.User, error) {
+	u, err := r.mutateUser(ctx, uuid, expectedVersion, "", func(tx dbtx, current *model.User) (string, *model.User, error) {
+		if current.DeletedAt == nil {
+			return "", nil, ErrNotDeleted
+		}
+		u, err := scanUser(tx.QueryRowContext(ctx,
+			`UPDATE users SET deleted_at = NULL, version = version + 1 WHERE uuid = $1 RETURNING `+userColumns, uuid))
+		if uniqueErr := asUniqueViolation(err); uniqueErr != nil {
+			return "", nil, uniqueErr
+		}
+		return model.AuditActionRestore, u, err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return u, err
+}
+
+// VerifyEmail marks the email of a live user as verified, provided it is
+// still email; otherwise it returns ErrEmailChanged. Verifying a verified
+// email changes nothing. It returns nil if the user does not exist.
+func (r *userRepository) VerifyEmail(ctx context.Context, uuid, email string) (*model.User, error) {
+	u, err := r.mutateUser(ctx, uuid, 0, liveUser, func(tx dbtx, current *model.User) (string, *model.User, error) {
+		if current.Email != email {
+			return "", nil, ErrEmailChanged
+		}
+		if current.EmailVerifiedAt != nil {
+			return "", current, nil
+		}
+		u, err := scanUser(tx.QueryRowContext(ctx,
+			`UPDATE users SET email_verified_at = now(), version = version + 1 WHERE uuid = $1 RETURNING `+userColumns, uuid))
+		return model.AuditActionVerifyEmail, u, err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return u, err
+}
+
+// versionMismatchOrNotFound explains why a versioned statement matched no row:
+// it returns ErrVersionMismatch if the user exists within scope (an extra
+// condition such as liveUser, or ""), and nil if it does not.
+func versionMismatchOrNotFound(ctx context.Context, q dbtx, uuid string, expectedVersion int, scope string) error {
+	if expectedVersion == 0 {
+		return nil
+	}
+	query := `SELECT EXISTS (SELECT 1 FROM users WHERE uuid = $1`
+	if scope != "" {
+		query += ` AND ` + scope
+	}
+	var exists bool
+	if err := q.QueryRowContext(ctx, query+`)`, uuid).Scan(&exists); err != nil {
 		return err
 	}
-	if rowsAffected == 0 {
-		return sql.ErrNoRows
+	if exists {
+		return ErrVersionMismatch
 	}
 	return nil
 }
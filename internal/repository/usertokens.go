@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"cruder/internal/model"
+	"database/sql"
+	"time"
+)
+
+type UserTokenRepository interface {
+	Create(ctx context.Context, token *model.UserToken) error
+	Get(ctx context.Context, hash []byte, forUpdate bool) (*model.UserToken, error)
+	CreatedSince(ctx context.Context, uuid, purpose string, since time.Time) (bool, error)
+	UseAll(ctx context.Context, uuid, purpose string) error
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+type userTokenRepository struct {
+	db dbtx
+}
+
+func NewUserTokenRepository(db *sql.DB) UserTokenRepository {
+	return &userTokenRepository{db: db}
+}
+
+// Create inserts token and fills in its generated id and creation time.
+func (r *userTokenRepository) Create(ctx context.Context, token *model.UserToken) error {
+	return r.db.QueryRowContext(ctx,
+		`INSERT INTO user_tokens (user_uuid, purpose, email, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`,
+		token.UserUUID, token.Purpose, token.Email, token.TokenHash, token.ExpiresAt,
+	).Scan(&token.ID, &token.CreatedAt)
+}
+
+// Get returns the token with the given hash, or nil. With forUpdate the row
+// is locked until the surrounding transaction ends.
+func (r *userTokenRepository) Get(ctx context.Context, hash []byte, forUpdate bool) (*model.UserToken, error) {
+	query := `SELECT id, user_uuid, purpose, email, token_hash, created_at, expires_at, used_at
+		FROM user_tokens WHERE token_hash = $1`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+	var token model.UserToken
+	var usedAt sql.NullTime
+	err := r.db.QueryRowContext(ctx, query, hash).Scan(&token.ID, &token.UserUUID, &token.Purpose, &token.Email,
+		&token.TokenHash, &token.CreatedAt, &token.ExpiresAt, &usedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if usedAt.Valid {
+		token.UsedAt = &usedAt.Time
+	}
+	return &token, nil
+}
+
+// CreatedSince reports whether a token for purpose was created for the user
+// after since.
+func (r *userTokenRepository) CreatedSince(ctx context.Context, uuid, purpose string, since time.Time) (bool, error) {
+	var exists bool
+	err := r.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM user_tokens WHERE user_uuid = $1 AND purpose = $2 AND created_at > $3)`,
+		uuid, purpose, since).Scan(&exists)
+	return exists, err
+}
+
+// UseAll marks every unused token of the user for purpose as used, so that
+// completing a flow invalidates the other links that were sent for it.
+func (r *userTokenRepository) UseAll(ctx context.Context, uuid, purpose string) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE user_tokens SET used_at = now() WHERE user_uuid = $1 AND purpose = $2 AND used_at IS NULL`,
+		uuid, purpose)
+	return err
+}
+
+// DeleteExpired deletes tokens that have expired, used or not.
+func (r *userTokenRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM user_tokens WHERE expires_at < now()`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
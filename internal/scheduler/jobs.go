@@ -0,0 +1,80 @@
+package scheduler
+
+import (
+	"context"
+	"cruder/internal/repository"
+	"cruder/internal/service"
+	"time"
+)
+
+const (
+	PurgeDeletedUsersJob    = "purge_deleted_users"
+	PurgeIdempotencyKeysJob = "purge_idempotency_keys"
+	PurgeAPIKeyEventsJob    = "purge_api_key_events"
+	PurgeRefreshTokensJob   = "purge_refresh_tokens"
+	PurgeUserTokensJob      = "purge_user_tokens"
+	FailStaleImportsJob     = "fail_stale_imports"
+)
+
+// PurgeDeletedUsers returns a job that hard-deletes users that have been
+// soft-deleted for longer than retention.
+func PurgeDeletedUsers(users service.UserService, interval, retention time.Duration) Job {
+	return Job{
+		Name:     PurgeDeletedUsersJob,
+		Interval: interval,
+		Run: func(ctx context.Context) (int64, error) {
+			return users.PurgeDeleted(ctx, retention)
+		},
+	}
+}
+
+// PurgeIdempotencyKeys returns a job that deletes expired idempotency keys.
+func PurgeIdempotencyKeys(keys repository.IdempotencyRepository, interval time.Duration) Job {
+	return Job{
+		Name:     PurgeIdempotencyKeysJob,
+		Interval: interval,
+		Run:      keys.DeleteExpired,
+	}
+}
+
+// PurgeAPIKeyEvents returns a job that deletes API key events older than
+// retention.
+func PurgeAPIKeyEvents(keys service.APIKeyService, interval, retention time.Duration) Job {
+	return Job{
+		Name:     PurgeAPIKeyEventsJob,
+		Interval: interval,
+		Run: func(ctx context.Context) (int64, error) {
+			return keys.PurgeEvents(ctx, retention)
+		},
+	}
+}
+
+// PurgeRefreshTokens returns a job that deletes expired OAuth refresh tokens.
+func PurgeRefreshTokens(tokens repository.OAuthRepository, interval time.Duration) Job {
+	return Job{
+		Name:     PurgeRefreshTokensJob,
+		Interval: interval,
+		Run:      tokens.DeleteExpiredRefreshTokens,
+	}
+}
+
+// PurgeUserTokens returns a job that deletes expired email verification
+// and password reset tokens.
+func PurgeUserTokens(tokens repository.UserTokenRepository, interval time.Duration) Job {
+	return Job{
+		Name:     PurgeUserTokensJob,
+		Interval: interval,
+		Run:      tokens.DeleteExpired,
+	}
+}
+
+// FailStaleImports returns a job that marks background imports left
+// unfinished by a stopped process as failed. It runs at startup like every
+// job, and then catches the imports of other instances.
+func FailStaleImports(imports service.ImportService, interval time.Duration) Job {
+	return Job{
+		Name:     FailStaleImportsJob,
+		Interval: interval,
+		Run:      imports.FailStale,
+	}
+}
@@ -0,0 +1,151 @@
+// Package scheduler runs periodic background jobs. Every run is guarded by a
+// Postgres advisory lock so that only one replica executes a job at a time,
+// and its outcome is recorded in the job_runs table.
+package scheduler
+
+import (
+	"context"
+	"cruder/internal/model"
+	"cruder/internal/repository"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownJob = errors.New("unknown job")
+	ErrJobLocked  = errors.New("job is running on another replica")
+)
+
+// Job is a unit of periodic work. Run returns the number of rows it affected.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) (int64, error)
+}
+
+type Scheduler struct {
+	repo repository.JobRepository
+	jobs []Job
+
+	mu      sync.Mutex
+	nextRun map[string]time.Time
+	running map[string]bool
+}
+
+func New(repo repository.JobRepository, jobs ...Job) *Scheduler {
+	return &Scheduler{
+		repo:    repo,
+		jobs:    jobs,
+		nextRun: make(map[string]time.Time),
+		running: make(map[string]bool),
+	}
+}
+
+// Start runs every job once and then on its interval until ctx is cancelled.
+func (s *Scheduler) Start(ctx context.Context) {
+	for _, job := range s.jobs {
+		go s.loop(ctx, job)
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, job Job) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.mu.Lock()
+		s.nextRun[job.Name] = time.Now().Add(job.Interval)
+		s.mu.Unlock()
+
+		if _, err := s.Run(ctx, job.Name); err != nil && !errors.Is(err, ErrJobLocked) && ctx.Err() == nil {
+			log.Printf("job %s failed: %v", job.Name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Run executes the named job immediately. It returns ErrJobLocked without
+// running the job if another replica holds its lock.
+func (s *Scheduler) Run(ctx context.Context, name string) (*model.JobRun, error) {
+	job, ok := s.job(name)
+	if !ok {
+		return nil, ErrUnknownJob
+	}
+
+	unlock, acquired, err := s.repo.TryLock(ctx, job.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !acquired {
+		return nil, ErrJobLocked
+	}
+	defer unlock()
+
+	s.setRunning(job.Name, true)
+	defer s.setRunning(job.Name, false)
+
+	run := &model.JobRun{Job: job.Name, StartedAt: time.Now(), Status: model.JobRunSucceeded}
+	rows, runErr := job.Run(ctx)
+	run.FinishedAt = time.Now()
+	run.RowsAffected = rows
+	if runErr != nil {
+		run.Status = model.JobRunFailed
+		run.Error = runErr.Error()
+	}
+
+	// Record the outcome even if the run was cut short by ctx.
+	if err := s.repo.RecordRun(context.WithoutCancel(ctx), run); err != nil {
+		return run, errors.Join(runErr, err)
+	}
+	return run, runErr
+}
+
+// Status reports every job together with its most recent recorded run.
+func (s *Scheduler) Status(ctx context.Context) ([]model.JobStatus, error) {
+	lastRuns, err := s.repo.LastRuns(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]model.JobStatus, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		status := model.JobStatus{
+			Name:     job.Name,
+			Interval: job.Interval.String(),
+			Running:  s.running[job.Name],
+		}
+		if run, ok := lastRuns[job.Name]; ok {
+			status.LastRun = &run
+		}
+		if next, ok := s.nextRun[job.Name]; ok {
+			status.NextRunAt = &next
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func (s *Scheduler) job(name string) (Job, bool) {
+	for _, job := range s.jobs {
+		if job.Name == name {
+			return job, true
+		}
+	}
+	return Job{}, false
+}
+
+func (s *Scheduler) setRunning(name string, running bool) {
+	s.mu.Lock()
+	s.running[name] = running
+	s.mu.Unlock()
+}
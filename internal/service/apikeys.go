@@ -0,0 +1,370 @@
+package service
+
+import (
+	"context"
+	"cruder/internal/model"
+	"cruder/internal/repository"
+	"cruder/pkg/validation"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// API keys look like crd_<prefix>_<secret>: the prefix identifies the
+	// key and may be logged, the secret is 32 random bytes.
+	apiKeyTokenPrefix = "crd"
+	apiKeyPrefixBytes = 6
+	apiKeySecretBytes = 32
+	apiKeyNameMaxLen  = 100
+	apiKeyOwnerMaxLen = 255
+
+	// Rejected attempts are recorded at most once per source IP, prefix
+	// and window, for at most apiKeyRejectionMaxTracked pairs at a time.
+	apiKeyRejectionWindow     = time.Minute
+	apiKeyRejectionMaxTracked = 10000
+)
+
+type APIKeyService interface {
+	Create(ctx context.Context, key *model.APIKey) (string, error)
+	Get(ctx context.Context, id string) (*model.APIKey, error)
+	List(ctx context.Context, includeRevoked bool) (*model.APIKeyList, error)
+	Revoke(ctx context.Context, id string) error
+	Rotate(ctx context.Context, id string, gracePeriod time.Duration) (*model.IssuedAPIKey, error)
+	Events(ctx context.Context, id string, limit int, after string) (*model.APIKeyEventList, error)
+	PurgeEvents(ctx context.Context, retention time.Duration) (int64, error)
+	Authenticate(ctx context.Context, token string, client model.APIKeyClient) (*model.APIKey, error)
+}
+
+// Only a SHA-256 hash of each key is stored. Keys carry 256 bits of
+// randomness, so unlike passwords they need no slow, salted hash; the
+// hash is compared in constant time.
+type apiKeyService struct {
+	repos    *repository.Repository
+	repo     repository.APIKeyRepository
+	rejected *rejectionSampler
+}
+
+func NewAPIKeyService(repos *repository.Repository) APIKeyService {
+	return &apiKeyService{
+		repos:    repos,
+		repo:     repos.APIKeys,
+		rejected: newRejectionSampler(apiKeyRejectionWindow, apiKeyRejectionMaxTracked),
+	}
+}
+
+var errAPIKeyNotFound = &NotFoundError{Resource: "API key"}
+
+// Create generates a key with the name, owner, scopes and expiry of key,
+// stores it and returns the secret, which cannot be retrieved later.
+func (s *apiKeyService) Create(ctx context.Context, key *model.APIKey) (string, error) {
+	if err := validateAPIKey(key); err != nil {
+		return "", err
+	}
+	token, err := issueAPIKey(ctx, s.repo, key)
+	if err != nil {
+		return "", translateError(err)
+	}
+	return token, nil
+}
+
+func issueAPIKey(ctx context.Context, repo repository.APIKeyRepository, key *model.APIKey) (string, error) {
+	prefix, token, err := generateAPIKey()
+	if err != nil {
+		return "", err
+	}
+	key.Prefix = prefix
+	key.KeyHash = hashAPIKey(token)
+	if err := repo.Create(ctx, key); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (s *apiKeyService) Get(ctx context.Context, id string) (*model.APIKey, error) {
+	if !isUUID(id) {
+		return nil, errAPIKeyNotFound
+	}
+	key, err := s.repo.Get(ctx, id, false)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if key == nil {
+		return nil, errAPIKeyNotFound
+	}
+	return key, nil
+}
+
+func (s *apiKeyService) List(ctx context.Context, includeRevoked bool) (*model.APIKeyList, error) {
+	keys, err := s.repo.List(ctx, includeRevoked)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &model.APIKeyList{Items: keys}, nil
+}
+
+// Revoke disables a key immediately, including during a rotation's grace
+// period.
+func (s *apiKeyService) Revoke(ctx context.Context, id string) error {
+	if !isUUID(id) {
+		return errAPIKeyNotFound
+	}
+	found, err := s.repo.Revoke(ctx, id)
+	if err != nil {
+		return translateError(err)
+	}
+	if !found {
+		return errAPIKeyNotFound
+	}
+	return nil
+}
+
+// Rotate issues a successor with the same name, owner, scopes and expiry.
+// The old key keeps working for gracePeriod so that clients can switch
+// over; a zero period retires it at once.
+func (s *apiKeyService) Rotate(ctx context.Context, id string, gracePeriod time.Duration) (*model.IssuedAPIKey, error) {
+	if gracePeriod < 0 || gracePeriod > model.MaxRotationGracePeriod {
+		return nil, &ValidationError{Errors: []FieldError{{
+			Field: "grace_period", Message: "must be between 0s and " + model.MaxRotationGracePeriod.String(),
+		}}}
+	}
+	if !isUUID(id) {
+		return nil, errAPIKeyNotFound
+	}
+
+	var issued *model.IssuedAPIKey
+	err := s.repos.WithTx(ctx, func(repos *repository.Repository) error {
+		old, err := repos.APIKeys.Get(ctx, id, true)
+		if err != nil {
+			return err
+		}
+		if old == nil {
+			return errAPIKeyNotFound
+		}
+		now := time.Now()
+		switch {
+		case old.RevokedAt != nil:
+			return &ConflictError{Message: "API key is revoked"}
+		case old.ReplacedBy != nil:
+			return &ConflictError{Message: "API key has already been rotated"}
+		case old.ExpiresAt != nil && !old.ExpiresAt.After(now):
+			return &ConflictError{Message: "API key has expired"}
+		}
+
+		next := &model.APIKey{Name: old.Name, Owner: old.Owner, Scopes: old.Scopes, ExpiresAt: old.ExpiresAt}
+		token, err := issueAPIKey(ctx, repos.APIKeys, next)
+		if err != nil {
+			return err
+		}
+		if err := repos.APIKeys.Replace(ctx, old.ID, next.ID, now.Add(gracePeriod)); err != nil {
+			return err
+		}
+		issued = &model.IssuedAPIKey{APIKey: *next, Key: token}
+		return nil
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return issued, nil
+}
+
+func (s *apiKeyService) Events(ctx context.Context, id string, limit int, after string) (*model.APIKeyEventList, error) {
+	if limit <= 0 {
+		limit = model.DefaultAPIKeyEventLimit
+	}
+	if limit > model.MaxAPIKeyEventLimit {
+		limit = model.MaxAPIKeyEventLimit
+	}
+	if _, err := s.Get(ctx, id); err != nil {
+		return nil, err
+	}
+	events, err := s.repo.ListEvents(ctx, id, limit, after)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return events, nil
+}
+
+// PurgeEvents deletes key events older than retention.
+func (s *apiKeyService) PurgeEvents(ctx context.Context, retention time.Duration) (int64, error) {
+	n, err := s.repo.PurgeEvents(ctx, retention)
+	if err != nil {
+		return n, translateError(err)
+	}
+	return n, nil
+}
+
+// Authenticate returns the key matching token, or nil if there is none or
+// it has expired or been revoked. Uses and rejections are recorded as key
+// events; failing to record them does not fail the request. Rejections come
+// from unauthenticated callers, so repeated ones are sampled.
+func (s *apiKeyService) Authenticate(ctx context.Context, token string, client model.APIKeyClient) (*model.APIKey, error) {
+	prefix, ok := parseAPIKey(token)
+	var key *model.APIKey
+	if ok {
+		var err error
+		if key, err = s.repo.GetByPrefix(ctx, prefix); err != nil {
+			return nil, translateError(err)
+		}
+	}
+
+	now := time.Now()
+	reason := ""
+	switch {
+	case key == nil || subtle.ConstantTimeCompare(hashAPIKey(token), key.KeyHash) != 1:
+		key, reason = nil, model.APIKeyRejectedUnknown
+	case key.RevokedAt != nil:
+		reason = model.APIKeyRejectedRevoked
+	case key.ExpiresAt != nil && !key.ExpiresAt.After(now):
+		reason = model.APIKeyRejectedExpired
+	}
+
+	if reason == "" {
+		if err := s.repo.RecordUse(ctx, key.ID, client); err != nil {
+			log.Printf("api key %s: recording use: %v", key.Prefix, err)
+		}
+		return key, nil
+	}
+
+	if !s.rejected.allow(client.IP, prefix, now) {
+		return nil, nil
+	}
+	event := &model.APIKeyEvent{Prefix: prefix, Reason: reason, SourceIP: client.IP, UserAgent: client.UserAgent}
+	if key != nil {
+		event.KeyID = &key.ID
+	}
+	if err := s.repo.RecordRejected(ctx, event); err != nil {
+		log.Printf("api key %s: recording rejection: %v", prefix, err)
+	}
+	return nil, nil
+}
+
+type rejectionKey struct {
+	ip, prefix string
+}
+
+// rejectionSampler lets one rejected attempt per source IP and prefix and
+// window through to the database. Once it tracks limit pairs, attempts from
+// new pairs are not recorded until old ones expire.
+type rejectionSampler struct {
+	window time.Duration
+	limit  int
+
+	mu     sync.Mutex
+	seen   map[rejectionKey]time.Time
+	pruned time.Time
+}
+
+func newRejectionSampler(window time.Duration, limit int) *rejectionSampler {
+	return &rejectionSampler{window: window, limit: limit, seen: make(map[rejectionKey]time.Time)}
+}
+
+// allow reports whether a rejection from ip with prefix at now should be
+// recorded.
+func (s *rejectionSampler) allow(ip, prefix string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.pruned) >= s.window {
+		for key, at := range s.seen {
+			if now.Sub(at) >= s.window {
+				delete(s.seen, key)
+			}
+		}
+		s.pruned = now
+	}
+	key := rejectionKey{ip: ip, prefix: prefix}
+	if at, ok := s.seen[key]; ok && now.Sub(at) < s.window {
+		return false
+	}
+	if _, ok := s.seen[key]; !ok && len(s.seen) >= s.limit {
+		return false
+	}
+	s.seen[key] = now
+	return true
+}
+
+func validateAPIKey(key *model.APIKey) error {
+	var errs validation.Errors
+	key.Name = strings.TrimSpace(key.Name)
+	switch {
+	case key.Name == "":
+		errs.Add("name", "is required")
+	case utf8.RuneCountInString(key.Name) > apiKeyNameMaxLen:
+		errs.Add("name", "must be at most 100 characters")
+	}
+	key.Owner = strings.TrimSpace(key.Owner)
+	switch {
+	case key.Owner == "":
+		errs.Add("owner", "is required")
+	case utf8.RuneCountInString(key.Owner) > apiKeyOwnerMaxLen:
+		errs.Add("owner", "must be at most 255 characters")
+	}
+	if len(key.Scopes) == 0 {
+		errs.Add("scopes", "is required")
+	}
+	for _, scope := range key.Scopes {
+		if !slices.Contains(model.Scopes, scope) {
+			errs.Add("scopes", "unknown scope "+scope)
+		}
+	}
+	if key.ExpiresAt != nil && !key.ExpiresAt.After(time.Now()) {
+		errs.Add("expires_at", "must be in the future")
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	key.Scopes = slices.Compact(slices.Sorted(slices.Values(key.Scopes)))
+	return nil
+}
+
+// isUUID reports whether id has the textual form of a UUID, so that
+// malformed ids are reported as not found rather than as database errors.
+func isUUID(id string) bool {
+	if len(id) != 36 {
+		return false
+	}
+	for i, c := range id {
+		switch {
+		case i == 8 || i == 13 || i == 18 || i == 23:
+			if c != '-' {
+				return false
+			}
+		case !strings.ContainsRune("0123456789abcdefABCDEF", c):
+			return false
+		}
+	}
+	return true
+}
+
+func generateAPIKey() (prefix, token string, err error) {
+	buf := make([]byte, apiKeyPrefixBytes+apiKeySecretBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	prefix = hex.EncodeToString(buf[:apiKeyPrefixBytes])
+	token = apiKeyTokenPrefix + "_" + prefix + "_" + base64.RawURLEncoding.EncodeToString(buf[apiKeyPrefixBytes:])
+	return prefix, token, nil
+}
+
+// parseAPIKey returns the lookup prefix of token.
+func parseAPIKey(token string) (string, bool) {
+	parts := strings.SplitN(token, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyTokenPrefix || len(parts[1]) != 2*apiKeyPrefixBytes || parts[2] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+func hashAPIKey(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"cruder/internal/model"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	prefix, token, err := generateAPIKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(token, "crd_"+prefix+"_") {
+		t.Errorf("token %q does not embed prefix %q", token, prefix)
+	}
+	if got, ok := parseAPIKey(token); !ok || got != prefix {
+		t.Errorf("parseAPIKey(%q) = %q, %v", token, got, ok)
+	}
+	if _, other, _ := generateAPIKey(); other == token {
+		t.Error("expected distinct keys")
+	}
+}
+
+func TestParseAPIKey(t *testing.T) {
+	tests := []struct {
+		token  string
+		prefix string
+		ok     bool
+	}{
+		{"crd_0123456789ab_c2VjcmV0_with_underscores", "0123456789ab", true},
+		{"crd_0123456789ab_", "", false},
+		{"crd_0123_secret", "", false},
+		{"xyz_0123456789ab_secret", "", false},
+		{"env-secret", "", false},
+	}
+	for _, tt := range tests {
+		prefix, ok := parseAPIKey(tt.token)
+		if prefix != tt.prefix || ok != tt.ok {
+			t.Errorf("parseAPIKey(%q) = %q, %v; want %q, %v", tt.token, prefix, ok, tt.prefix, tt.ok)
+		}
+	}
+}
+
+func TestValidateAPIKey(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+	key := &model.APIKey{Name: " ", Owner: "ops", Scopes: []string{"users:read", "root"}, ExpiresAt: &past}
+
+	err := validateAPIKey(key)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected validation error, got %v", err)
+	}
+	fields := map[string]bool{}
+	for _, fe := range validationErr.Errors {
+		fields[fe.Field] = true
+	}
+	for _, field := range []string{"name", "scopes", "expires_at"} {
+		if !fields[field] {
+			t.Errorf("expected an error for %s, got %v", field, validationErr.Errors)
+		}
+	}
+
+	key = &model.APIKey{Name: "ci", Owner: "ops", Scopes: []string{"users:write", "users:read", "users:write"}}
+	if err := validateAPIKey(key); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(key.Scopes, ",") != "users:read,users:write" {
+		t.Errorf("expected sorted, unique scopes, got %v", key.Scopes)
+	}
+}
+
+func TestIsUUID(t *testing.T) {
+	tests := map[string]bool{
+		"123e4567-e89b-12d3-a456-426614174000": true,
+		"123E4567-E89B-12D3-A456-426614174000": true,
+		"123e4567e89b12d3a456426614174000":     false,
+		"123e4567-e89b-12d3-a456-42661417400g": false,
+		"":                                     false,
+	}
+	for id, want := range tests {
+		if got := isUUID(id); got != want {
+			t.Errorf("isUUID(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
+
+func TestRejectionSampler(t *testing.T) {
+	sampler := newRejectionSampler(time.Minute, 2)
+	now := time.Now()
+
+	if !sampler.allow("192.0.2.1", "0123456789ab", now) {
+		t.Error("expected the first rejection to be recorded")
+	}
+	if sampler.allow("192.0.2.1", "0123456789ab", now.Add(time.Second)) {
+		t.Error("expected a repeated rejection within the window to be dropped")
+	}
+	if !sampler.allow("192.0.2.1", "ba9876543210", now.Add(time.Second)) {
+		t.Error("expected another prefix to be recorded")
+	}
+	if sampler.allow("192.0.2.2", "0123456789ab", now.Add(time.Second)) {
+		t.Error("expected a new pair beyond the limit to be dropped")
+	}
+	if !sampler.allow("192.0.2.1", "0123456789ab", now.Add(time.Minute)) {
+		t.Error("expected a rejection after the window to be recorded")
+	}
+	if !sampler.allow("192.0.2.2", "0123456789ab", now.Add(2*time.Minute)) {
+		t.Error("expected expired pairs to make room")
+	}
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"cruder/internal/model"
+	"cruder/internal/repository"
+	"cruder/pkg/validation"
+	"errors"
+	"fmt"
+)
+
+// BatchResult is the outcome of one batch operation: the created or updated
+// user (nil for deletions), or a service error.
+type BatchResult struct {
+	User *model.User
+	Err  error
+}
+
+// BatchError reports the operation that aborted an atomic batch.
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("operation %d: %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// Batch applies ops in order. In atomic mode all operations run in one
+// transaction and the first failure rolls everything back and is returned
+// as a *BatchError. Otherwise every operation succeeds or fails on its own
+// and its outcome is reported in the result at the same index.
+// Consecutive creates are written with multi-row inserts.
+func (s *userService) Batch(ctx context.Context, ops []model.BatchOperation, atomic bool) ([]BatchResult, error) {
+	if len(ops) == 0 {
+		return nil, &ValidationError{Errors: []FieldError{{Field: "operations", Message: "must not be empty"}}}
+	}
+	if len(ops) > model.MaxBatchOperations {
+		return nil, &ValidationError{Errors: []FieldError{{
+			Field:   "operations",
+			Message: fmt.Sprintf("must contain at most %d operations", model.MaxBatchOperations),
+		}}}
+	}
+
+	if !atomic {
+		return applyBatch(ctx, s.repo, ops, false)
+	}
+
+	var results []BatchResult
+	err := s.repos.WithTx(ctx, func(repos *repository.Repository) error {
+		var err error
+		results, err = applyBatch(ctx, repos.Users, ops, true)
+		return err
+	})
+	if err != nil {
+		var batchErr *BatchError
+		if errors.As(err, &batchErr) {
+			return nil, err
+		}
+		return nil, translateError(err)
+	}
+	return results, nil
+}
+
+// applyBatch runs ops against repo. With stopOnError the first failure is
+// returned as a *BatchError; otherwise failures are recorded in the results.
+func applyBatch(ctx context.Context, repo repository.UserRepository, ops []model.BatchOperation, stopOnError bool) ([]BatchResult, error) {
+	results := make([]BatchResult, len(ops))
+
+	// Validate up front so an atomic batch fails before touching the database.
+	for i := range ops {
+		if err := validateBatchOperation(&ops[i]); err != nil {
+			if stopOnError {
+				return nil, &BatchError{Index: i, Err: err}
+			}
+			results[i].Err = err
+		}
+	}
+
+	var pending []int
+	flushCreates := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		users := make([]*model.User, len(pending))
+		for i, idx := range pending {
+			users[i] = ops[idx].User
+		}
+
+		created, err := repo.CreateMany(ctx, users)
+		for i, idx := range pending {
+			switch {
+			case i < len(created) && created[i].Err != nil:
+				results[idx].Err = translateError(created[i].Err)
+			case i < len(created):
+				results[idx].User = created[i].User
+			default:
+				results[idx].Err = translateError(err)
+			}
+			if stopOnError && results[idx].Err != nil {
+				return &BatchError{Index: idx, Err: results[idx].Err}
+			}
+		}
+		pending = pending[:0]
+		return nil
+	}
+
+	for i, op := range ops {
+		if results[i].Err != nil {
+			continue
+		}
+		if op.Op == model.BatchOpCreate {
+			pending = append(pending, i)
+			continue
+		}
+		// Earlier creates must be written first, e.g. a delete may free a
+		// username that a later create takes.
+		if err := flushCreates(); err != nil {
+			return nil, err
+		}
+
+		switch op.Op {
+		case model.BatchOpUpdate:
+			results[i].User, results[i].Err = updateUser(ctx, repo, op.UUID, op.User, op.Version)
+		case model.BatchOpDelete:
+			results[i].Err = deleteUser(ctx, repo, op.UUID, op.Version)
+		}
+		if stopOnError && results[i].Err != nil {
+			return nil, &BatchError{Index: i, Err: results[i].Err}
+		}
+	}
+	if err := flushCreates(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func validateBatchOperation(op *model.BatchOperation) error {
+	var errs validation.Errors
+	switch op.Op {
+	case model.BatchOpCreate:
+	case model.BatchOpUpdate, model.BatchOpDelete:
+		if op.UUID == "" {
+			errs.Add("uuid", "is required")
+		}
+	default:
+		errs.Add("op", fmt.Sprintf("must be one of %q, %q or %q", model.BatchOpCreate, model.BatchOpUpdate, model.BatchOpDelete))
+	}
+
+	if op.Op == model.BatchOpCreate || op.Op == model.BatchOpUpdate {
+		if op.User == nil {
+			errs.Add("user", "is required")
+		} else if err := validateUser(op.User); err != nil {
+			var validationErr *ValidationError
+			errors.As(err, &validationErr)
+			errs = append(errs, validationErr.Errors...)
+		}
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"cruder/internal/repository"
+	"cruder/pkg/validation"
+	"errors"
+	"strings"
+)
+
+// NotFoundError is returned when the requested resource does not exist.
+type NotFoundError struct {
+	Resource string
+}
+
+func (e *NotFoundError) Error() string {
+	return e.Resource + " is not found"
+}
+
+// ConflictError is returned when a write collides with existing data.
+// Field names the attribute that conflicted, if known.
+type ConflictError struct {
+	Field   string
+	Message string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Message
+}
+
+type FieldError = validation.FieldError
+
+// ValidationError is returned when input fails business validation.
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fe.Field + ": " + fe.Message
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+// PreconditionFailedError is returned when a conditional write does not
+// match the current version of the resource.
+type PreconditionFailedError struct {
+	Message string
+}
+
+func (e *PreconditionFailedError) Error() string {
+	return e.Message
+}
+
+// ForbiddenError is returned when the caller is not allowed to perform an operation.
+type ForbiddenError struct {
+	Message string
+}
+
+func (e *ForbiddenError) Error() string {
+	return e.Message
+}
+
+// UnauthorizedError is returned when credentials presented to the service,
+// such as a password, are wrong.
+type UnauthorizedError struct {
+	Message string
+}
+
+func (e *UnauthorizedError) Error() string {
+	return e.Message
+}
+
+// InternalError wraps unexpected failures. Its message is safe to show
+// to clients; the cause is only available through Unwrap.
+type InternalError struct {
+	Err error
+}
+
+func (e *InternalError) Error() string {
+	return "internal error"
+}
+
+func (e *InternalError) Unwrap() error {
+	return e.Err
+}
+
+// OAuth error codes of RFC 6749 section 5.2.
+const (
+	OAuthInvalidRequest       = "invalid_request"
+	OAuthInvalidClient        = "invalid_client"
+	OAuthInvalidGrant         = "invalid_grant"
+	OAuthUnauthorizedClient   = "unauthorized_client"
+	OAuthUnsupportedGrantType = "unsupported_grant_type"
+	OAuthInvalidScope         = "invalid_scope"
+)
+
+// OAuthError is returned by the token and introspection endpoints, which
+// report errors as RFC 6749 error responses rather than problem details.
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (e *OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+var errUserNotFound = &NotFoundError{Resource: "user"}
+
+// translateError converts repository errors into the service error taxonomy.
+// Service errors and errors that callers handle themselves (cursor, filter
+// syntax, deadlines) are passed through unchanged.
+func translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	switch err.(type) {
+	case *NotFoundError, *ConflictError, *ValidationError, *PreconditionFailedError, *ForbiddenError, *InternalError,
+		*UnauthorizedError, *OAuthError:
+		return err
+	}
+
+	if errors.Is(err, repository.ErrVersionMismatch) {
+		return &PreconditionFailedError{Message: "user has been modified since it was read"}
+	}
+
+	var uniqueErr *repository.UniqueViolationError
+	if errors.As(err, &uniqueErr) {
+		return &ConflictError{Field: uniqueErr.Field, Message: uniqueErr.Error()}
+	}
+
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) ||
+		errors.Is(err, ErrInvalidCursor) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	return &InternalError{Err: err}
+}
@@ -0,0 +1,428 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"cruder/internal/model"
+	"cruder/internal/repository"
+	"cruder/pkg/csvsafe"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// importBatchSize is the number of valid rows created with one CreateMany
+// call, and the interval at which background jobs report progress.
+const importBatchSize = 500
+
+// maxImportLineBytes bounds a single NDJSON line.
+const maxImportLineBytes = 1 << 20
+
+// ImportStaleAfter is how long a background import may go without
+// reporting progress before it is taken to be abandoned by a process that
+// crashed. Jobs report progress after every batch.
+const ImportStaleAfter = 15 * time.Minute
+
+type ImportService interface {
+	Import(ctx context.Context, src io.Reader, format string, dryRun bool) (model.ImportStats, []model.ImportError, error)
+	StartImport(ctx context.Context, src io.ReadCloser, format string, dryRun bool) (*model.ImportJob, error)
+	GetJob(ctx context.Context, id string) (*model.ImportJob, error)
+	Report(ctx context.Context, id string, fn func(model.ImportError) error) error
+	FailStale(ctx context.Context) (int64, error)
+	Shutdown(ctx context.Context) error
+}
+
+// Imports stream-parse CSV (with a header row) or NDJSON and run every row
+// through the same validation as Create. Unless it is a dry run, valid rows
+// are created in batches; rows that conflict with existing users are
+// reported like invalid ones.
+type importService struct {
+	users repository.UserRepository
+	jobs  repository.ImportRepository
+
+	// Background jobs run until stop is cancelled by Shutdown, which waits
+	// for them on running. closed keeps jobs from starting after that.
+	stop    context.Context
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	closed  bool
+	running sync.WaitGroup
+}
+
+func NewImportService(repos *repository.Repository) ImportService {
+	stop, cancel := context.WithCancel(context.Background())
+	return &importService{users: repos.Users, jobs: repos.Imports, stop: stop, cancel: cancel}
+}
+
+var (
+	errImportJobNotFound = &NotFoundError{Resource: "import job"}
+	errShuttingDown      = &InternalError{Err: errors.New("import service is shutting down")}
+)
+
+const (
+	importInterruptedMessage = "import interrupted by a shutdown"
+	importAbandonedMessage   = "import abandoned by a stopped server"
+)
+
+// Import runs an import to completion and returns its counts and the
+// rejected lines in line order.
+func (s *importService) Import(ctx context.Context, src io.Reader, format string, dryRun bool) (model.ImportStats, []model.ImportError, error) {
+	var report []model.ImportError
+	stats, err := s.run(ctx, src, format, dryRun, func(_ model.ImportStats, errs []model.ImportError) error {
+		report = append(report, errs...)
+		return nil
+	})
+	if err != nil {
+		return stats, nil, translateError(err)
+	}
+	slices.SortStableFunc(report, func(a, b model.ImportError) int { return a.Line - b.Line })
+	return stats, report, nil
+}
+
+// StartImport records a job and runs the import in the background, reading
+// and finally closing src. The returned job is a snapshot; poll GetJob for
+// progress. The job keeps the audit source of ctx but not its deadline.
+func (s *importService) StartImport(ctx context.Context, src io.ReadCloser, format string, dryRun bool) (*model.ImportJob, error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = src.Close()
+		return nil, errShuttingDown
+	}
+	s.running.Add(1)
+	s.mu.Unlock()
+
+	job := &model.ImportJob{Status: model.ImportJobPending, Format: format, DryRun: dryRun}
+	if err := s.jobs.Create(ctx, job); err != nil {
+		s.running.Done()
+		_ = src.Close()
+		return nil, translateError(err)
+	}
+
+	snapshot := *job
+	jobCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stopJob := context.AfterFunc(s.stop, cancel)
+	go func() {
+		defer s.running.Done()
+		defer cancel()
+		defer stopJob()
+		s.runJob(jobCtx, job, src)
+	}()
+	return &snapshot, nil
+}
+
+// Shutdown stops background imports, marking them failed, and waits for
+// them until ctx ends. Imports cannot be started afterwards.
+func (s *importService) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FailStale marks the jobs that crashed processes left unfinished as failed.
+func (s *importService) FailStale(ctx context.Context) (int64, error) {
+	n, err := s.jobs.FailStale(ctx, ImportStaleAfter, importAbandonedMessage)
+	return n, translateError(err)
+}
+
+func (s *importService) runJob(ctx context.Context, job *model.ImportJob, src io.ReadCloser) {
+	defer func() { _ = src.Close() }()
+
+	job.Status = model.ImportJobRunning
+	if err := s.jobs.Update(ctx, job); err != nil {
+		log.Printf("import job %s: %v", job.ID, err)
+	}
+
+	stats, err := s.run(ctx, src, job.Format, job.DryRun, func(stats model.ImportStats, errs []model.ImportError) error {
+		if err := s.jobs.AddErrors(ctx, job.ID, errs); err != nil {
+			return err
+		}
+		job.ImportStats = stats
+		return s.jobs.Update(ctx, job)
+	})
+
+	finishedAt := time.Now()
+	job.ImportStats = stats
+	job.FinishedAt = &finishedAt
+	job.Status = model.ImportJobSucceeded
+	if err != nil {
+		job.Status = model.ImportJobFailed
+		var validationErr *ValidationError
+		switch {
+		case errors.As(err, &validationErr):
+			job.Error = validationErr.Error()
+		case s.stop.Err() != nil:
+			job.Error = importInterruptedMessage
+		default:
+			log.Printf("import job %s: %v", job.ID, err)
+			job.Error = "import aborted by an internal error"
+		}
+	}
+	// The outcome is recorded even if the job was stopped.
+	if err := s.jobs.Update(context.WithoutCancel(ctx), job); err != nil {
+		log.Printf("import job %s: %v", job.ID, err)
+	}
+}
+
+func (s *importService) GetJob(ctx context.Context, id string) (*model.ImportJob, error) {
+	if !isUUID(id) {
+		return nil, errImportJobNotFound
+	}
+	job, err := s.jobs.Get(ctx, id)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if job == nil {
+		return nil, errImportJobNotFound
+	}
+	return job, nil
+}
+
+// Report calls fn for every line of job id rejected so far, in line order.
+// It does not check that the job exists; use GetJob for that.
+func (s *importService) Report(ctx context.Context, id string, fn func(model.ImportError) error) error {
+	if !isUUID(id) {
+		return errImportJobNotFound
+	}
+	return translateError(s.jobs.Errors(ctx, id, fn))
+}
+
+// run reads every row of src and, unless dryRun, creates the valid ones.
+// After each batch, flush receives the running counts and the lines
+// rejected since the previous call.
+func (s *importService) run(ctx context.Context, src io.Reader, format string, dryRun bool, flush func(model.ImportStats, []model.ImportError) error) (model.ImportStats, error) {
+	var stats model.ImportStats
+	reader, err := newImportReader(src, format)
+	if err != nil {
+		return stats, err
+	}
+
+	var errs []model.ImportError
+	var batch []importRow
+	writeBatch := func() error {
+		if dryRun || len(batch) == 0 {
+			stats.Succeeded += len(batch)
+		} else {
+			users := make([]*model.User, len(batch))
+			for i := range batch {
+				users[i] = &batch[i].user
+			}
+			results, err := s.users.CreateMany(ctx, users)
+			if err != nil {
+				return err
+			}
+			for i, result := range results {
+				if result.Err == nil {
+					stats.Succeeded++
+					continue
+				}
+				stats.Failed++
+				importErr := model.ImportError{Line: batch[i].line, Message: result.Err.Error()}
+				var uniqueErr *repository.UniqueViolationError
+				if errors.As(result.Err, &uniqueErr) {
+					importErr.Field = uniqueErr.Field
+				}
+				errs = append(errs, importErr)
+			}
+		}
+		batch = batch[:0]
+
+		err := flush(stats, errs)
+		errs = errs[:0]
+		return err
+	}
+
+	for {
+		row, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, err
+		}
+
+		stats.Processed++
+		if row.err != nil {
+			stats.Failed++
+			errs = append(errs, model.ImportError{Line: row.line, Message: row.err.Error()})
+			continue
+		}
+		if err := validateUser(&row.user); err != nil {
+			stats.Failed++
+			var validationErr *ValidationError
+			errors.As(err, &validationErr)
+			for _, fe := range validationErr.Errors {
+				errs = append(errs, model.ImportError{Line: row.line, Field: fe.Field, Message: fe.Message})
+			}
+			continue
+		}
+
+		batch = append(batch, row)
+		if len(batch) >= importBatchSize {
+			if err := writeBatch(); err != nil {
+				return stats, err
+			}
+		}
+	}
+	return stats, writeBatch()
+}
+
+// importRow is a parsed record and the line it starts on. err is set if the
+// line could not be parsed; the import continues with the next one.
+type importRow struct {
+	line int
+	user model.User
+	err  error
+}
+
+type importReader interface {
+	// next returns the next row, or io.EOF after the last one.
+	next() (importRow, error)
+}
+
+func newImportReader(src io.Reader, format string) (importReader, error) {
+	switch format {
+	case model.ImportFormatCSV:
+		return newCSVImportReader(src)
+	case model.ImportFormatNDJSON:
+		return newNDJSONImportReader(src), nil
+	default:
+		return nil, fmt.Errorf("unsupported import format %q", format)
+	}
+}
+
+func fileError(format string, args ...any) error {
+	return &ValidationError{Errors: []FieldError{{Field: "file", Message: fmt.Sprintf(format, args...)}}}
+}
+
+type csvImportReader struct {
+	r       *csv.Reader
+	columns map[string]int
+}
+
+// newCSVImportReader reads the header row. Columns are matched by name, in
+// any order; username and email are required and unknown ones are ignored,
+// so files produced by the export can be imported again.
+func newCSVImportReader(src io.Reader) (*csvImportReader, error) {
+	r := csv.NewReader(src)
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, fileError("is empty")
+	}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return nil, fileError("invalid header: %v", parseErr.Err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"username", "email"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fileError("missing column %q", required)
+		}
+	}
+	return &csvImportReader{r: r, columns: columns}, nil
+}
+
+func (c *csvImportReader) next() (importRow, error) {
+	record, err := c.r.Read()
+	if err == io.EOF {
+		return importRow{}, io.EOF
+	}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return importRow{line: parseErr.StartLine, err: parseErr.Err}, nil
+	}
+	if err != nil {
+		return importRow{}, err
+	}
+
+	field := func(name string) string {
+		i, ok := c.columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return csvsafe.Unescape(record[i])
+	}
+	line, _ := c.r.FieldPos(0)
+	return importRow{
+		line: line,
+		user: model.User{Username: field("username"), Email: field("email"), FullName: field("full_name")},
+	}, nil
+}
+
+type ndjsonImportReader struct {
+	s    *bufio.Scanner
+	line int
+}
+
+// newNDJSONImportReader reads one JSON user object per line. Blank lines
+// are skipped and members other than username, email and full_name are
+// ignored.
+func newNDJSONImportReader(src io.Reader) *ndjsonImportReader {
+	s := bufio.NewScanner(src)
+	s.Buffer(make([]byte, 0, 64*1024), maxImportLineBytes)
+	return &ndjsonImportReader{s: s}
+}
+
+func (n *ndjsonImportReader) next() (importRow, error) {
+	for n.s.Scan() {
+		n.line++
+		text := bytes.TrimSpace(n.s.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		var record struct {
+			Username string `json:"username"`
+			Email    string `json:"email"`
+			FullName string `json:"full_name"`
+		}
+		if err := json.Unmarshal(text, &record); err != nil {
+			return importRow{line: n.line, err: fmt.Errorf("invalid JSON: %w", err)}, nil
+		}
+		return importRow{
+			line: n.line,
+			user: model.User{Username: record.Username, Email: record.Email, FullName: record.FullName},
+		}, nil
+	}
+	if errors.Is(n.s.Err(), bufio.ErrTooLong) {
+		return importRow{}, fileError("line %d is longer than %d bytes", n.line+1, maxImportLineBytes)
+	}
+	if err := n.s.Err(); err != nil {
+		return importRow{}, err
+	}
+	return importRow{}, io.EOF
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"cruder/internal/model"
+	"cruder/internal/repository"
+)
+
+func TestImportDryRun(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  string
+		stats  model.ImportStats
+		errors []model.ImportError
+	}{
+		{
+			name:   "csv",
+			format: model.ImportFormatCSV,
+			input: "\ufeffUsername,email,extra\n" +
+				"jdoe,jdoe@example.com,1\n" +
+				"ab,not-an-email\n" +
+				"\"broken,x@example.com\n",
+			stats: model.ImportStats{Processed: 3, Succeeded: 1, Failed: 2},
+			errors: []model.ImportError{
+				{Line: 3, Field: "username", Message: "must be between 3 and 50 characters"},
+				{Line: 3, Field: "email", Message: "must be a valid email address"},
+				{Line: 4, Message: "extraneous or missing \" in quoted-field"},
+			},
+		},
+		{
+			name:   "ndjson",
+			format: model.ImportFormatNDJSON,
+			input: `{"username": "jdoe", "email": "jdoe@example.com", "id": 9}` + "\n" +
+				"\n" +
+				`{"username": "jane", "email": "jane@example.com", "full_name": "  Jane  "}` + "\n" +
+				"not json\n",
+			stats: model.ImportStats{Processed: 3, Succeeded: 2, Failed: 1},
+			errors: []model.ImportError{
+				{Line: 4, Message: "invalid JSON: invalid character 'o' in literal null (expecting 'u')"},
+			},
+		},
+	}
+
+	s := &importService{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats, errs, err := s.Import(context.Background(), strings.NewReader(tt.input), tt.format, true)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if stats != tt.stats {
+				t.Errorf("expected stats %+v, got %+v", tt.stats, stats)
+			}
+			if len(errs) != len(tt.errors) {
+				t.Fatalf("expected errors %+v, got %+v", tt.errors, errs)
+			}
+			for i := range errs {
+				if errs[i] != tt.errors[i] {
+					t.Errorf("error %d: expected %+v, got %+v", i, tt.errors[i], errs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestImportMissingColumn(t *testing.T) {
+	s := &importService{}
+	_, _, err := s.Import(context.Background(), strings.NewReader("username,full_name\njdoe,John\n"), model.ImportFormatCSV, true)
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Errors[0].Message != `missing column "email"` {
+		t.Errorf("expected missing column error, got %v", err)
+	}
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestStartImportAfterShutdown(t *testing.T) {
+	s := NewImportService(&repository.Repository{})
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("expected an idle service to stop at once, got %v", err)
+	}
+
+	src := &closeRecorder{Reader: strings.NewReader("")}
+	if _, err := s.StartImport(context.Background(), src, model.ImportFormatNDJSON, false); err != errShuttingDown {
+		t.Errorf("expected %v, got %v", errShuttingDown, err)
+	}
+	if !src.closed {
+		t.Error("expected the upload to be closed")
+	}
+}
+
+func TestImportJobMalformedID(t *testing.T) {
+	s := NewImportService(&repository.Repository{})
+	var notFound *NotFoundError
+	if _, err := s.GetJob(context.Background(), "not-a-uuid"); !errors.As(err, &notFound) {
+		t.Errorf("GetJob: expected not found, got %v", err)
+	}
+	err := s.Report(context.Background(), "not-a-uuid", func(model.ImportError) error { return nil })
+	if !errors.As(err, &notFound) {
+		t.Errorf("Report: expected not found, got %v", err)
+	}
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"strings"
+
+	"cruder/internal/model"
+	"cruder/pkg/validation"
+)
+
+// mutableUserMembers are the members a JSON Patch may change; every other
+// member of the representation can only be tested.
+var mutableUserMembers = []string{"username", "email", "full_name"}
+
+// JSONPatch applies RFC 6902 operations (add, remove, replace, test) to a user
+// atomically. A failing test operation returns a ConflictError and no change
+// is written.
+func (s *userService) JSONPatch(ctx context.Context, uuid string, ops []model.JSONPatchOperation, expectedVersion int) (*model.User, error) {
+	patchedUser, err := s.repo.PatchWith(ctx, uuid, expectedVersion, func(current *model.User) (*model.UserPatch, error) {
+		patch, err := applyJSONPatch(current, ops)
+		if err != nil {
+			return nil, err
+		}
+		if err := validatePatch(patch); err != nil {
+			return nil, err
+		}
+		return patch, nil
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if patchedUser == nil {
+		return nil, errUserNotFound
+	}
+	return patchedUser, nil
+}
+
+// applyJSONPatch runs ops against the JSON representation of current and
+// returns the resulting changes to the mutable members.
+func applyJSONPatch(current *model.User, ops []model.JSONPatchOperation) (*model.UserPatch, error) {
+	doc, err := userDocument(current)
+	if err != nil {
+		return nil, &InternalError{Err: err}
+	}
+	original := maps.Clone(doc)
+
+	var errs validation.Errors
+	for i, op := range ops {
+		field := fmt.Sprintf("operations/%d", i)
+
+		member, ok := pointerMember(op.Path)
+		if !ok {
+			errs.Add(field, fmt.Sprintf("path %q does not reference a user member", op.Path))
+			continue
+		}
+		_, exists := doc[member]
+
+		var value any
+		switch op.Op {
+		case "add", "replace", "test":
+			if len(op.Value) == 0 {
+				errs.Add(field, fmt.Sprintf("%s requires a value", op.Op))
+				continue
+			}
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				errs.Add(field, "value is not valid JSON")
+				continue
+			}
+		case "remove":
+		default:
+			errs.Add(field, fmt.Sprintf("unsupported operation %q", op.Op))
+			continue
+		}
+
+		if op.Op != "add" && !exists {
+			errs.Add(field, fmt.Sprintf("path %q does not exist", op.Path))
+			continue
+		}
+
+		switch op.Op {
+		case "test":
+			if !reflect.DeepEqual(doc[member], value) {
+				return nil, &ConflictError{
+					Field:   member,
+					Message: fmt.Sprintf("test operation %d failed: %s does not match", i, op.Path),
+				}
+			}
+		case "remove":
+			delete(doc, member)
+		default:
+			doc[member] = value
+		}
+	}
+	if len(errs) > 0 {
+		return nil, &ValidationError{Errors: errs}
+	}
+
+	for member, value := range original {
+		if !isMutableUserMember(member) && !reflect.DeepEqual(doc[member], value) {
+			errs.Add(member, "is read-only")
+		}
+	}
+	for member := range doc {
+		if _, known := original[member]; !known {
+			errs.Add(member, "is not a user field")
+		}
+	}
+
+	patch := &model.UserPatch{}
+	targets := map[string]*model.PatchString{
+		"username":  &patch.Username,
+		"email":     &patch.Email,
+		"full_name": &patch.FullName,
+	}
+	for _, member := range mutableUserMembers {
+		value := doc[member]
+		if reflect.DeepEqual(value, original[member]) {
+			continue
+		}
+		target := targets[member]
+		target.Set = true
+		switch v := value.(type) {
+		case nil:
+			target.Null = true
+		case string:
+			target.Value = v
+		default:
+			errs.Add(member, "must be a string or null")
+		}
+	}
+	if len(errs) > 0 {
+		return nil, &ValidationError{Errors: errs}
+	}
+	return patch, nil
+}
+
+// userDocument returns the generic JSON form of u, as clients see it.
+func userDocument(u *model.User) (map[string]any, error) {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// pointerMember resolves a single segment JSON Pointer such as "/email".
+func pointerMember(pointer string) (string, bool) {
+	if !strings.HasPrefix(pointer, "/") || strings.Count(pointer, "/") != 1 {
+		return "", false
+	}
+	member := strings.NewReplacer("~1", "/", "~0", "~").Replace(pointer[1:])
+	if member == "" {
+		return "", false
+	}
+	return member, true
+}
+
+func isMutableUserMember(member string) bool {
+	for _, m := range mutableUserMembers {
+		if m == member {
+			return true
+		}
+	}
+	return false
+}
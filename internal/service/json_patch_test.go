@@ -0,0 +1,69 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"cruder/internal/model"
+)
+
+func jsonPatchOps(t *testing.T, doc string) []model.JSONPatchOperation {
+	var ops []model.JSONPatchOperation
+	if err := json.Unmarshal([]byte(doc), &ops); err != nil {
+		t.Fatalf("invalid test patch: %v", err)
+	}
+	return ops
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	current := &model.User{ID: 7, UUID: "u-1", Username: "jdoe", Email: "old@example.com", FullName: "John Doe"}
+
+	patch, err := applyJSONPatch(current, jsonPatchOps(t, `[
+		{"op": "test", "path": "/email", "value": "old@example.com"},
+		{"op": "replace", "path": "/email", "value": "new@example.com"},
+		{"op": "remove", "path": "/full_name"},
+		{"op": "test", "path": "/id", "value": 7}
+	]`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !patch.Email.Set || patch.Email.Value != "new@example.com" {
+		t.Errorf("expected email to be replaced, got %+v", patch.Email)
+	}
+	if !patch.FullName.Set || !patch.FullName.Null {
+		t.Errorf("expected full_name to be cleared, got %+v", patch.FullName)
+	}
+	if patch.Username.Set {
+		t.Errorf("expected username to be unchanged, got %+v", patch.Username)
+	}
+}
+
+func TestApplyJSONPatch_Errors(t *testing.T) {
+	current := &model.User{ID: 7, UUID: "u-1", Username: "jdoe", Email: "old@example.com"}
+
+	_, err := applyJSONPatch(current, jsonPatchOps(t, `[
+		{"op": "test", "path": "/email", "value": "other@example.com"},
+		{"op": "replace", "path": "/email", "value": "new@example.com"}
+	]`))
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || conflict.Field != "email" {
+		t.Errorf("expected conflict on email, got %v", err)
+	}
+
+	tests := []string{
+		`[{"op": "replace", "path": "/uuid", "value": "u-2"}]`,
+		`[{"op": "add", "path": "/password", "value": "secret"}]`,
+		`[{"op": "move", "from": "/email", "path": "/username"}]`,
+		`[{"op": "replace", "path": "/username"}]`,
+		`[{"op": "replace", "path": "/username", "value": 42}]`,
+		`[{"op": "remove", "path": "/missing"}]`,
+	}
+	for _, doc := range tests {
+		_, err := applyJSONPatch(current, jsonPatchOps(t, doc))
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Errorf("%s: expected validation error, got %v", doc, err)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package service
+
+import (
+	"context"
+	"cruder/internal/auth"
+	"cruder/internal/model"
+	"cruder/internal/repository"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultMFAIssuer names the service in authenticator apps.
+	DefaultMFAIssuer = "cruder"
+
+	// recoveryCodeCount codes of recoveryCodeBytes random bytes are issued
+	// when MFA is enabled. 80 bits are too many to guess from their hashes.
+	recoveryCodeCount = 10
+	recoveryCodeBytes = 10
+
+	// mfaChallengeTTL is how long a login waits for its second factor.
+	mfaChallengeTTL = 5 * time.Minute
+)
+
+type MFAService interface {
+	Status(ctx context.Context, uuid string) (*model.MFAStatus, error)
+	Enroll(ctx context.Context, uuid string) (*model.TOTPEnrollment, error)
+	Activate(ctx context.Context, uuid, code string) (*model.RecoveryCodes, error)
+	Reset(ctx context.Context, uuid string) error
+	Challenge(ctx context.Context, user *model.User) (*model.MFAChallenge, error)
+	CompleteLogin(ctx context.Context, mfaToken, code string) (*model.User, error)
+}
+
+// Users enable TOTP by enrolling a secret and confirming it with a first
+// code. Once it is enabled, a login with the right password only returns a
+// challenge, which is exchanged for a token with a TOTP or recovery code.
+// Secrets are encrypted with keys, bound to their user.
+type mfaService struct {
+	repos  *repository.Repository
+	keys   *auth.SecretBox
+	issuer string
+}
+
+// NewMFAService returns an MFA service that encrypts secrets with keys.
+// Without keys, users cannot enroll and users with MFA cannot log in.
+func NewMFAService(repos *repository.Repository, keys *auth.SecretBox, issuer string) MFAService {
+	if issuer == "" {
+		issuer = DefaultMFAIssuer
+	}
+	return &mfaService{repos: repos, keys: keys, issuer: issuer}
+}
+
+var (
+	errMFANotConfigured = &InternalError{Err: errors.New("no MFA encryption keys are configured")}
+	errMFAEnabled       = &ConflictError{Field: "mfa", Message: "MFA is already enabled"}
+	errInvalidMFAToken  = &UnauthorizedError{Message: "MFA token is invalid or expired"}
+	errInvalidMFACode   = &UnauthorizedError{Message: "invalid code"}
+)
+
+// Status describes the second factor of a live user.
+func (s *mfaService) Status(ctx context.Context, uuid string) (*model.MFAStatus, error) {
+	if _, err := s.liveUser(ctx, uuid); err != nil {
+		return nil, err
+	}
+	factor, err := s.repos.MFA.Get(ctx, uuid, false)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if factor == nil || factor.EnabledAt == nil {
+		return &model.MFAStatus{}, nil
+	}
+	remaining, err := s.repos.MFA.CountRecoveryCodes(ctx, uuid)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &model.MFAStatus{Enabled: true, EnabledAt: factor.EnabledAt, RecoveryCodesRemaining: remaining}, nil
+}
+
+// Enroll generates a TOTP secret for a live user without MFA. It replaces
+// the secret of an enrollment that was never activated.
+func (s *mfaService) Enroll(ctx context.Context, uuid string) (*model.TOTPEnrollment, error) {
+	user, err := s.liveUser(ctx, uuid)
+	if err != nil {
+		return nil, err
+	}
+	if s.keys == nil {
+		return nil, errMFANotConfigured
+	}
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return nil, translateError(err)
+	}
+	ciphertext, err := s.keys.Seal(secret, []byte(uuid))
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if err := s.repos.MFA.Enroll(ctx, uuid, ciphertext); err != nil {
+		if errors.Is(err, repository.ErrMFAEnabled) {
+			return nil, errMFAEnabled
+		}
+		return nil, translateError(err)
+	}
+	return &model.TOTPEnrollment{
+		Secret: auth.EncodeTOTPSecret(secret),
+		URI:    auth.TOTPURI(s.issuer, user.Username, secret),
+	}, nil
+}
+
+// Activate enables a pending enrollment with a code from the authenticator,
+// proving it was set up, and returns the new recovery codes.
+func (s *mfaService) Activate(ctx context.Context, uuid, code string) (*model.RecoveryCodes, error) {
+	if _, err := s.liveUser(ctx, uuid); err != nil {
+		return nil, err
+	}
+	if code == "" {
+		return nil, &ValidationError{Errors: []FieldError{{Field: "code", Message: "is required"}}}
+	}
+
+	var codes []string
+	err := s.repos.WithTx(ctx, func(repos *repository.Repository) error {
+		factor, err := repos.MFA.Get(ctx, uuid, true)
+		if err != nil {
+			return err
+		}
+		if factor == nil {
+			return &ConflictError{Field: "mfa", Message: "MFA enrollment has not been started"}
+		}
+		if factor.EnabledAt != nil {
+			return errMFAEnabled
+		}
+		secret, err := s.secret(ctx, repos, factor)
+		if err != nil {
+			return err
+		}
+		step, ok := auth.VerifyTOTP(secret, code, time.Now(), factor.LastUsedStep)
+		if !ok {
+			return &ValidationError{Errors: []FieldError{{Field: "code", Message: "is invalid"}}}
+		}
+		var hashes [][]byte
+		if codes, hashes, err = newRecoveryCodes(); err != nil {
+			return err
+		}
+		return repos.MFA.Enable(ctx, uuid, step, hashes)
+	})
+	if errors.Is(err, repository.ErrMFAEnabled) {
+		return nil, errMFAEnabled
+	}
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &model.RecoveryCodes{Codes: codes}, nil
+}
+
+// Reset removes the second factor of a user who lost it, so that they log
+// in with their password alone and can enroll again.
+func (s *mfaService) Reset(ctx context.Context, uuid string) error {
+	if _, err := s.liveUser(ctx, uuid); err != nil {
+		return err
+	}
+	deleted, err := s.repos.MFA.Delete(ctx, uuid)
+	if err != nil {
+		return translateError(err)
+	}
+	if !deleted {
+		return &NotFoundError{Resource: "MFA"}
+	}
+	return nil
+}
+
+// Challenge returns the challenge for a login of user with the right
+// password, or nil if user has no MFA and is logged in already.
+func (s *mfaService) Challenge(ctx context.Context, user *model.User) (*model.MFAChallenge, error) {
+	factor, err := s.repos.MFA.Get(ctx, user.UUID, false)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if factor == nil || factor.EnabledAt == nil {
+		return nil, nil
+	}
+	token, err := newUserToken(ctx, s.repos.UserTokens, user, model.TokenPurposeMFALogin, mfaChallengeTTL)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &model.MFAChallenge{MFARequired: true, MFAToken: token, ExpiresIn: int64(mfaChallengeTTL / time.Second)}, nil
+}
+
+// CompleteLogin returns the user of a challenge given a TOTP code or an
+// unused recovery code. Wrong codes count towards a lockout of the factor,
+// which logging in with the password again does not lift.
+func (s *mfaService) CompleteLogin(ctx context.Context, mfaToken, code string) (*model.User, error) {
+	if mfaToken == "" {
+		return nil, errInvalidMFAToken
+	}
+
+	var user *model.User
+	var failed bool
+	err := s.repos.WithTx(ctx, func(repos *repository.Repository) error {
+		token, err := repos.UserTokens.Get(ctx, hashSecret(mfaToken), true)
+		if err != nil {
+			return err
+		}
+		if token == nil || token.Purpose != model.TokenPurposeMFALogin || token.UsedAt != nil || !token.ExpiresAt.After(time.Now()) {
+			return errInvalidMFAToken
+		}
+		if user, err = repos.Users.GetByUUID(ctx, token.UserUUID, false); err != nil {
+			return err
+		}
+		if user == nil {
+			return errInvalidMFAToken
+		}
+		// A factor reset since the password was checked needs a new login.
+		factor, err := repos.MFA.Get(ctx, user.UUID, true)
+		if err != nil {
+			return err
+		}
+		if factor == nil || factor.EnabledAt == nil {
+			return errInvalidMFAToken
+		}
+		secret, err := s.secret(ctx, repos, factor)
+		if err != nil {
+			return err
+		}
+
+		// Failures are committed, so they return nil and are reported
+		// after the transaction.
+		if factor.LockedUntil != nil && factor.LockedUntil.After(time.Now()) {
+			failed = true
+			return nil
+		}
+		var ok bool
+		if step, valid := auth.VerifyTOTP(secret, code, time.Now(), factor.LastUsedStep); valid {
+			ok, err = repos.MFA.UseStep(ctx, user.UUID, step)
+		} else if normalized := normalizeRecoveryCode(code); normalized != "" {
+			ok, err = repos.MFA.UseRecoveryCode(ctx, user.UUID, hashSecret(normalized))
+		}
+		if err != nil {
+			return err
+		}
+		if !ok {
+			failed = true
+			return repos.MFA.RecordFailure(ctx, user.UUID, maxFailedLogins, loginLockout)
+		}
+		return repos.UserTokens.UseAll(ctx, user.UUID, model.TokenPurposeMFALogin)
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if failed {
+		return nil, errInvalidMFACode
+	}
+	return user, nil
+}
+
+func (s *mfaService) liveUser(ctx context.Context, uuid string) (*model.User, error) {
+	if !isUUID(uuid) {
+		return nil, errUserNotFound
+	}
+	user, err := s.repos.Users.GetByUUID(ctx, uuid, false)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if user == nil {
+		return nil, errUserNotFound
+	}
+	return user, nil
+}
+
+// secret decrypts the secret of factor. A secret encrypted with a key that
+// has since been rotated out of first place is encrypted again.
+func (s *mfaService) secret(ctx context.Context, repos *repository.Repository, factor *model.TOTPFactor) ([]byte, error) {
+	if s.keys == nil {
+		return nil, errMFANotConfigured
+	}
+	owner := []byte(factor.UserUUID)
+	secret, current, err := s.keys.Open(factor.SecretCiphertext, owner)
+	if err != nil || current {
+		return secret, err
+	}
+	ciphertext, err := s.keys.Seal(secret, owner)
+	if err != nil {
+		return nil, err
+	}
+	return secret, repos.MFA.UpdateSecret(ctx, factor.UserUUID, ciphertext)
+}
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCodes returns recovery codes formatted for users, e.g.
+// "abcd-efgh-ijkl-mnop", and the hashes to store.
+func newRecoveryCodes() ([]string, [][]byte, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([][]byte, recoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(buf))
+		hashes[i] = hashSecret(code)
+		groups := make([]string, 0, len(code)/4)
+		for j := 0; j < len(code); j += 4 {
+			groups = append(groups, code[j:min(j+4, len(code))])
+		}
+		codes[i] = strings.Join(groups, "-")
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode undoes the formatting of a recovery code, and
+// returns "" for anything that cannot be one.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if _, err := recoveryCodeEncoding.DecodeString(strings.ToUpper(code)); err != nil || len(code) == 0 {
+		return ""
+	}
+	return code
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("expected %d codes, got %d and %d hashes", recoveryCodeCount, len(codes), len(hashes))
+	}
+	format := regexp.MustCompile(`^[a-z2-7]{4}(-[a-z2-7]{4}){3}$`)
+	seen := map[string]bool{}
+	for i, code := range codes {
+		if !format.MatchString(code) || seen[code] {
+			t.Errorf("unexpected or repeated code %q", code)
+		}
+		seen[code] = true
+
+		// Codes are accepted however they are typed.
+		for _, typed := range []string{code, strings.ToUpper(code), strings.ReplaceAll(code, "-", " ")} {
+			if !bytes.Equal(hashSecret(normalizeRecoveryCode(typed)), hashes[i]) {
+				t.Errorf("%q does not match the hash of %q", typed, code)
+			}
+		}
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	for _, code := range []string{"", "-", "not a code!", "123456"} {
+		if got := normalizeRecoveryCode(code); got != "" {
+			t.Errorf("normalizeRecoveryCode(%q) = %q, want \"\"", code, got)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package service
+
+import (
+	"context"
+	"cruder/internal/auth"
+	"cruder/internal/model"
+	"cruder/internal/repository"
+	"crypto/subtle"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	DefaultAccessTokenTTL  = 15 * time.Minute
+	DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+
+	refreshTokenBytes = 32
+	tokenIDBytes      = 16
+
+	// accessTokenType is the "typ" header of RFC 9068 access tokens.
+	accessTokenType = "at+jwt"
+)
+
+// TokenService is the token endpoint of a small OAuth 2.0 authorization
+// server for service clients: it supports the client credentials and
+// refresh token grants and RFC 7662 introspection. It also issues access
+// tokens to users who logged in with a password.
+type TokenService interface {
+	Token(ctx context.Context, req *model.TokenRequest) (*model.TokenResponse, error)
+	IssueUserToken(ctx context.Context, user *model.User, methods []string) (*model.TokenResponse, error)
+	Introspect(ctx context.Context, client model.ClientCredentials, token string) (*model.Introspection, error)
+	JWKS() []byte
+}
+
+// TokenConfig configures issued tokens. Access tokens are issued by and for
+// this service: Issuer is both their "iss" and their "aud".
+type TokenConfig struct {
+	Issuer          string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+}
+
+// Access tokens are signed JWTs and cannot be revoked before they expire;
+// refresh tokens are opaque, stored as SHA-256 hashes and rotated on use.
+type tokenService struct {
+	repos    *repository.Repository
+	repo     repository.OAuthRepository
+	keys     *auth.SigningKeys
+	verifier *auth.Verifier
+	config   TokenConfig
+}
+
+func NewTokenService(repos *repository.Repository, keys *auth.SigningKeys, config TokenConfig) TokenService {
+	if config.AccessTokenTTL <= 0 {
+		config.AccessTokenTTL = DefaultAccessTokenTTL
+	}
+	if config.RefreshTokenTTL <= 0 {
+		config.RefreshTokenTTL = DefaultRefreshTokenTTL
+	}
+	return &tokenService{
+		repos:    repos,
+		repo:     repos.OAuth,
+		keys:     keys,
+		verifier: auth.NewVerifier(keys, config.Issuer, config.Issuer),
+		config:   config,
+	}
+}
+
+var (
+	errInvalidClient       = &OAuthError{Code: OAuthInvalidClient, Description: "client authentication failed"}
+	errInvalidRefreshToken = &OAuthError{Code: OAuthInvalidGrant, Description: "refresh token is invalid, expired or revoked"}
+)
+
+func (s *tokenService) Token(ctx context.Context, req *model.TokenRequest) (*model.TokenResponse, error) {
+	if req.GrantType == "" {
+		return nil, &OAuthError{Code: OAuthInvalidRequest, Description: "grant_type is required"}
+	}
+	if !slices.Contains(model.GrantTypes, req.GrantType) {
+		return nil, &OAuthError{Code: OAuthUnsupportedGrantType, Description: "grant_type must be one of " + strings.Join(model.GrantTypes, ", ")}
+	}
+	client, err := s.authenticate(ctx, req.Client)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(client.GrantTypes, req.GrantType) {
+		return nil, &OAuthError{Code: OAuthUnauthorizedClient, Description: "client may not use grant type " + req.GrantType}
+	}
+
+	if req.GrantType == model.GrantRefreshToken {
+		return s.refresh(ctx, client, req)
+	}
+	scopes, err := grantScopes(req.Scope, client.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	return s.issue(ctx, s.repo, client, scopes, "")
+}
+
+// refresh exchanges a refresh token for new tokens. A token that has been
+// used before revokes its family, including the successor that was issued
+// for it.
+func (s *tokenService) refresh(ctx context.Context, client *model.OAuthClient, req *model.TokenRequest) (*model.TokenResponse, error) {
+	if req.RefreshToken == "" {
+		return nil, &OAuthError{Code: OAuthInvalidRequest, Description: "refresh_token is required"}
+	}
+
+	var resp *model.TokenResponse
+	reused := false
+	err := s.repos.WithTx(ctx, func(repos *repository.Repository) error {
+		token, err := repos.OAuth.GetRefreshToken(ctx, hashSecret(req.RefreshToken), true)
+		if err != nil {
+			return err
+		}
+		switch {
+		case token == nil || token.ClientID != client.ID:
+			return errInvalidRefreshToken
+		case token.RevokedAt != nil || !token.ExpiresAt.After(time.Now()):
+			return errInvalidRefreshToken
+		case token.UsedAt != nil:
+			// Commit the revocation rather than rolling it back with the error.
+			reused = true
+			return repos.OAuth.RevokeRefreshTokens(ctx, token.FamilyID)
+		}
+
+		// The client may have lost scopes since the token was issued.
+		granted := slices.DeleteFunc(token.Scopes, func(scope string) bool {
+			return !model.HasScope(client.Scopes, scope)
+		})
+		scopes, err := grantScopes(req.Scope, granted)
+		if err != nil {
+			return err
+		}
+		if err := repos.OAuth.UseRefreshToken(ctx, token.ID); err != nil {
+			return err
+		}
+		resp, err = s.issue(ctx, repos.OAuth, client, scopes, token.FamilyID)
+		return err
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if reused {
+		return nil, errInvalidRefreshToken
+	}
+	return resp, nil
+}
+
+// issue signs an access token and, if the client may refresh it, stores a
+// refresh token in the given family, or a new one.
+func (s *tokenService) issue(ctx context.Context, repo repository.OAuthRepository, client *model.OAuthClient, scopes []string, familyID string) (*model.TokenResponse, error) {
+	now := time.Now()
+	scope := strings.Join(scopes, " ")
+	accessToken, err := s.sign(now, client.ID, client.ID, scope, nil)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	resp := &model.TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   model.TokenTypeBearer,
+		ExpiresIn:   int64(s.config.AccessTokenTTL / time.Second),
+		Scope:       scope,
+	}
+	if !slices.Contains(client.GrantTypes, model.GrantRefreshToken) {
+		return resp, nil
+	}
+
+	secret, err := randomToken(refreshTokenBytes)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	refreshToken := &model.RefreshToken{
+		ClientID:  client.ID,
+		FamilyID:  familyID,
+		Scopes:    scopes,
+		TokenHash: hashSecret(secret),
+		ExpiresAt: now.Add(s.config.RefreshTokenTTL),
+	}
+	if err := repo.CreateRefreshToken(ctx, refreshToken); err != nil {
+		return nil, translateError(err)
+	}
+	resp.RefreshToken = secret
+	return resp, nil
+}
+
+// IssueUserToken issues an access token whose subject is the user, who
+// logged in with the authentication methods of RFC 8176. It has no scopes:
+// it only grants what users may do to themselves, such as changing their
+// password. There is no refresh token; users log in again.
+func (s *tokenService) IssueUserToken(ctx context.Context, user *model.User, methods []string) (*model.TokenResponse, error) {
+	accessToken, err := s.sign(time.Now(), user.UUID, "", "", methods)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &model.TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   model.TokenTypeBearer,
+		ExpiresIn:   int64(s.config.AccessTokenTTL / time.Second),
+	}, nil
+}
+
+// sign returns an RFC 9068 access token. clientID is empty for user tokens,
+// and amr for client tokens.
+func (s *tokenService) sign(now time.Time, subject, clientID, scope string, amr []string) (string, error) {
+	jti, err := randomToken(tokenIDBytes)
+	if err != nil {
+		return "", err
+	}
+	return s.keys.Sign(&auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.config.Issuer,
+			Subject:   subject,
+			Audience:  jwt.ClaimStrings{s.config.Issuer},
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.config.AccessTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        jti,
+		},
+		Scope:    scope,
+		ClientID: clientID,
+		AMR:      amr,
+	}, accessTokenType)
+}
+
+// Introspect describes token to an authenticated client. Access tokens are
+// active while they verify and their client is not revoked, or for user
+// tokens, their user is not deleted; refresh tokens are only described to
+// the client they were issued to.
+func (s *tokenService) Introspect(ctx context.Context, creds model.ClientCredentials, token string) (*model.Introspection, error) {
+	caller, err := s.authenticate(ctx, creds)
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		return nil, &OAuthError{Code: OAuthInvalidRequest, Description: "token is required"}
+	}
+	inactive := &model.Introspection{}
+
+	// Refresh tokens are base64url and never contain the dots of a JWT.
+	if strings.Count(token, ".") == 2 {
+		claims, err := s.verifier.Verify(ctx, token)
+		if err != nil {
+			return inactive, nil
+		}
+		active, err := s.tokenHolderActive(ctx, claims)
+		if err != nil || !active {
+			return inactive, err
+		}
+		info := &model.Introspection{
+			Active:    true,
+			Scope:     claims.Scope,
+			ClientID:  claims.ClientID,
+			TokenType: model.TokenTypeBearer,
+			Subject:   claims.Subject,
+			Issuer:    claims.Issuer,
+			ID:        claims.ID,
+		}
+		if len(claims.Audience) > 0 {
+			info.Audience = claims.Audience[0]
+		}
+		if claims.ExpiresAt != nil {
+			info.ExpiresAt = claims.ExpiresAt.Unix()
+		}
+		if claims.IssuedAt != nil {
+			info.IssuedAt = claims.IssuedAt.Unix()
+		}
+		return info, nil
+	}
+
+	refreshToken, err := s.repo.GetRefreshToken(ctx, hashSecret(token), false)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if refreshToken == nil || refreshToken.ClientID != caller.ID || refreshToken.UsedAt != nil ||
+		refreshToken.RevokedAt != nil || !refreshToken.ExpiresAt.After(time.Now()) {
+		return inactive, nil
+	}
+	return &model.Introspection{
+		Active:    true,
+		Scope:     strings.Join(refreshToken.Scopes, " "),
+		ClientID:  refreshToken.ClientID,
+		Subject:   refreshToken.ClientID,
+		ExpiresAt: refreshToken.ExpiresAt.Unix(),
+		IssuedAt:  refreshToken.CreatedAt.Unix(),
+	}, nil
+}
+
+// tokenHolderActive reports whether the client, or for user tokens the
+// user, that an access token was issued to still exists and is not revoked
+// or deleted.
+func (s *tokenService) tokenHolderActive(ctx context.Context, claims *auth.Claims) (bool, error) {
+	if claims.ClientID == "" {
+		if !isUUID(claims.Subject) {
+			return false, nil
+		}
+		user, err := s.repos.Users.GetByUUID(ctx, claims.Subject, false)
+		return user != nil, translateError(err)
+	}
+	if !isUUID(claims.ClientID) {
+		return false, nil
+	}
+	client, err := s.repo.GetClient(ctx, claims.ClientID)
+	return client != nil && client.RevokedAt == nil, translateError(err)
+}
+
+func (s *tokenService) JWKS() []byte {
+	return s.keys.JWKS()
+}
+
+// authenticate returns the client identified by creds, failing with
+// invalid_client if the id or secret is wrong or the client is revoked.
+func (s *tokenService) authenticate(ctx context.Context, creds model.ClientCredentials) (*model.OAuthClient, error) {
+	if creds.ID == "" || creds.Secret == "" {
+		return nil, &OAuthError{Code: OAuthInvalidClient, Description: "client authentication is required"}
+	}
+	if !isUUID(creds.ID) {
+		return nil, errInvalidClient
+	}
+	client, err := s.repo.GetClient(ctx, creds.ID)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if client == nil || subtle.ConstantTimeCompare(hashSecret(creds.Secret), client.SecretHash) != 1 ||
+		client.RevokedAt != nil {
+		return nil, errInvalidClient
+	}
+	return client, nil
+}
+
+// grantScopes returns the scopes of a space separated scope parameter, or
+// all allowed scopes if it is empty. Every requested scope must be allowed.
+func grantScopes(requested string, allowed []string) ([]string, error) {
+	scopes := strings.Fields(requested)
+	if len(scopes) == 0 {
+		scopes = allowed
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(model.Scopes, scope) || !model.HasScope(allowed, scope) {
+			return nil, &OAuthError{Code: OAuthInvalidScope, Description: "scope " + scope + " is not granted to the client"}
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, &OAuthError{Code: OAuthInvalidScope, Description: "no scopes are granted to the client"}
+	}
+	return slices.Compact(slices.Sorted(slices.Values(scopes))), nil
+}
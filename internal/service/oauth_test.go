@@ -0,0 +1,53 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"cruder/internal/model"
+)
+
+func TestGrantScopes(t *testing.T) {
+	tests := []struct {
+		requested string
+		allowed   []string
+		want      string
+		code      string
+	}{
+		{"", []string{"users:write", "users:read"}, "users:read users:write", ""},
+		{"users:read", []string{"users:read", "users:write"}, "users:read", ""},
+		{" users:read  users:read ", []string{"users:read"}, "users:read", ""},
+		{"users:write", []string{"admin"}, "users:write", ""},
+		{"users:write", []string{"users:read"}, "", OAuthInvalidScope},
+		{"root", []string{"admin"}, "", OAuthInvalidScope},
+		{"", nil, "", OAuthInvalidScope},
+	}
+	for _, tt := range tests {
+		scopes, err := grantScopes(tt.requested, tt.allowed)
+		var oauthErr *OAuthError
+		switch {
+		case tt.code != "" && (!errors.As(err, &oauthErr) || oauthErr.Code != tt.code):
+			t.Errorf("grantScopes(%q, %v): expected %s, got %v", tt.requested, tt.allowed, tt.code, err)
+		case tt.code == "" && (err != nil || strings.Join(scopes, " ") != tt.want):
+			t.Errorf("grantScopes(%q, %v) = %v, %v; want %q", tt.requested, tt.allowed, scopes, err, tt.want)
+		}
+	}
+}
+
+func TestValidateOAuthClient(t *testing.T) {
+	client := &model.OAuthClient{Name: "billing", Owner: "payments", Scopes: []string{"users:read"}}
+	if err := validateOAuthClient(client); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(client.GrantTypes, ",") != model.GrantClientCredentials {
+		t.Errorf("expected client_credentials by default, got %v", client.GrantTypes)
+	}
+
+	client = &model.OAuthClient{Name: "billing", Owner: "payments", Scopes: []string{"users:read"},
+		GrantTypes: []string{model.GrantRefreshToken, "password"}}
+	var validationErr *ValidationError
+	if err := validateOAuthClient(client); !errors.As(err, &validationErr) || len(validationErr.Errors) != 2 {
+		t.Fatalf("expected two grant_types errors, got %v", err)
+	}
+}
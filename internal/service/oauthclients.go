@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"cruder/internal/model"
+	"cruder/internal/repository"
+	"cruder/pkg/validation"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+const oauthClientSecretBytes = 32
+
+type OAuthClientService interface {
+	Create(ctx context.Context, client *model.OAuthClient) (string, error)
+	Get(ctx context.Context, id string) (*model.OAuthClient, error)
+	List(ctx context.Context, includeRevoked bool) (*model.OAuthClientList, error)
+	Revoke(ctx context.Context, id string) error
+}
+
+// Client secrets, like API keys, are random enough to be stored as a plain
+// SHA-256 hash.
+type oauthClientService struct {
+	repo repository.OAuthRepository
+}
+
+func NewOAuthClientService(repos *repository.Repository) OAuthClientService {
+	return &oauthClientService{repo: repos.OAuth}
+}
+
+var errOAuthClientNotFound = &NotFoundError{Resource: "OAuth client"}
+
+// Create registers a client with the name, owner, scopes and grant types of
+// client and returns its secret, which cannot be retrieved later.
+func (s *oauthClientService) Create(ctx context.Context, client *model.OAuthClient) (string, error) {
+	if err := validateOAuthClient(client); err != nil {
+		return "", err
+	}
+	secret, err := randomToken(oauthClientSecretBytes)
+	if err != nil {
+		return "", translateError(err)
+	}
+	client.SecretHash = hashSecret(secret)
+	if err := s.repo.CreateClient(ctx, client); err != nil {
+		return "", translateError(err)
+	}
+	return secret, nil
+}
+
+func (s *oauthClientService) Get(ctx context.Context, id string) (*model.OAuthClient, error) {
+	if !isUUID(id) {
+		return nil, errOAuthClientNotFound
+	}
+	client, err := s.repo.GetClient(ctx, id)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if client == nil {
+		return nil, errOAuthClientNotFound
+	}
+	return client, nil
+}
+
+func (s *oauthClientService) List(ctx context.Context, includeRevoked bool) (*model.OAuthClientList, error) {
+	clients, err := s.repo.ListClients(ctx, includeRevoked)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &model.OAuthClientList{Items: clients}, nil
+}
+
+// Revoke disables a client and its refresh tokens. Access tokens already
+// issued stay valid until they expire, but introspection reports them as
+// inactive.
+func (s *oauthClientService) Revoke(ctx context.Context, id string) error {
+	if !isUUID(id) {
+		return errOAuthClientNotFound
+	}
+	found, err := s.repo.RevokeClient(ctx, id)
+	if err != nil {
+		return translateError(err)
+	}
+	if !found {
+		return errOAuthClientNotFound
+	}
+	return nil
+}
+
+func validateOAuthClient(client *model.OAuthClient) error {
+	var errs validation.Errors
+	client.Name = strings.TrimSpace(client.Name)
+	switch {
+	case client.Name == "":
+		errs.Add("name", "is required")
+	case utf8.RuneCountInString(client.Name) > apiKeyNameMaxLen:
+		errs.Add("name", "must be at most 100 characters")
+	}
+	client.Owner = strings.TrimSpace(client.Owner)
+	switch {
+	case client.Owner == "":
+		errs.Add("owner", "is required")
+	case utf8.RuneCountInString(client.Owner) > apiKeyOwnerMaxLen:
+		errs.Add("owner", "must be at most 255 characters")
+	}
+	if len(client.Scopes) == 0 {
+		errs.Add("scopes", "is required")
+	}
+	for _, scope := range client.Scopes {
+		if !slices.Contains(model.Scopes, scope) {
+			errs.Add("scopes", "unknown scope "+scope)
+		}
+	}
+	if len(client.GrantTypes) == 0 {
+		client.GrantTypes = []string{model.GrantClientCredentials}
+	}
+	for _, grant := range client.GrantTypes {
+		if !slices.Contains(model.GrantTypes, grant) {
+			errs.Add("grant_types", "unknown grant type "+grant)
+		}
+	}
+	// Refresh tokens are only handed out with client credentials tokens.
+	if !slices.Contains(client.GrantTypes, model.GrantClientCredentials) {
+		errs.Add("grant_types", "must include "+model.GrantClientCredentials)
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	client.Scopes = slices.Compact(slices.Sorted(slices.Values(client.Scopes)))
+	client.GrantTypes = slices.Compact(slices.Sorted(slices.Values(client.GrantTypes)))
+	return nil
+}
+
+// randomToken returns n random bytes, base64url encoded.
+func randomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashSecret(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
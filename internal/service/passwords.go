@@ -0,0 +1,221 @@
+package service
+
+import (
+	"context"
+	"cruder/internal/auth"
+	"cruder/internal/mail"
+	"cruder/internal/model"
+	"cruder/internal/repository"
+	"cruder/pkg/validation"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// maxFailedLogins wrong passwords in a row lock a credential for
+	// loginLockout.
+	maxFailedLogins = 5
+	loginLockout    = 15 * time.Minute
+
+	// resetMailTimeout bounds sending a reset email, which happens after
+	// the request has been answered.
+	resetMailTimeout = time.Minute
+)
+
+type PasswordService interface {
+	Authenticate(ctx context.Context, username, password string) (*model.User, error)
+	ChangePassword(ctx context.Context, uuid, current, next string) error
+	RequestReset(ctx context.Context, email string) error
+	Reset(ctx context.Context, token, next string) error
+}
+
+// Passwords are stored as argon2id hashes in user_credentials. Hashes made
+// with other parameters than the hasher's are replaced on the next login.
+type passwordService struct {
+	repos  *repository.Repository
+	hasher *auth.PasswordHasher
+	mail   MailConfig
+
+	// dummyHash is verified when there is no credential to check, so that
+	// failed logins take the same time whether or not the user exists.
+	dummyOnce sync.Once
+	dummyHash string
+}
+
+func NewPasswordService(repos *repository.Repository, hasher *auth.PasswordHasher, mail MailConfig) PasswordService {
+	return &passwordService{repos: repos, hasher: hasher, mail: mail.withDefaults()}
+}
+
+var errInvalidLogin = &UnauthorizedError{Message: "invalid username or password"}
+
+// Authenticate returns the live user with the given username and password.
+// Unknown users, wrong passwords and locked credentials fail alike.
+func (s *passwordService) Authenticate(ctx context.Context, username, password string) (*model.User, error) {
+	if username == "" || password == "" || len(password) > validation.PasswordMaxLength {
+		return nil, errInvalidLogin
+	}
+	user, err := s.repos.Users.GetByUsername(ctx, username, false)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	var cred *model.Credential
+	if user != nil {
+		if cred, err = s.repos.Credentials.Get(ctx, user.UUID, false); err != nil {
+			return nil, translateError(err)
+		}
+	}
+	if cred == nil {
+		_, _, err := s.hasher.Verify(ctx, password, s.dummy())
+		if err != nil {
+			return nil, translateError(err)
+		}
+		return nil, errInvalidLogin
+	}
+
+	ok, rehash, err := s.hasher.Verify(ctx, password, cred.PasswordHash)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	// The lock is checked after hashing so that it does not show in the
+	// response time either.
+	if cred.LockedUntil != nil && cred.LockedUntil.After(time.Now()) {
+		return nil, errInvalidLogin
+	}
+	if !ok {
+		if err := s.repos.Credentials.RecordFailure(ctx, user.UUID, maxFailedLogins, loginLockout); err != nil {
+			return nil, translateError(err)
+		}
+		return nil, errInvalidLogin
+	}
+
+	var newHash string
+	if rehash {
+		if newHash, err = s.hasher.Hash(ctx, password); err != nil {
+			return nil, translateError(err)
+		}
+	}
+	if err := s.repos.Credentials.RecordLogin(ctx, user.UUID, cred.PasswordHash, newHash); err != nil {
+		return nil, translateError(err)
+	}
+	return user, nil
+}
+
+// ChangePassword sets the password of a live user. Once a user has a
+// password, changing it requires the current one.
+func (s *passwordService) ChangePassword(ctx context.Context, uuid, current, next string) error {
+	if !isUUID(uuid) {
+		return errUserNotFound
+	}
+	user, err := s.repos.Users.GetByUUID(ctx, uuid, false)
+	if err != nil {
+		return translateError(err)
+	}
+	if user == nil {
+		return errUserNotFound
+	}
+	var errs validation.Errors
+	errs.Check("new_password", validation.Password(next, user.Username, user.Email))
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+
+	cred, err := s.repos.Credentials.Get(ctx, uuid, false)
+	if err != nil {
+		return translateError(err)
+	}
+	if cred != nil {
+		if current == "" {
+			return &ValidationError{Errors: []FieldError{{Field: "current_password", Message: "is required"}}}
+		}
+		if cred.LockedUntil != nil && cred.LockedUntil.After(time.Now()) {
+			return &ForbiddenError{Message: "too many wrong passwords, try again later"}
+		}
+		ok, _, err := s.hasher.Verify(ctx, current, cred.PasswordHash)
+		if err != nil {
+			return translateError(err)
+		}
+		if !ok {
+			if err := s.repos.Credentials.RecordFailure(ctx, uuid, maxFailedLogins, loginLockout); err != nil {
+				return translateError(err)
+			}
+			return &ForbiddenError{Message: "current password is incorrect"}
+		}
+	}
+
+	hash, err := s.hasher.Hash(ctx, next)
+	if err != nil {
+		return translateError(err)
+	}
+	return translateError(s.repos.Credentials.SetPassword(ctx, uuid, hash))
+}
+
+// RequestReset mails a password reset link to the live user with the given
+// email address. It succeeds whether or not there is such a user, and the
+// email is sent in the background, so that callers cannot tell.
+func (s *passwordService) RequestReset(ctx context.Context, email string) error {
+	var errs validation.Errors
+	errs.Check("email", validation.Email(email))
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	user, err := s.repos.Users.GetByEmail(ctx, email, false)
+	if err != nil || user == nil {
+		return translateError(err)
+	}
+	token, err := issueUserToken(ctx, s.repos.UserTokens, user, model.TokenPurposeResetPassword, s.mail.ResetTTL)
+	if err != nil || token == "" {
+		return translateError(err)
+	}
+
+	msg := &mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: "Hello " + user.Username + ",\n\n" +
+			"someone asked to reset your password. To choose a new one within " + formatTTL(s.mail.ResetTTL) + ", open:\n\n" +
+			tokenLink(s.mail.ResetPasswordURL, token) + "\n\n" +
+			"If you did not ask for this, you can ignore this email; your password stays the same.\n",
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), resetMailTimeout)
+		defer cancel()
+		if err := s.mail.Mailer.Send(ctx, msg); err != nil {
+			log.Printf("password reset for user %s: sending email: %v", user.UUID, err)
+		}
+	}()
+	return nil
+}
+
+// Reset sets a new password with a reset token. It does not need the
+// current password, and lifts a lockout.
+func (s *passwordService) Reset(ctx context.Context, token, next string) error {
+	err := s.repos.WithTx(ctx, func(repos *repository.Repository) error {
+		user, _, err := useUserToken(ctx, repos, token, model.TokenPurposeResetPassword)
+		if err != nil {
+			return err
+		}
+		var errs validation.Errors
+		errs.Check("new_password", validation.Password(next, user.Username, user.Email))
+		if len(errs) > 0 {
+			return &ValidationError{Errors: errs}
+		}
+		hash, err := s.hasher.Hash(ctx, next)
+		if err != nil {
+			return err
+		}
+		return repos.Credentials.SetPassword(actingAs(ctx, user.UUID), user.UUID, hash)
+	})
+	return translateError(err)
+}
+
+// dummy returns a hash of a random password made with the current
+// parameters. It is computed on first use to keep startup fast.
+func (s *passwordService) dummy() string {
+	s.dummyOnce.Do(func() {
+		// randomToken and Hash only fail if the context ends or the system
+		// has no randomness; logins then fail with an internal error.
+		password, _ := randomToken(16)
+		s.dummyHash, _ = s.hasher.Hash(context.Background(), password)
+	})
+	return s.dummyHash
+}
@@ -1,13 +1,43 @@
 package service
 
-import "cruder/internal/repository"
+import (
+	"cruder/internal/auth"
+	"cruder/internal/mail"
+	"cruder/internal/repository"
+	"os"
+)
+
+// DefaultMailFrom is the sender of emails unless configured otherwise.
+const DefaultMailFrom = "no-reply@localhost"
 
 type Service struct {
-	Users UserService
+	Users        UserService
+	Imports      ImportService
+	APIKeys      APIKeyService
+	OAuthClients OAuthClientService
+
+	// Unless replaced, Passwords hashes with auth.DefaultArgon2Params, and
+	// it and EmailVerification write their emails to stdout.
+	Passwords         PasswordService
+	EmailVerification EmailVerificationService
+
+	// Unless replaced, MFA has no encryption keys, so users cannot enroll.
+	MFA MFAService
+
+	// Tokens is nil unless the token endpoint has been configured with
+	// signing keys.
+	Tokens TokenService
 }
 
 func NewService(repos *repository.Repository) *Service {
+	mailConfig := MailConfig{Mailer: mail.NewWriterMailer(os.Stdout, DefaultMailFrom)}
 	return &Service{
-		Users: NewUserService(repos.Users),
+		Users:             NewUserService(repos),
+		Imports:           NewImportService(repos),
+		APIKeys:           NewAPIKeyService(repos),
+		OAuthClients:      NewOAuthClientService(repos),
+		Passwords:         NewPasswordService(repos, auth.NewPasswordHasher(auth.DefaultArgon2Params), mailConfig),
+		EmailVerification: NewEmailVerificationService(repos, mailConfig),
+		MFA:               NewMFAService(repos, nil, DefaultMFAIssuer),
 	}
 }
@@ -1,93 +1,320 @@
 package service
 
 import (
+	"context"
 	"cruder/internal/model"
 	"cruder/internal/repository"
+	"cruder/pkg/validation"
 	"database/sql"
 	"errors"
+	"strings"
+	"time"
 )
 
-var ErrUniqueConstraint = repository.ErrUniqueConstraint
+var (
+	ErrInvalidCursor    = repository.ErrInvalidCursor
+	ErrEmptySearchQuery = errors.New("search query must not be empty")
+)
+
+// ParseError is returned by GetAll for malformed filter or sort expressions.
+type ParseError = repository.ParseError
 
 type UserService interface {
-	GetAll() ([]model.User, error)
-	GetByUsername(username string) (*model.User, error)
-	GetByID(id int64) (*model.User, error)
-	GetByUUID(uuid string) (*model.User, error)
-	Create(user *model.User) (*model.User, error)
-	Update(uuid string, user *model.User) (*model.User, error)
-	Delete(uuid string) error
+	GetAll(ctx context.Context, params model.ListParams) (*model.UserList, error)
+	Export(ctx context.Context, params model.ListParams, fn func(*model.User) error) error
+	Search(ctx context.Context, query string, limit int, includeDeleted bool) (*model.UserSearchResults, error)
+	GetByUsername(ctx context.Context, username string, includeDeleted bool) (*model.User, error)
+	GetByID(ctx context.Context, id int64, includeDeleted bool) (*model.User, error)
+	GetByUUID(ctx context.Context, uuid string, includeDeleted bool) (*model.User, error)
+	Create(ctx context.Context, user *model.User) (*model.User, error)
+	Update(ctx context.Context, uuid string, user *model.User, expectedVersion int) (*model.User, error)
+	UpsertByExternalID(ctx context.Context, source, externalID string, user *model.User, expectedVersion int) (*model.User, bool, error)
+	Patch(ctx context.Context, uuid string, patch *model.UserPatch, expectedVersion int) (*model.User, error)
+	JSONPatch(ctx context.Context, uuid string, ops []model.JSONPatchOperation, expectedVersion int) (*model.User, error)
+	Delete(ctx context.Context, uuid string, expectedVersion int) error
+	Restore(ctx context.Context, uuid string, expectedVersion int) (*model.User, error)
+	Purge(ctx context.Context, uuid string, expectedVersion int) error
+	PurgeDeleted(ctx context.Context, retention time.Duration) (int64, error)
+	History(ctx context.Context, uuid string, limit int, after string) (*model.UserAuditList, error)
+	Batch(ctx context.Context, ops []model.BatchOperation, atomic bool) ([]BatchResult, error)
 }
 
+// Mutations take an expectedVersion (from If-Match); zero skips the check.
 type userService struct {
-	repo repository.UserRepository
+	repos *repository.Repository
+	repo  repository.UserRepository
+	audit repository.AuditRepository
+}
+
+func NewUserService(repos *repository.Repository) UserService {
+	return &userService{repos: repos, repo: repos.Users, audit: repos.Audit}
 }
 
-func NewUserService(repo repository.UserRepository) UserService {
-	return &userService{repo: repo}
+func (s *userService) GetAll(ctx context.Context, params model.ListParams) (*model.UserList, error) {
+	if params.Limit <= 0 {
+		params.Limit = model.DefaultPageLimit
+	}
+	if params.Limit > model.MaxPageLimit {
+		params.Limit = model.MaxPageLimit
+	}
+	if params.Offset < 0 {
+		params.Offset = 0
+	}
+	users, err := s.repo.GetAll(ctx, params)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return users, nil
 }
 
-func (s *userService) GetAll() ([]model.User, error) {
-	return s.repo.GetAll()
+// Export streams every user matching params to fn. Errors returned by fn
+// are passed through unchanged.
+func (s *userService) Export(ctx context.Context, params model.ListParams, fn func(*model.User) error) error {
+	var fnErr error
+	err := s.repo.Export(ctx, params, func(u *model.User) error {
+		fnErr = fn(u)
+		return fnErr
+	})
+	if err != nil && err == fnErr {
+		return err
+	}
+	return translateError(err)
 }
 
-func (s *userService) GetByUsername(username string) (*model.User, error) {
-	user, err := s.repo.GetByUsername(username)
+func (s *userService) Search(ctx context.Context, query string, limit int, includeDeleted bool) (*model.UserSearchResults, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, ErrEmptySearchQuery
+	}
+	if limit <= 0 {
+		limit = model.DefaultSearchLimit
+	}
+	if limit > model.MaxSearchLimit {
+		limit = model.MaxSearchLimit
+	}
+
+	results, err := s.repo.Search(ctx, query, limit, includeDeleted)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &model.UserSearchResults{Items: results}, nil
+}
+
+func (s *userService) GetByUsername(ctx context.Context, username string, includeDeleted bool) (*model.User, error) {
+	user, err := s.repo.GetByUsername(ctx, username, includeDeleted)
 	return s.ensureUserExists(user, err)
 }
 
-func (s *userService) GetByID(id int64) (*model.User, error) {
-	user, err := s.repo.GetByID(id)
+func (s *userService) GetByID(ctx context.Context, id int64, includeDeleted bool) (*model.User, error) {
+	user, err := s.repo.GetByID(ctx, id, includeDeleted)
 	return s.ensureUserExists(user, err)
 }
 
-func (s *userService) GetByUUID(uuid string) (*model.User, error) {
-	user, err := s.repo.GetByUUID(uuid)
+func (s *userService) GetByUUID(ctx context.Context, uuid string, includeDeleted bool) (*model.User, error) {
+	user, err := s.repo.GetByUUID(ctx, uuid, includeDeleted)
 	return s.ensureUserExists(user, err)
 }
 
-func (s *userService) Create(user *model.User) (*model.User, error) {
-	createdUser, err := s.repo.Create(user)
-	if err != nil {
-		if errors.Is(err, repository.ErrUniqueConstraint) {
-			return nil, ErrUniqueConstraint
-		}
+func (s *userService) Create(ctx context.Context, user *model.User) (*model.User, error) {
+	if err := validateUser(user); err != nil {
 		return nil, err
 	}
+
+	createdUser, err := s.repo.Create(ctx, user)
+	if err != nil {
+		return nil, translateError(err)
+	}
 	return createdUser, nil
 }
 
-func (s *userService) Update(uuid string, user *model.User) (*model.User, error) {
-	updatedUser, err := s.repo.Update(uuid, user)
-	if err != nil {
-		if errors.Is(err, repository.ErrUniqueConstraint) {
-			return nil, ErrUniqueConstraint
-		}
+func (s *userService) Update(ctx context.Context, uuid string, user *model.User, expectedVersion int) (*model.User, error) {
+	if err := validateUser(user); err != nil {
 		return nil, err
 	}
+
+	return updateUser(ctx, s.repo, uuid, user, expectedVersion)
+}
+
+// UpsertByExternalID creates or replaces the user linked to externalID of
+// source and reports whether it was created.
+func (s *userService) UpsertByExternalID(ctx context.Context, source, externalID string, user *model.User, expectedVersion int) (*model.User, bool, error) {
+	var errs validation.Errors
+	errs.Check("source", validation.ExternalSource(source))
+	errs.Check("external_id", validation.ExternalID(externalID))
+	if err := validateUser(user); err != nil {
+		var validationErr *ValidationError
+		errors.As(err, &validationErr)
+		errs = append(errs, validationErr.Errors...)
+	}
+	if len(errs) > 0 {
+		return nil, false, &ValidationError{Errors: errs}
+	}
+
+	upserted, created, err := s.repo.UpsertByExternalID(ctx, source, externalID, user, expectedVersion)
+	if err != nil {
+		return nil, false, translateError(err)
+	}
+	return upserted, created, nil
+}
+
+// updateUser replaces a validated user through repo.
+func updateUser(ctx context.Context, repo repository.UserRepository, uuid string, user *model.User, expectedVersion int) (*model.User, error) {
+	updatedUser, err := repo.Update(ctx, uuid, user, expectedVersion)
+	if err != nil {
+		return nil, translateError(err)
+	}
 	if updatedUser == nil {
-		return nil, errors.New("user is not found")
+		return nil, errUserNotFound
 	}
 	return updatedUser, nil
 }
 
-func (s *userService) Delete(uuid string) error {
-	err := s.repo.Delete(uuid)
+func (s *userService) Patch(ctx context.Context, uuid string, patch *model.UserPatch, expectedVersion int) (*model.User, error) {
+	if err := validatePatch(patch); err != nil {
+		return nil, err
+	}
+
+	patchedUser, err := s.repo.Patch(ctx, uuid, patch, expectedVersion)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if patchedUser == nil {
+		return nil, errUserNotFound
+	}
+	return patchedUser, nil
+}
+
+func (s *userService) Delete(ctx context.Context, uuid string, expectedVersion int) error {
+	return deleteUser(ctx, s.repo, uuid, expectedVersion)
+}
+
+func deleteUser(ctx context.Context, repo repository.UserRepository, uuid string, expectedVersion int) error {
+	err := repo.Delete(ctx, uuid, expectedVersion)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return errors.New("user is not found")
+		if errors.Is(err, sql.ErrNoRows) {
+			return errUserNotFound
 		}
-		return err
+		return translateError(err)
 	}
 	return nil
 }
 
+func (s *userService) Restore(ctx context.Context, uuid string, expectedVersion int) (*model.User, error) {
+	restoredUser, err := s.repo.Restore(ctx, uuid, expectedVersion)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotDeleted) {
+			return nil, &ConflictError{Message: err.Error()}
+		}
+		return nil, translateError(err)
+	}
+	if restoredUser == nil {
+		return nil, errUserNotFound
+	}
+	return restoredUser, nil
+}
+
+func (s *userService) Purge(ctx context.Context, uuid string, expectedVersion int) error {
+	err := s.repo.Purge(ctx, uuid, expectedVersion)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errUserNotFound
+		}
+		return translateError(err)
+	}
+	return nil
+}
+
+// purgeBatchSize bounds how many users a single purge statement removes.
+const purgeBatchSize = 500
+
+// PurgeDeleted permanently removes users that have been soft-deleted for
+// longer than retention.
+func (s *userService) PurgeDeleted(ctx context.Context, retention time.Duration) (int64, error) {
+	n, err := s.repo.PurgeDeleted(ctx, retention, purgeBatchSize)
+	return n, translateError(err)
+}
+
+// History pages through the audit trail of a user, newest change first.
+// Purged users keep their history; a user that never existed is not found.
+func (s *userService) History(ctx context.Context, uuid string, limit int, after string) (*model.UserAuditList, error) {
+	if limit <= 0 {
+		limit = model.DefaultHistoryLimit
+	}
+	if limit > model.MaxHistoryLimit {
+		limit = model.MaxHistoryLimit
+	}
+
+	// Read the trail and the user from one snapshot so a user created in
+	// between is not reported as missing.
+	var history *model.UserAuditList
+	var user *model.User
+	err := s.repos.WithTx(ctx, func(repos *repository.Repository) error {
+		var err error
+		if history, err = repos.Audit.ListUserHistory(ctx, uuid, limit, after); err != nil {
+			return err
+		}
+		if len(history.Items) == 0 && after == "" {
+			user, err = repos.Users.GetByUUID(ctx, uuid, true)
+		}
+		return err
+	}, repository.WithIsolation(sql.LevelRepeatableRead), repository.ReadOnly())
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if len(history.Items) == 0 && after == "" && user == nil {
+		return nil, errUserNotFound
+	}
+	return history, nil
+}
+
 func (s *userService) ensureUserExists(user *model.User, err error) (*model.User, error) {
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 	if user == nil {
-		return nil, errors.New("user is not found")
+		return nil, errUserNotFound
 	}
 	return user, nil
 }
+
+// validateUser normalizes user in place and checks it against the business rules.
+func validateUser(user *model.User) error {
+	user.FullName = validation.NormalizeFullName(user.FullName)
+
+	var errs validation.Errors
+	errs.Check("username", validation.Username(user.Username))
+	errs.Check("email", validation.Email(user.Email))
+	errs.Check("full_name", validation.FullName(user.FullName))
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// validatePatch applies the user rules to the fields present in patch.
+// Username and email are required and therefore cannot be cleared.
+func validatePatch(patch *model.UserPatch) error {
+	var errs validation.Errors
+	if patch.Username.Set {
+		if patch.Username.Null {
+			errs.Add("username", "cannot be null")
+		} else {
+			errs.Check("username", validation.Username(patch.Username.Value))
+		}
+	}
+	if patch.Email.Set {
+		if patch.Email.Null {
+			errs.Add("email", "cannot be null")
+		} else {
+			errs.Check("email", validation.Email(patch.Email.Value))
+		}
+	}
+	if patch.FullName.Set && !patch.FullName.Null {
+		patch.FullName.Value = validation.NormalizeFullName(patch.FullName.Value)
+		errs.Check("full_name", validation.FullName(patch.FullName.Value))
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
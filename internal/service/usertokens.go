@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"cruder/internal/audit"
+	"cruder/internal/mail"
+	"cruder/internal/model"
+	"cruder/internal/repository"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	DefaultVerificationTTL = 48 * time.Hour
+	DefaultResetTTL        = time.Hour
+
+	// userTokenBytes is the randomness of mailed tokens.
+	userTokenBytes = 32
+
+	// mailResendInterval throttles the emails sent to one user for one
+	// flow, however often they are requested.
+	mailResendInterval = time.Minute
+)
+
+// MailConfig configures the emails of the email verification and password
+// reset flows.
+type MailConfig struct {
+	Mailer mail.Mailer
+
+	// VerifyEmailURL and ResetPasswordURL are the frontend pages that
+	// complete a flow; the token is added as the "token" query parameter.
+	// Without them, emails carry the bare token.
+	VerifyEmailURL   string
+	ResetPasswordURL string
+
+	VerificationTTL time.Duration
+	ResetTTL        time.Duration
+}
+
+func (c MailConfig) withDefaults() MailConfig {
+	if c.VerificationTTL <= 0 {
+		c.VerificationTTL = DefaultVerificationTTL
+	}
+	if c.ResetTTL <= 0 {
+		c.ResetTTL = DefaultResetTTL
+	}
+	return c
+}
+
+var errInvalidUserToken = &ValidationError{Errors: []FieldError{{Field: "token", Message: "is invalid, expired or already used"}}}
+
+// issueUserToken stores a token for purpose that is sent to the current
+// email of user and returns it. It returns "" if one was issued less than
+// mailResendInterval ago.
+func issueUserToken(ctx context.Context, tokens repository.UserTokenRepository, user *model.User, purpose string, ttl time.Duration) (string, error) {
+	recent, err := tokens.CreatedSince(ctx, user.UUID, purpose, time.Now().Add(-mailResendInterval))
+	if err != nil || recent {
+		return "", err
+	}
+	return newUserToken(ctx, tokens, user, purpose, ttl)
+}
+
+// newUserToken stores a token for purpose that is bound to the current
+// email of user and returns it.
+func newUserToken(ctx context.Context, tokens repository.UserTokenRepository, user *model.User, purpose string, ttl time.Duration) (string, error) {
+	secret, err := randomToken(userTokenBytes)
+	if err != nil {
+		return "", err
+	}
+	err = tokens.Create(ctx, &model.UserToken{
+		UserUUID:  user.UUID,
+		Purpose:   purpose,
+		Email:     user.Email,
+		TokenHash: hashSecret(secret),
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	return secret, err
+}
+
+// useUserToken returns the live user a valid token for purpose was issued
+// to, and marks the user's tokens for purpose as used. It must run in a
+// transaction. Tokens sent to an address the user no longer has are
+// invalid.
+func useUserToken(ctx context.Context, repos *repository.Repository, secret, purpose string) (*model.User, *model.UserToken, error) {
+	if secret == "" {
+		return nil, nil, &ValidationError{Errors: []FieldError{{Field: "token", Message: "is required"}}}
+	}
+	token, err := repos.UserTokens.Get(ctx, hashSecret(secret), true)
+	if err != nil {
+		return nil, nil, err
+	}
+	if token == nil || token.Purpose != purpose || token.UsedAt != nil || !token.ExpiresAt.After(time.Now()) {
+		return nil, nil, errInvalidUserToken
+	}
+	user, err := repos.Users.GetByUUID(ctx, token.UserUUID, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil || user.Email != token.Email {
+		return nil, nil, errInvalidUserToken
+	}
+	if err := repos.UserTokens.UseAll(ctx, user.UUID, purpose); err != nil {
+		return nil, nil, err
+	}
+	return user, token, nil
+}
+
+// actingAs attributes the changes made with a mailed token to its user.
+func actingAs(ctx context.Context, uuid string) context.Context {
+	source := audit.SourceFrom(ctx)
+	source.Actor = "user:" + uuid
+	return audit.WithSource(ctx, source)
+}
+
+// tokenLink returns the link that completes a flow, or the bare token
+// without a page to link to.
+func tokenLink(page, token string) string {
+	if page == "" {
+		return token
+	}
+	u, err := url.Parse(page)
+	if err != nil {
+		return token
+	}
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// formatTTL renders a token lifetime for an email, e.g. "48 hours".
+func formatTTL(d time.Duration) string {
+	n, unit := max(d/time.Minute, 1), "minute"
+	if d >= time.Hour && d%time.Hour == 0 {
+		n, unit = d/time.Hour, "hour"
+	}
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
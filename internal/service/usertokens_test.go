@@ -0,0 +1,36 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenLink(t *testing.T) {
+	tests := []struct {
+		page string
+		want string
+	}{
+		{"", "tok-en"},
+		{"https://app.example.com/reset", "https://app.example.com/reset?token=tok-en"},
+		{"https://app.example.com/verify?lang=de", "https://app.example.com/verify?lang=de&token=tok-en"},
+	}
+	for _, tt := range tests {
+		if got := tokenLink(tt.page, "tok-en"); got != tt.want {
+			t.Errorf("tokenLink(%q) = %q, want %q", tt.page, got, tt.want)
+		}
+	}
+}
+
+func TestFormatTTL(t *testing.T) {
+	tests := map[time.Duration]string{
+		time.Hour:        "1 hour",
+		48 * time.Hour:   "48 hours",
+		90 * time.Minute: "90 minutes",
+		time.Second:      "1 minute",
+	}
+	for ttl, want := range tests {
+		if got := formatTTL(ttl); got != want {
+			t.Errorf("formatTTL(%s) = %q, want %q", ttl, got, want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"cruder/internal/mail"
+	"cruder/internal/model"
+	"cruder/internal/repository"
+	"errors"
+)
+
+type EmailVerificationService interface {
+	Request(ctx context.Context, uuid string) error
+	Verify(ctx context.Context, token string) (*model.User, error)
+}
+
+// A user verifies their email address by presenting a token that was
+// mailed to it. Changing the address clears the verification and
+// invalidates the tokens sent to the old one.
+type emailVerificationService struct {
+	repos *repository.Repository
+	mail  MailConfig
+}
+
+func NewEmailVerificationService(repos *repository.Repository, mail MailConfig) EmailVerificationService {
+	return &emailVerificationService{repos: repos, mail: mail.withDefaults()}
+}
+
+// Request mails a verification link to the current address of a live user.
+// Requests within a minute of the last one send nothing.
+func (s *emailVerificationService) Request(ctx context.Context, uuid string) error {
+	if !isUUID(uuid) {
+		return errUserNotFound
+	}
+	user, err := s.repos.Users.GetByUUID(ctx, uuid, false)
+	if err != nil {
+		return translateError(err)
+	}
+	if user == nil {
+		return errUserNotFound
+	}
+	if user.EmailVerifiedAt != nil {
+		return &ConflictError{Field: "email", Message: "email is already verified"}
+	}
+
+	token, err := issueUserToken(ctx, s.repos.UserTokens, user, model.TokenPurposeVerifyEmail, s.mail.VerificationTTL)
+	if err != nil || token == "" {
+		return translateError(err)
+	}
+	return translateError(s.mail.Mailer.Send(ctx, &mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: "Hello " + user.Username + ",\n\n" +
+			"please confirm that this is your email address within " + formatTTL(s.mail.VerificationTTL) + ":\n\n" +
+			tokenLink(s.mail.VerifyEmailURL, token) + "\n\n" +
+			"If you did not expect this email, you can ignore it.\n",
+	}))
+}
+
+// Verify marks the email address a token was sent to as verified and
+// returns the user.
+func (s *emailVerificationService) Verify(ctx context.Context, token string) (*model.User, error) {
+	var verified *model.User
+	err := s.repos.WithTx(ctx, func(repos *repository.Repository) error {
+		user, issued, err := useUserToken(ctx, repos, token, model.TokenPurposeVerifyEmail)
+		if err != nil {
+			return err
+		}
+		verified, err = repos.Users.VerifyEmail(actingAs(ctx, user.UUID), user.UUID, issued.Email)
+		if errors.Is(err, repository.ErrEmailChanged) || (err == nil && verified == nil) {
+			return errInvalidUserToken
+		}
+		return err
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return verified, nil
+}
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE users ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', coalesce(full_name, '')), 'A') ||
+    setweight(to_tsvector('simple', username), 'A') ||
+    setweight(to_tsvector('simple', email), 'B')
+) STORED;
+
+ALTER TABLE users ADD COLUMN search_text TEXT GENERATED ALWAYS AS (
+    lower(username || ' ' || email || ' ' || coalesce(full_name, ''))
+) STORED;
+
+CREATE INDEX users_search_vector_idx ON users USING GIN (search_vector);
+CREATE INDEX users_search_text_trgm_idx ON users USING GIN (search_text gin_trgm_ops);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS users_search_text_trgm_idx;
+DROP INDEX IF EXISTS users_search_vector_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS search_text;
+ALTER TABLE users DROP COLUMN IF EXISTS search_vector;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE job_runs (
+    id BIGSERIAL PRIMARY KEY,
+    job_name VARCHAR(100) NOT NULL,
+    started_at TIMESTAMPTZ NOT NULL,
+    finished_at TIMESTAMPTZ NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    rows_affected BIGINT NOT NULL DEFAULT 0,
+    error TEXT
+);
+
+CREATE INDEX job_runs_job_name_started_at_idx ON job_runs(job_name, started_at DESC);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS job_runs;
+-- +goose StatementEnd
@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE user_audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    user_uuid UUID NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    request_id VARCHAR(128),
+    source_ip INET,
+    before JSONB,
+    after JSONB,
+    changes JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- No foreign key: the trail outlives purged users.
+CREATE INDEX user_audit_log_user_uuid_id_idx ON user_audit_log(user_uuid, id DESC);
+
+CREATE FUNCTION user_audit_log_immutable() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'user_audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER user_audit_log_immutable
+    BEFORE UPDATE OR DELETE ON user_audit_log
+    FOR EACH ROW EXECUTE FUNCTION user_audit_log_immutable();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS user_audit_log;
+DROP FUNCTION IF EXISTS user_audit_log_immutable();
+-- +goose StatementEnd
@@ -0,0 +1,31 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE user_import_jobs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    status VARCHAR(20) NOT NULL,
+    format VARCHAR(20) NOT NULL,
+    dry_run BOOLEAN NOT NULL,
+    processed_rows INTEGER NOT NULL DEFAULT 0,
+    succeeded_rows INTEGER NOT NULL DEFAULT 0,
+    failed_rows INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    finished_at TIMESTAMPTZ
+);
+
+CREATE TABLE user_import_errors (
+    id BIGSERIAL PRIMARY KEY,
+    job_id UUID NOT NULL REFERENCES user_import_jobs(id) ON DELETE CASCADE,
+    line INTEGER NOT NULL,
+    field VARCHAR(50) NOT NULL DEFAULT '',
+    message TEXT NOT NULL
+);
+
+CREATE INDEX user_import_errors_job_id_idx ON user_import_errors(job_id, line);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS user_import_errors;
+DROP TABLE IF EXISTS user_import_jobs;
+-- +goose StatementEnd
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE api_keys
+    ADD COLUMN revoked_at TIMESTAMPTZ,
+    ADD COLUMN replaced_by UUID REFERENCES api_keys(id),
+    ADD COLUMN last_used_ip INET;
+
+CREATE TABLE api_key_events (
+    id BIGSERIAL PRIMARY KEY,
+    key_id UUID REFERENCES api_keys(id) ON DELETE CASCADE,
+    prefix VARCHAR(16) NOT NULL DEFAULT '',
+    event VARCHAR(20) NOT NULL,
+    reason VARCHAR(20) NOT NULL DEFAULT '',
+    source_ip INET,
+    user_agent TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX api_key_events_key_id_idx ON api_key_events(key_id, id);
+CREATE INDEX api_key_events_created_at_idx ON api_key_events(created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS api_key_events;
+ALTER TABLE api_keys
+    DROP COLUMN IF EXISTS last_used_ip,
+    DROP COLUMN IF EXISTS replaced_by,
+    DROP COLUMN IF EXISTS revoked_at;
+-- +goose StatementEnd
@@ -0,0 +1,40 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMPTZ;
+
+-- A new address is unverified, however it was changed.
+CREATE FUNCTION users_reset_email_verification() RETURNS trigger AS $$
+BEGIN
+    IF NEW.email IS DISTINCT FROM OLD.email THEN
+        NEW.email_verified_at := NULL;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_reset_email_verification
+    BEFORE UPDATE OF email ON users
+    FOR EACH ROW EXECUTE FUNCTION users_reset_email_verification();
+
+CREATE TABLE user_tokens (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_uuid UUID NOT NULL REFERENCES users(uuid) ON DELETE CASCADE,
+    purpose VARCHAR(20) NOT NULL,
+    email VARCHAR(100) NOT NULL,
+    token_hash BYTEA NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ
+);
+
+CREATE INDEX user_tokens_user_uuid_purpose_idx ON user_tokens(user_uuid, purpose, created_at DESC);
+CREATE INDEX user_tokens_expires_at_idx ON user_tokens(expires_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS user_tokens;
+DROP TRIGGER IF EXISTS users_reset_email_verification ON users;
+DROP FUNCTION IF EXISTS users_reset_email_verification();
+ALTER TABLE users DROP COLUMN IF EXISTS email_verified_at;
+-- +goose StatementEnd
@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+-- A user's TOTP factor. It is pending until enabled_at is set by the first
+-- valid code; the secret is encrypted by the application.
+CREATE TABLE user_mfa (
+    user_uuid UUID PRIMARY KEY REFERENCES users(uuid) ON DELETE CASCADE,
+    secret_ciphertext BYTEA NOT NULL,
+    enabled_at TIMESTAMPTZ,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    failed_attempts INTEGER NOT NULL DEFAULT 0,
+    locked_until TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE user_recovery_codes (
+    id BIGSERIAL PRIMARY KEY,
+    user_uuid UUID NOT NULL REFERENCES user_mfa(user_uuid) ON DELETE CASCADE,
+    code_hash BYTEA NOT NULL,
+    used_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX user_recovery_codes_user_uuid_code_hash_idx ON user_recovery_codes(user_uuid, code_hash);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS user_recovery_codes;
+DROP TABLE IF EXISTS user_mfa;
+-- +goose StatementEnd
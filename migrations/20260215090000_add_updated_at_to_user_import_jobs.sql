@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Running jobs touch updated_at with every batch, so that jobs left behind
+-- by a crashed process can be told from live ones.
+ALTER TABLE user_import_jobs ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE user_import_jobs DROP COLUMN IF EXISTS updated_at;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Each reservation of a key gets a new id, so that a request whose
+-- reservation was taken over cannot complete or release its successor's.
+ALTER TABLE idempotency_keys ADD COLUMN reservation_id UUID NOT NULL DEFAULT gen_random_uuid();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS reservation_id;
+-- +goose StatementEnd
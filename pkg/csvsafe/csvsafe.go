@@ -0,0 +1,36 @@
+// Package csvsafe keeps user-provided CSV cells from being run as formulas
+// when a file is opened in a spreadsheet (CSV injection).
+package csvsafe
+
+// triggers start cells that spreadsheets evaluate. The quote is included
+// so that escaping can be undone exactly.
+const triggers = "=+-@\t\r'"
+
+// Escape prefixes a cell that starts with a formula character with a
+// single quote, which spreadsheets show as text.
+func Escape(cell string) string {
+	if needsEscape(cell) {
+		return "'" + cell
+	}
+	return cell
+}
+
+// Unescape undoes Escape, so that escaped files can be read back.
+func Unescape(cell string) string {
+	if len(cell) > 1 && cell[0] == '\'' && needsEscape(cell[1:]) {
+		return cell[1:]
+	}
+	return cell
+}
+
+func needsEscape(cell string) bool {
+	if cell == "" {
+		return false
+	}
+	for i := 0; i < len(triggers); i++ {
+		if cell[0] == triggers[i] {
+			return true
+		}
+	}
+	return false
+}
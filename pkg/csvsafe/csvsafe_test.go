@@ -0,0 +1,35 @@
+package csvsafe
+
+import "testing"
+
+func TestEscape(t *testing.T) {
+	tests := map[string]string{
+		"":                   "",
+		"John Doe":           "John Doe",
+		"=HYPERLINK(\"x\")":  "'=HYPERLINK(\"x\")",
+		"+1 555":             "'+1 555",
+		"-2":                 "'-2",
+		"@SUM(A1)":           "'@SUM(A1)",
+		"\tcmd":              "'\tcmd",
+		"'quoted":            "''quoted",
+		"o'neil@example.com": "o'neil@example.com",
+	}
+	for cell, want := range tests {
+		got := Escape(cell)
+		if got != want {
+			t.Errorf("Escape(%q) = %q, want %q", cell, got, want)
+		}
+		if back := Unescape(got); back != cell {
+			t.Errorf("Unescape(%q) = %q, want %q", got, back, cell)
+		}
+	}
+}
+
+func TestUnescapeUnescaped(t *testing.T) {
+	// Cells written by other tools are kept unless they look escaped.
+	for _, cell := range []string{"'", "'text", "John"} {
+		if got := Unescape(cell); got != cell {
+			t.Errorf("Unescape(%q) = %q, want it unchanged", cell, got)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// Package validation implements business validation rules for user input.
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	UsernameMinLength = 3
+	UsernameMaxLength = 50
+	EmailMaxLength    = 100
+	FullNameMaxLength = 100
+
+	ExternalSourceMaxLength = 50
+	ExternalIDMaxLength     = 255
+
+	// PasswordMinLength counts characters; PasswordMaxLength counts bytes,
+	// which bounds the work of hashing a password.
+	PasswordMinLength = 12
+	PasswordMaxLength = 128
+)
+
+// FieldError describes why a single field is invalid.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors collects field errors. A nil or empty Errors means the input is valid.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Field + ": " + fe.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *Errors) Add(field, message string) {
+	*e = append(*e, FieldError{Field: field, Message: message})
+}
+
+// Check adds message for field unless it is empty. It is meant to be
+// used with the rule functions below, which return "" for valid input.
+func (e *Errors) Check(field, message string) {
+	if message != "" {
+		e.Add(field, message)
+	}
+}
+
+// Username returns a message describing why username is invalid, or "" if it is valid.
+// Usernames are 3-50 ASCII letters, digits, '.', '_' or '-', starting with a letter or digit.
+func Username(username string) string {
+	if username == "" {
+		return "is required"
+	}
+	if len(username) < UsernameMinLength || len(username) > UsernameMaxLength {
+		return fmt.Sprintf("must be between %d and %d characters", UsernameMinLength, UsernameMaxLength)
+	}
+	for i, r := range username {
+		isAlnum := r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r))
+		if i == 0 && !isAlnum {
+			return "must start with a letter or digit"
+		}
+		if !isAlnum && r != '.' && r != '_' && r != '-' {
+			return "may only contain letters, digits, '.', '_' and '-'"
+		}
+	}
+	return ""
+}
+
+// Email returns a message describing why email is invalid, or "" if it is valid.
+// The address must be a bare RFC 5322 addr-spec without display name.
+func Email(email string) string {
+	if email == "" {
+		return "is required"
+	}
+	if len(email) > EmailMaxLength {
+		return fmt.Sprintf("must be at most %d characters", EmailMaxLength)
+	}
+	// ParseAddress also accepts "Name <addr>"; only the bare form is allowed.
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || strings.ContainsAny(email, "<>") {
+		return "must be a valid email address"
+	}
+	return ""
+}
+
+// NormalizeFullName trims surrounding whitespace and converts the name to
+// Unicode NFC so visually identical names are stored identically.
+func NormalizeFullName(fullName string) string {
+	return norm.NFC.String(strings.TrimSpace(fullName))
+}
+
+// FullName returns a message describing why a normalized full name is invalid,
+// or "" if it is valid. An empty full name is allowed.
+func FullName(fullName string) string {
+	if !utf8.ValidString(fullName) {
+		return "must be valid UTF-8"
+	}
+	if utf8.RuneCountInString(fullName) > FullNameMaxLength {
+		return fmt.Sprintf("must be at most %d characters", FullNameMaxLength)
+	}
+	for _, r := range fullName {
+		if unicode.IsControl(r) {
+			return "must not contain control characters"
+		}
+	}
+	return ""
+}
+
+// ExternalSource returns a message describing why the name of an upstream
+// system is invalid, or "" if it is valid. Sources are 1-50 lowercase ASCII
+// letters, digits, '_' or '-', starting with a letter, e.g. "hr" or "ldap".
+func ExternalSource(source string) string {
+	if source == "" {
+		return "is required"
+	}
+	if len(source) > ExternalSourceMaxLength {
+		return fmt.Sprintf("must be at most %d characters", ExternalSourceMaxLength)
+	}
+	for i, r := range source {
+		isLower := r >= 'a' && r <= 'z'
+		if i == 0 && !isLower {
+			return "must start with a lowercase letter"
+		}
+		if !isLower && (r < '0' || r > '9') && r != '_' && r != '-' {
+			return "may only contain lowercase letters, digits, '_' and '-'"
+		}
+	}
+	return ""
+}
+
+// ExternalID returns a message describing why an identifier assigned by an
+// upstream system is invalid, or "" if it is valid.
+func ExternalID(id string) string {
+	if id == "" {
+		return "is required"
+	}
+	if len(id) > ExternalIDMaxLength {
+		return fmt.Sprintf("must be at most %d characters", ExternalIDMaxLength)
+	}
+	if !utf8.ValidString(id) {
+		return "must be valid UTF-8"
+	}
+	for _, r := range id {
+		if unicode.IsControl(r) {
+			return "must not contain control characters"
+		}
+	}
+	return ""
+}
+
+// Password returns a message describing why password is invalid for the
+// user with the given username and email, or "" if it is valid. Passwords
+// are 12-128 characters and must not contain the username or the local part
+// of the email address. Any other characters are allowed, including spaces,
+// so that passphrases work.
+func Password(password, username, email string) string {
+	if password == "" {
+		return "is required"
+	}
+	if !utf8.ValidString(password) {
+		return "must be valid UTF-8"
+	}
+	if utf8.RuneCountInString(password) < PasswordMinLength || len(password) > PasswordMaxLength {
+		return fmt.Sprintf("must be between %d and %d characters", PasswordMinLength, PasswordMaxLength)
+	}
+	for _, r := range password {
+		if unicode.IsControl(r) {
+			return "must not contain control characters"
+		}
+	}
+	lower := strings.ToLower(password)
+	local, _, _ := strings.Cut(email, "@")
+	for _, name := range []string{username, local} {
+		if len(name) >= UsernameMinLength && strings.Contains(lower, strings.ToLower(name)) {
+			return "must not contain the username or email address"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,120 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUsername(t *testing.T) {
+	valid := []string{"jdoe", "john.doe", "j_doe-42", "007"}
+	for _, username := range valid {
+		if msg := Username(username); msg != "" {
+			t.Errorf("%q: expected valid, got %q", username, msg)
+		}
+	}
+
+	invalid := []string{"", "jd", "_jdoe", "john doe", "jöhn", strings.Repeat("a", 51)}
+	for _, username := range invalid {
+		if msg := Username(username); msg == "" {
+			t.Errorf("%q: expected invalid", username)
+		}
+	}
+}
+
+func TestEmail(t *testing.T) {
+	valid := []string{"jdoe@example.com", "first.last+tag@sub.example.org", `"quoted name"@example.com`}
+	for _, email := range valid {
+		if msg := Email(email); msg != "" {
+			t.Errorf("%q: expected valid, got %q", email, msg)
+		}
+	}
+
+	invalid := []string{"", "jdoe", "jdoe@", "@example.com", "John <jdoe@example.com>", "a b@example.com", strings.Repeat("a", 95) + "@x.com"}
+	for _, email := range invalid {
+		if msg := Email(email); msg == "" {
+			t.Errorf("%q: expected invalid", email)
+		}
+	}
+}
+
+func TestFullName(t *testing.T) {
+	// "e" followed by a combining acute accent normalizes to a single "é"
+	normalized := NormalizeFullName("  René Descartes ")
+	if normalized != "Ren\u00e9 Descartes" {
+		t.Errorf("expected NFC normalized name, got %q", normalized)
+	}
+
+	if msg := FullName(normalized); msg != "" {
+		t.Errorf("expected valid, got %q", msg)
+	}
+	if msg := FullName(""); msg != "" {
+		t.Errorf("expected empty full name to be valid, got %q", msg)
+	}
+	if msg := FullName(strings.Repeat("é", 101)); msg == "" {
+		t.Error("expected too long full name to be invalid")
+	}
+	if msg := FullName("John\x00Doe"); msg == "" {
+		t.Error("expected control characters to be invalid")
+	}
+}
+
+func TestExternalSource(t *testing.T) {
+	valid := []string{"hr", "ldap", "crm_2", "work-day"}
+	for _, source := range valid {
+		if msg := ExternalSource(source); msg != "" {
+			t.Errorf("%q: expected valid, got %q", source, msg)
+		}
+	}
+
+	invalid := []string{"", "HR", "2fa", "-hr", "h r", strings.Repeat("a", 51)}
+	for _, source := range invalid {
+		if msg := ExternalSource(source); msg == "" {
+			t.Errorf("%q: expected invalid", source)
+		}
+	}
+}
+
+func TestExternalID(t *testing.T) {
+	valid := []string{"42", "E-0001", "CN=John Doe,OU=People", "ümlaut"}
+	for _, id := range valid {
+		if msg := ExternalID(id); msg != "" {
+			t.Errorf("%q: expected valid, got %q", id, msg)
+		}
+	}
+
+	invalid := []string{"", "a\x00b", strings.Repeat("a", 256)}
+	for _, id := range invalid {
+		if msg := ExternalID(id); msg == "" {
+			t.Errorf("%q: expected invalid", id)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	var errs Errors
+	errs.Check("username", Username("ok_user"))
+	errs.Check("email", Email("nope"))
+	if len(errs) != 1 || errs[0].Field != "email" {
+		t.Fatalf("expected a single email error, got %v", errs)
+	}
+	if errs.Error() != "email: must be a valid email address" {
+		t.Errorf("unexpected message %q", errs.Error())
+	}
+}
+
+func TestPassword(t *testing.T) {
+	valid := []string{"correct horse battery", "Tr0ub4dor&3xyz", strings.Repeat("é", 12), strings.Repeat("a", 128)}
+	for _, password := range valid {
+		if msg := Password(password, "jdoe", "john.doe@example.com"); msg != "" {
+			t.Errorf("%q: expected valid, got %q", password, msg)
+		}
+	}
+
+	invalid := []string{"", "short pass", strings.Repeat("é", 65), strings.Repeat("a", 129),
+		"tab\tseparated words", "\xff\xfe invalid utf8", "my name is JDoe!!", "john.doe is my name"}
+	for _, password := range invalid {
+		if msg := Password(password, "jdoe", "john.doe@example.com"); msg == "" {
+			t.Errorf("%q: expected invalid", password)
+		}
+	}
+}